@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+// how long the database ping of the check mode may take
+const checkTimeout = 5 * time.Second
+
+// errSkipped marks checks which were not run because an earlier check failed
+var errSkipped = errors.New("skipped")
+
+// checkResult is the outcome of a single startup check
+type checkResult struct {
+	Name string
+	Err  error
+}
+
+// runChecks loads the configuration of the profile from dir, resolves secrets and pings the database
+// it never mutates the global config, the environment or the database
+func runChecks(ctx context.Context, dir string, profile string) []checkResult {
+	v := viper.New()
+	results := []checkResult{{Name: "config", Err: readConfig(v, dir, profile)}}
+	if results[0].Err != nil {
+		return append(results,
+			checkResult{Name: "required keys", Err: errSkipped},
+			checkResult{Name: "secrets", Err: errSkipped},
+			checkResult{Name: "database", Err: errSkipped},
+		)
+	}
+
+	results = append(results, checkResult{Name: "required keys", Err: validateConfig(v)})
+
+	secretsCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	dbCreds, _, err := loadSecrets(secretsCtx, v, dir)
+	cancel()
+	results = append(results, checkResult{Name: "secrets", Err: err})
+	if err != nil {
+		return append(results, checkResult{Name: "database", Err: errSkipped})
+	}
+
+	// settings missing from both the secret and the config fail the database check
+	dbConfig, err := databaseConfig(v, dbCreds)
+	if err != nil {
+		return append(results, checkResult{Name: "database", Err: err})
+	}
+	return append(results, checkResult{Name: "database", Err: pingDatabase(ctx, dbConfig.DSN())})
+}
+
+// pingDatabase opens a connection with the given dsn and pings it
+func pingDatabase(ctx context.Context, dsn string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// printReport writes one line per check and returns the exit status, 1 if any check did not pass
+func printReport(w io.Writer, results []checkResult) int {
+	status := 0
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			fmt.Fprintf(w, "%-14s ok\n", result.Name)
+		case errors.Is(result.Err, errSkipped):
+			fmt.Fprintf(w, "%-14s skipped\n", result.Name)
+			status = 1
+		default:
+			fmt.Fprintf(w, "%-14s failed: %v\n", result.Name, result.Err)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// version of the build, set with -ldflags "-X main.version=..."
+var version = "dev"
+
+// options are the command-line flags which are not config keys
+type options struct {
+	configDir string
+	check     bool
+	version   bool
+	// flags overriding config keys, bound into viper by bindFlags
+	flags *pflag.FlagSet
+}
+
+// config keys overridden by flags
+var flagKeys = map[string]string{
+	"port":      "server.port",
+	"log-level": "log.level",
+}
+
+// parseFlags parses the command-line arguments, unknown flags are an error
+// --help prints the usage to out and returns pflag.ErrHelp
+func parseFlags(args []string, out io.Writer) (options, error) {
+	var opts options
+	flags := pflag.NewFlagSet("berliner", pflag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.StringVar(&opts.configDir, "config-dir", defaultConfigDir, "directory with config.yaml, the profile files and .env")
+	flags.BoolVar(&opts.check, "check", false, "validate configuration, secrets and database connectivity, then exit without serving")
+	flags.BoolVar(&opts.version, "version", false, "print the version and exit")
+	flags.Int("port", 0, "port of the HTTP server, overrides server.port")
+	flags.String("log-level", "", "debug, info, warn or error, overrides log.level")
+	// --config is accepted as a shorter --config-dir
+	flags.SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "config" {
+			name = "config-dir"
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	if err := flags.Parse(args); err != nil {
+		return options{}, err
+	}
+	opts.flags = flags
+	return opts, nil
+}
+
+// bindFlags lets the flags which were passed override their config keys
+// flags take precedence over environment variables and the config files
+func bindFlags(v *viper.Viper, opts options) error {
+	for name, key := range flagKeys {
+		if err := v.BindPFlag(key, opts.flags.Lookup(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,113 +2,523 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net/url"
+	"log/slog"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/I1Asyl/berliner_backend/pkg/config"
+	"github.com/I1Asyl/berliner_backend/pkg/repository"
 	"github.com/I1Asyl/berliner_backend/pkg/secrets"
-	"github.com/joho/godotenv"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
+// default directory with config.yaml and .env files
+const defaultConfigDir = "configs/"
+
+// environment variable selecting the config profile, config.<profile>.yaml is merged over config.yaml
+const profileEnv = "APP_ENV"
+
+// how often the revoked tokens which expired are deleted
+const revokedTokensPruneInterval = time.Hour
+
 func main() {
+	opts, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, pflag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if opts.version {
+		fmt.Println(version)
+		os.Exit(0)
+	}
+	profile := os.Getenv(profileEnv)
+
+	if opts.check {
+		os.Exit(printReport(os.Stdout, runChecks(context.Background(), opts.configDir, profile)))
+	}
+
+	if profile != "" {
+		log.Printf("using config profile %s from %s", profile, opts.configDir)
+	} else {
+		log.Printf("using no config profile, %s is not set", profileEnv)
+	}
+
 	fmt.Println("before config")
 
-	err := setupConfigs()
+	if err := bindFlags(viper.GetViper(), opts); err != nil {
+		log.Fatalf(err.Error())
+	}
+	cfg, err := setupConfigs(opts.configDir, profile)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	level, _ := cfg.Log.SlogLevel()
+	slog.SetLogLoggerLevel(level)
 
-	fmt.Println(os.Getenv("dsn"))
+	// reload the hot keys when the config files change or on SIGHUP
+	watcher, err := config.NewWatcher(cfg, func() (config.Config, error) {
+		return readConfigFiles(opts, profile)
+	})
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	watcher.Subscribe(func(cfg config.Config) {
+		level, _ := cfg.Log.SlogLevel()
+		slog.SetLogLoggerLevel(level)
+	})
+	go func() {
+		if err := watcher.Run(context.Background(), opts.configDir); err != nil {
+			log.Printf("warning: config files are not watched: %v", err)
+		}
+	}()
 
 	// Create config for Wire
-	config := Config{
-		DSN: os.Getenv("dsn"),
+	wireConfig := Config{
+		App:     cfg,
+		DSN:     cfg.DB.DSN(),
+		Watcher: watcher,
+	}
+	// follow rotations of the database secret
+	if secretsBackend(viper.GetViper()) != secretsBackendEnv {
+		wireConfig.DSNSource = func(ctx context.Context) (string, error) {
+			return loadDSN(ctx, viper.GetViper(), opts.configDir)
+		}
+		wireConfig.Rotation = repository.RotationConfig{
+			FailureThreshold: viper.GetInt("db.rotation.failure_threshold"),
+			PollInterval:     viper.GetDuration("db.rotation.poll_interval"),
+		}
 	}
 
 	// Initialize the app using Wire
-	router, err := InitializeApp(config)
+	router, cleanup, err := InitializeApp(wireConfig)
 	fmt.Println(router)
 	if err != nil {
 		log.Fatalf("Failed to initialize app: %v", err)
 	}
+	defer cleanup()
+
+	addr := fmt.Sprintf(":%d", cfg.Server.Port)
+	if cfg.Server.TLS.Enabled {
+		router.RunTLS(addr, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	} else {
+		router.Run(addr)
+	}
+}
+
+// pruneRevokedTokens deletes the revoked tokens which expired at the interval until ctx is done
+func pruneRevokedTokens(ctx context.Context, services *services.Services, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := services.PruneRevokedTokens(); err != nil {
+			log.Printf("warning: failed to prune revoked tokens: %v", err)
+		}
+	}
+}
+
+// seedAdmins gives the admin role to the registered users of admin.usernames when there is no admin yet
+func seedAdmins(services *services.Services) {
+	seeded, err := services.SeedAdmins()
+	if err != nil {
+		log.Printf("warning: failed to seed admins: %v", err)
+		return
+	}
+	for _, username := range seeded {
+		log.Printf("gave the admin role to %s", username)
+	}
+}
 
-	router.Run()
+// setupConfigs reads the config files and loads the secrets into the config of the application
+func setupConfigs(configDir string, profile string) (config.Config, error) {
+	if err := readConfig(viper.GetViper(), configDir, profile); err != nil {
+		return config.Config{}, err
+	}
+	for _, key := range config.UnknownKeys(viper.GetViper()) {
+		log.Printf("warning: unknown config key %s", key)
+	}
+	if err := config.CheckTypes(viper.GetViper()); err != nil {
+		return config.Config{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	cfg := config.FromViper(viper.GetViper())
+
+	dbCreds, jwtSecret, err := loadSecrets(context.Background(), viper.GetViper(), configDir)
+	if err != nil {
+		return config.Config{}, err
+	}
+	if cfg.DB, err = databaseConfig(viper.GetViper(), dbCreds); err != nil {
+		return config.Config{}, err
+	}
+	cfg.JWT.Secret = jwtSecret
+	return cfg, nil
+}
+
+// readConfigFiles reads the config files into a new viper without loading the secrets, used to reload the config
+func readConfigFiles(opts options, profile string) (config.Config, error) {
+	v := viper.New()
+	if err := bindFlags(v, opts); err != nil {
+		return config.Config{}, err
+	}
+	if err := readConfig(v, opts.configDir, profile); err != nil {
+		return config.Config{}, err
+	}
+	if err := config.CheckTypes(v); err != nil {
+		return config.Config{}, err
+	}
+	return config.FromViper(v), nil
 }
 
-func setupConfigs() error {
-	viper.SetConfigName("config")
-	viper.AddConfigPath("configs/")
-	if err := viper.ReadInConfig(); err != nil {
+// readConfig reads config.yaml from the given directory and merges config.<profile>.yaml over it
+// maps are merged key by key while lists and other values of the profile replace the base ones
+// environment variables prefixed with BERLINER_ override both files
+func readConfig(v *viper.Viper, dir string, profile string) error {
+	config.BindEnv(v)
+	v.SetConfigName("config")
+	v.AddConfigPath(dir)
+	if err := v.ReadInConfig(); err != nil {
 		return err
 	}
+	if profile == "" {
+		return nil
+	}
 
-	var dbPassword, jwtSecret string
+	v.SetConfigFile(filepath.Join(dir, "config."+profile+".yaml"))
+	if err := v.MergeInConfig(); err != nil {
+		return fmt.Errorf("failed to read config profile %s: %w", profile, err)
+	}
+	return nil
+}
 
-	if viper.GetBool("aws.enabled") {
-		// Load secrets from AWS Secrets Manager
-		awsRegion := viper.GetString("aws.region")
-		dbPasswordSecretName := viper.GetString("aws.secrets.db_password")
-		jwtSecretName := viper.GetString("aws.secrets.jwt_secret")
+// secret backends which can be selected with secrets.backend
+const (
+	secretsBackendAWS   = "aws"
+	secretsBackendVault = "vault"
+	secretsBackendEnv   = "env"
+	secretsBackendFile  = "file"
+)
 
-		secretsClient, err := secrets.NewClient(awsRegion)
-		if err != nil {
-			return fmt.Errorf("failed to create secrets client: %w", err)
-		}
+// defaults of the file secrets backend, where docker mounts secrets
+const (
+	defaultSecretsDir     = "/run/secrets"
+	defaultDBPasswordFile = "db_password"
+	defaultJWTSecretFile  = "jwt_secret"
+)
 
-		ctx := context.Background()
+// secretsBackend returns secrets.backend
+// when it is not set, aws is used if aws.enabled is set and the environment otherwise
+func secretsBackend(v *viper.Viper) string {
+	if backend := v.GetString("secrets.backend"); backend != "" {
+		return backend
+	}
+	if v.GetBool("aws.enabled") {
+		return secretsBackendAWS
+	}
+	return secretsBackendEnv
+}
 
-		dbPassword, err = secretsClient.GetSecret(ctx, dbPasswordSecretName)
-		if err != nil {
-			return fmt.Errorf("failed to get DB_PASSWORD: %w", err)
+// validateConfig checks that every required key is set and returns all missing keys at once
+// the db keys are only required with the env and file backends, the other backends can read them from the database secret
+func validateConfig(v *viper.Viper) error {
+	var required []string
+	backend := secretsBackend(v)
+	switch backend {
+	case secretsBackendEnv, secretsBackendFile:
+		required = append(required, "db.user", "db.address", "db.name")
+	case secretsBackendAWS:
+		required = append(required, "aws.region")
+	case secretsBackendVault:
+		required = append(required, "vault.address")
+		switch v.GetString("vault.auth") {
+		case secrets.VaultAuthKubernetes:
+			required = append(required, "vault.kubernetes_role")
+		case secrets.VaultAuthAppRole:
+			required = append(required, "vault.approle_role_id")
+		}
+	}
+	var errs []error
+	for _, key := range required {
+		if v.GetString(key) == "" {
+			errs = append(errs, fmt.Errorf("%s is not set", key))
 		}
+	}
 
-		jwtSecret, err = secretsClient.GetSecret(ctx, jwtSecretName)
-		if err != nil {
-			return fmt.Errorf("failed to get JWT_SECRET: %w", err)
+	switch backend {
+	case secretsBackendAWS:
+		switch name := v.GetString("aws.secrets_backend"); name {
+		case "", secrets.BackendSecretsManager, secrets.BackendSSM:
+		default:
+			errs = append(errs, fmt.Errorf("aws.secrets_backend: %w: %s", secrets.ErrUnknownBackend, name))
 		}
-	} else {
-		// Load secrets from local .env file
-		if err := godotenv.Load("configs/.env"); err != nil {
-			return fmt.Errorf("failed to load .env file: %w", err)
+	case secretsBackendVault:
+		switch auth := v.GetString("vault.auth"); auth {
+		case "", secrets.VaultAuthToken, secrets.VaultAuthKubernetes, secrets.VaultAuthAppRole:
+		default:
+			errs = append(errs, fmt.Errorf("vault.auth: %w: %s", secrets.ErrUnknownVaultAuth, auth))
 		}
-
-		dbPassword = os.Getenv("DB_PASSWORD")
-		if dbPassword == "" {
-			return fmt.Errorf("DB_PASSWORD is not set in .env file")
+	case secretsBackendEnv, secretsBackendFile:
+	default:
+		errs = append(errs, fmt.Errorf("secrets.backend must be aws, vault, env or file: %s", backend))
+	}
+	if backend == secretsBackendAWS || backend == secretsBackendVault {
+		for _, key := range []string{backend + ".secrets.db_password", backend + ".secrets.jwt_secret"} {
+			if _, err := secretReference(v, key); err != nil {
+				errs = append(errs, err)
+			}
 		}
+	}
+	errs = append(errs, config.CheckTypes(v))
+	return errors.Join(errs...)
+}
+
+// secretRef points to a secret in the secrets backend and optionally to a field of a JSON secret
+type secretRef struct {
+	Name  string `mapstructure:"name"`
+	Field string `mapstructure:"field"`
+}
 
-		jwtSecret = os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			return fmt.Errorf("JWT_SECRET is not set in .env file")
+// secretReference reads the secret reference at key
+// which is either the plain secret name or a map with name and an optional field
+func secretReference(v *viper.Viper, key string) (secretRef, error) {
+	var ref secretRef
+	switch value := v.Get(key).(type) {
+	case nil:
+	case string:
+		ref.Name = value
+	default:
+		if err := v.UnmarshalKey(key, &ref); err != nil {
+			return ref, fmt.Errorf("%s is not a valid secret reference: %w", key, err)
 		}
 	}
+	if ref.Name == "" {
+		return ref, fmt.Errorf("%s is not set", key)
+	}
+	return ref, nil
+}
 
-	// Set environment variables for use by other packages
-	os.Setenv("DB_PASSWORD", dbPassword)
-	os.Setenv("JWT_SECRET", jwtSecret)
+// resolveSecret returns the referenced field of the secret, or the secret itself when no field is given
+func resolveSecret(ctx context.Context, client secrets.Provider, ref secretRef) (string, error) {
+	if ref.Field == "" {
+		return client.GetSecret(ctx, ref.Name)
+	}
+	return client.GetSecretField(ctx, ref.Name, ref.Field)
+}
 
-	username := viper.GetString("db.user")
-	address := viper.GetString("db.address")
-	dbname := viper.GetString("db.name")
-	sslmode := viper.GetString("db.sslmode")
+// newSecretsProvider creates the client of the aws or vault secrets backend
+func newSecretsProvider(ctx context.Context, v *viper.Viper, backend string) (secrets.Provider, error) {
+	switch backend {
+	case secretsBackendAWS:
+		return secrets.NewProvider(v.GetString("aws.secrets_backend"), v.GetString("aws.region"), secretsOptions(v, "aws")...)
+	case secretsBackendVault:
+		return secrets.NewVaultClient(ctx, secrets.VaultConfig{
+			Address:             v.GetString("vault.address"),
+			Mount:               v.GetString("vault.mount"),
+			AuthMethod:          v.GetString("vault.auth"),
+			Token:               os.Getenv("VAULT_TOKEN"),
+			KubernetesRole:      v.GetString("vault.kubernetes_role"),
+			KubernetesMount:     v.GetString("vault.kubernetes_mount"),
+			KubernetesTokenPath: v.GetString("vault.kubernetes_token_path"),
+			AppRoleRoleId:       v.GetString("vault.approle_role_id"),
+			AppRoleSecretId:     os.Getenv("VAULT_SECRET_ID"),
+			AppRoleMount:        v.GetString("vault.approle_mount"),
+		}, secretsOptions(v, "vault")...)
+	}
+	return nil, fmt.Errorf("secrets.backend must be aws, vault, env or file: %s", backend)
+}
 
-	u := &url.URL{
-		Scheme: "postgres",
-		User:   url.UserPassword(username, dbPassword),
-		Host:   address,
-		Path:   dbname,
+// loadSecrets returns the database credentials and jwt secret from the backend selected by secrets.backend
+// the backend client is closed when ctx is done, so a Vault token keeps being renewed until then
+func loadSecrets(ctx context.Context, v *viper.Viper, dir string) (secrets.DatabaseCredentials, string, error) {
+	backend := secretsBackend(v)
+	switch backend {
+	case secretsBackendEnv:
+		dbPassword, jwtSecret, err := loadEnvSecrets(dir)
+		return secrets.DatabaseCredentials{Password: dbPassword}, jwtSecret, err
+	case secretsBackendFile:
+		dbPassword, jwtSecret, err := loadFileSecrets(ctx, v)
+		return secrets.DatabaseCredentials{Password: dbPassword}, jwtSecret, err
 	}
 
-	q := u.Query()
-	q.Set("sslmode", sslmode)
-	q.Set("connect_timeout", "10") // Fail after 10 seconds instead of hanging
-	u.RawQuery = q.Encode()
+	dbPasswordRef, err := secretReference(v, backend+".secrets.db_password")
+	if err != nil {
+		return secrets.DatabaseCredentials{}, "", err
+	}
+	jwtSecretRef, err := secretReference(v, backend+".secrets.jwt_secret")
+	if err != nil {
+		return secrets.DatabaseCredentials{}, "", err
+	}
 
-	dsn := u.String()
+	secretsClient, err := newSecretsProvider(ctx, v, backend)
+	if err != nil {
+		return secrets.DatabaseCredentials{}, "", fmt.Errorf("failed to create secrets client: %w", err)
+	}
+	context.AfterFunc(ctx, func() {
+		secretsClient.Close()
+	})
 
-	os.Setenv("dsn", dsn)
+	// fetch both secrets in a single batch, they are read from the cache below
+	if _, err := secretsClient.GetSecretStrings(ctx, []string{dbPasswordRef.Name, jwtSecretRef.Name}); err != nil {
+		return secrets.DatabaseCredentials{}, "", err
+	}
 
-	return nil
+	dbCreds, err := resolveDatabaseCredentials(ctx, secretsClient, dbPasswordRef)
+	if err != nil {
+		return secrets.DatabaseCredentials{}, "", fmt.Errorf("failed to get DB_PASSWORD: %w", err)
+	}
+
+	jwtSecret, err := resolveSecret(ctx, secretsClient, jwtSecretRef)
+	if err != nil {
+		return secrets.DatabaseCredentials{}, "", fmt.Errorf("failed to get JWT_SECRET: %w", err)
+	}
+	return dbCreds, jwtSecret, nil
+}
+
+// loadDSN loads the database secret and builds the DSN from it
+// the secrets client is closed when ctx is done
+func loadDSN(ctx context.Context, v *viper.Viper, dir string) (string, error) {
+	dbCreds, _, err := loadSecrets(ctx, v, dir)
+	if err != nil {
+		return "", err
+	}
+	dbConfig, err := databaseConfig(v, dbCreds)
+	if err != nil {
+		return "", err
+	}
+	return dbConfig.DSN(), nil
+}
+
+// resolveDatabaseCredentials reads the whole credential set from the secret when no field is given
+// with a field only the password is read from it
+func resolveDatabaseCredentials(ctx context.Context, client secrets.Provider, ref secretRef) (secrets.DatabaseCredentials, error) {
+	if ref.Field == "" {
+		return client.GetDatabaseCredentials(ctx, ref.Name)
+	}
+	password, err := client.GetSecretField(ctx, ref.Name, ref.Field)
+	return secrets.DatabaseCredentials{Password: password}, err
+}
+
+// databaseConfig merges the credentials from the secret with the db section of the config
+// values from the secret take precedence, so a rotation which changes the username keeps working
+func databaseConfig(v *viper.Viper, creds secrets.DatabaseCredentials) (config.DB, error) {
+	settings := config.FromViper(v).DB
+	settings.Password = creds.Password
+	if creds.Username != "" {
+		settings.User = creds.Username
+	}
+	if creds.Host != "" {
+		settings.Address = creds.Host
+	}
+	if settings.Address != "" && creds.Port != 0 {
+		host := settings.Address
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		settings.Address = net.JoinHostPort(host, strconv.Itoa(creds.Port))
+	}
+	if creds.DBName != "" {
+		settings.Name = creds.DBName
+	}
+
+	var errs []error
+	for _, field := range []struct{ value, name, key string }{
+		{settings.User, "username", "db.user"},
+		{settings.Password, "password", ""},
+		{settings.Address, "host", "db.address"},
+		{settings.Name, "dbname", "db.name"},
+	} {
+		switch {
+		case field.value != "":
+		case field.key == "":
+			errs = append(errs, fmt.Errorf("database %s is not set in the secret", field.name))
+		default:
+			errs = append(errs, fmt.Errorf("database %s is not set in the secret or %s", field.name, field.key))
+		}
+	}
+	return settings, errors.Join(errs...)
+}
+
+// loadEnvSecrets returns the database password and jwt secret from the environment or the local .env file
+// DB_PASSWORD_FILE and JWT_SECRET_FILE name files which take precedence over both
+func loadEnvSecrets(dir string) (string, string, error) {
+	return loadSourceSecrets(context.Background(), secrets.NewEnvSource(filepath.Join(dir, ".env")), "DB_PASSWORD", "JWT_SECRET")
+}
+
+// loadFileSecrets returns the database password and jwt secret from the files of file.dir
+func loadFileSecrets(ctx context.Context, v *viper.Viper) (string, string, error) {
+	dir, dbPasswordFile, jwtSecretFile := v.GetString("file.dir"), v.GetString("file.secrets.db_password"), v.GetString("file.secrets.jwt_secret")
+	if dir == "" {
+		dir = defaultSecretsDir
+	}
+	if dbPasswordFile == "" {
+		dbPasswordFile = defaultDBPasswordFile
+	}
+	if jwtSecretFile == "" {
+		jwtSecretFile = defaultJWTSecretFile
+	}
+	return loadSourceSecrets(ctx, secrets.NewFileSource(dir), dbPasswordFile, jwtSecretFile)
+}
+
+// loadSourceSecrets returns the database password and jwt secret of the given names from the source
+func loadSourceSecrets(ctx context.Context, source secrets.Source, dbPasswordName, jwtSecretName string) (string, string, error) {
+	dbPassword, err := source.Get(ctx, dbPasswordName)
+	if err != nil {
+		return "", "", err
+	}
+	jwtSecret, err := source.Get(ctx, jwtSecretName)
+	if err != nil {
+		return "", "", err
+	}
+	return dbPassword, jwtSecret, nil
+}
+
+// secretsOptions returns the secrets client options set in the aws or vault section of the config
+func secretsOptions(v *viper.Viper, section string) []secrets.Option {
+	var opts []secrets.Option
+	if section == "aws" && v.GetString("aws.endpoint") != "" {
+		opts = append(opts, secrets.WithEndpoint(v.GetString("aws.endpoint")))
+	}
+	if section == "aws" && v.GetString("aws.assume_role_arn") != "" {
+		opts = append(opts, secrets.WithAssumeRole(v.GetString("aws.assume_role_arn"), v.GetString("aws.external_id")))
+	}
+	if v.IsSet(section + ".secrets_cache_ttl") {
+		opts = append(opts, secrets.WithCacheTTL(v.GetDuration(section+".secrets_cache_ttl")))
+	}
+	if v.GetBool(section + ".secrets_serve_stale") {
+		opts = append(opts, secrets.WithStaleOnError())
+	}
+	if v.IsSet(section + ".secrets_retry") {
+		policy := secrets.DefaultRetryPolicy
+		retry := v.Sub(section + ".secrets_retry")
+		if retry.IsSet("max_attempts") {
+			policy.MaxAttempts = retry.GetInt("max_attempts")
+		}
+		if retry.IsSet("base_delay") {
+			policy.BaseDelay = retry.GetDuration("base_delay")
+		}
+		if retry.IsSet("max_delay") {
+			policy.MaxDelay = retry.GetDuration("max_delay")
+		}
+		if retry.IsSet("attempt_timeout") {
+			policy.AttemptTimeout = retry.GetDuration("attempt_timeout")
+		}
+		opts = append(opts, secrets.WithRetryPolicy(policy))
+	}
+	return opts
 }
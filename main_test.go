@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/I1Asyl/berliner_backend/pkg/config"
+	"github.com/I1Asyl/berliner_backend/pkg/repository"
+	"github.com/I1Asyl/berliner_backend/pkg/secrets"
+	"github.com/ory/dockertest/v3"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// writes config.yaml and .env files into a new temporary directory
+func writeConfigDir(t *testing.T, config string, env string) string {
+	dir := t.TempDir()
+	if config != "" {
+		if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(config), 0600); err != nil {
+			t.Fatalf("Could not write config: %s", err)
+		}
+	}
+	if env != "" {
+		if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(env), 0600); err != nil {
+			t.Fatalf("Could not write .env: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestRunChecks(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Could not construct pool: %s", err)
+	}
+	resource, err := pool.Run("postgres", "16-alpine", []string{
+		"POSTGRES_PASSWORD=secret",
+		"POSTGRES_USER=postgres",
+		"POSTGRES_DB=berliner",
+	})
+	if err != nil {
+		t.Fatalf("Could not start resource: %s", err)
+	}
+	defer pool.Purge(resource)
+
+	address := "localhost:" + resource.GetPort("5432/tcp")
+	if err := pool.Retry(func() error {
+		return pingDatabase(context.Background(), fmt.Sprintf("postgres://postgres:secret@%s/berliner?sslmode=disable", address))
+	}); err != nil {
+		t.Fatalf("Could not connect to database: %s", err)
+	}
+
+	validConfig := fmt.Sprintf("db:\n  user: postgres\n  address: %s\n  name: berliner\n  sslmode: disable\naws:\n  enabled: false\n", address)
+	validEnv := "DB_PASSWORD=secret\nJWT_SECRET=randomJWTSecret\n"
+
+	testTable := []struct {
+		name     string
+		config   string
+		env      string
+		expected map[string]bool
+		status   int
+	}{
+		{
+			name:     "valid",
+			config:   validConfig,
+			env:      validEnv,
+			expected: map[string]bool{"config": true, "required keys": true, "secrets": true, "database": true},
+			status:   0,
+		},
+		{
+			name:     "wrong password",
+			config:   validConfig,
+			env:      "DB_PASSWORD=wrong\nJWT_SECRET=randomJWTSecret\n",
+			expected: map[string]bool{"config": true, "required keys": true, "secrets": true, "database": false},
+			status:   1,
+		},
+		{
+			name:     "missing database name",
+			config:   fmt.Sprintf("db:\n  user: postgres\n  address: %s\n  sslmode: disable\naws:\n  enabled: false\n", address),
+			env:      validEnv,
+			expected: map[string]bool{"config": true, "required keys": false, "secrets": true, "database": false},
+			status:   1,
+		},
+		{
+			name:     "missing env file",
+			config:   validConfig,
+			expected: map[string]bool{"config": true, "required keys": true, "secrets": false, "database": false},
+			status:   1,
+		},
+		{
+			name:     "missing config file",
+			env:      validEnv,
+			expected: map[string]bool{"config": false, "required keys": false, "secrets": false, "database": false},
+			status:   1,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			results := runChecks(context.Background(), writeConfigDir(t, testCase.config, testCase.env), "")
+			for _, result := range results {
+				if passed := result.Err == nil; passed != testCase.expected[result.Name] {
+					t.Errorf("Expected %s check passed to be %v, got error: %v", result.Name, testCase.expected[result.Name], result.Err)
+				}
+			}
+			var out bytes.Buffer
+			if status := printReport(&out, results); status != testCase.status {
+				t.Errorf("Expected status %v, got %v, report:\n%s", testCase.status, status, out.String())
+			}
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	base := "db:\n  user: postgres\n  address: localhost:5432\n  name: berliner\naws:\n  enabled: false\n  region: eu-central-1\nadmin:\n  usernames: [alice, bob]\n"
+	prod := "db:\n  address: db.internal:5432\naws:\n  enabled: true\nadmin:\n  usernames: [carol]\n"
+
+	testTable := []struct {
+		name     string
+		profile  string
+		env      map[string]string
+		expected map[string]interface{}
+		isError  bool
+	}{
+		{
+			name:    "base only",
+			profile: "",
+			expected: map[string]interface{}{
+				"db.address":      "localhost:5432",
+				"aws.enabled":     false,
+				"admin.usernames": []string{"alice", "bob"},
+			},
+		},
+		{
+			name:    "profile merged over base",
+			profile: "prod",
+			expected: map[string]interface{}{
+				"db.user":         "postgres",
+				"db.address":      "db.internal:5432",
+				"aws.enabled":     true,
+				"aws.region":      "eu-central-1",
+				"admin.usernames": []string{"carol"},
+			},
+		},
+		{
+			name:    "environment over profile",
+			profile: "prod",
+			env:     map[string]string{"BERLINER_DB_ADDRESS": "replica:5432", "BERLINER_AWS_ENABLED": "false"},
+			expected: map[string]interface{}{
+				"db.user":     "postgres",
+				"db.address":  "replica:5432",
+				"aws.enabled": false,
+			},
+		},
+		{
+			name:    "missing profile",
+			profile: "staging",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			for key, value := range testCase.env {
+				t.Setenv(key, value)
+			}
+			dir := writeConfigDir(t, base, "")
+			if err := os.WriteFile(filepath.Join(dir, "config.prod.yaml"), []byte(prod), 0600); err != nil {
+				t.Fatalf("Could not write profile: %s", err)
+			}
+
+			v := viper.New()
+			err := readConfig(v, dir, testCase.profile)
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if testCase.isError {
+				if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), testCase.profile) {
+					t.Errorf("Expected a missing file error naming the profile, got %v", err)
+				}
+				return
+			}
+			for key, expected := range testCase.expected {
+				value := v.Get(key)
+				switch expected.(type) {
+				case bool:
+					value = v.GetBool(key)
+				case []string:
+					value = v.GetStringSlice(key)
+				}
+				if !reflect.DeepEqual(value, expected) {
+					t.Errorf("Expected %s to be %v, got %v", key, expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	dir := writeConfigDir(t, "server:\n  port: 8081\nlog:\n  level: warn\n", "")
+
+	testTable := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		port    int
+		level   string
+		isError bool
+		help    bool
+	}{
+		{
+			name:  "file",
+			args:  []string{"--config-dir", dir},
+			port:  8081,
+			level: "warn",
+		},
+		{
+			name:  "environment over file",
+			args:  []string{"--config-dir", dir},
+			env:   map[string]string{"BERLINER_SERVER_PORT": "8082", "BERLINER_LOG_LEVEL": "error"},
+			port:  8082,
+			level: "error",
+		},
+		{
+			name:  "flags over environment",
+			args:  []string{"--config", dir, "--port", "9090", "--log-level", "debug"},
+			env:   map[string]string{"BERLINER_SERVER_PORT": "8082", "BERLINER_LOG_LEVEL": "error"},
+			port:  9090,
+			level: "debug",
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"--config-dir", dir, "--migrate"},
+			isError: true,
+		},
+		{
+			name:    "help",
+			args:    []string{"--help"},
+			isError: true,
+			help:    true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			for key, value := range testCase.env {
+				t.Setenv(key, value)
+			}
+			opts, err := parseFlags(testCase.args, io.Discard)
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if errors.Is(err, pflag.ErrHelp) != testCase.help {
+				t.Errorf("Expected help %v, got %v", testCase.help, err)
+			}
+			if testCase.isError {
+				return
+			}
+
+			v := viper.New()
+			if err := bindFlags(v, opts); err != nil {
+				t.Fatalf("Could not bind flags: %s", err)
+			}
+			if err := readConfig(v, opts.configDir, ""); err != nil {
+				t.Fatalf("Could not read config: %s", err)
+			}
+			cfg := config.FromViper(v)
+			if cfg.Server.Port != testCase.port {
+				t.Errorf("Expected port %v, got %v", testCase.port, cfg.Server.Port)
+			}
+			if cfg.Log.Level != testCase.level {
+				t.Errorf("Expected log level %v, got %v", testCase.level, cfg.Log.Level)
+			}
+		})
+	}
+}
+
+func TestProvideRepositorySharesDB(t *testing.T) {
+	// sql.Open does not connect, so no database is needed
+	db, err := sql.Open("postgres", "postgres://postgres@localhost:1/berliner?sslmode=disable")
+	if err != nil {
+		t.Fatalf("Could not open database: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+
+	repo := ProvideRepository(db)
+	if database, ok := repo.SqlQueries.(repository.Database); !ok || database.DB.DB != db {
+		t.Errorf("Expected the repository to use the provided connection pool")
+	}
+	repository.PublishStats(db)
+	if stats := expvar.Get("db_stats").String(); !strings.Contains(stats, `"MaxOpenConnections":7`) {
+		t.Errorf("Expected the stats of the provided connection pool, got %v", stats)
+	}
+}
+
+// fake secrets client with fixed JSON secrets
+type fakeSecretGetter struct {
+	secrets.Provider
+	values map[string]map[string]string
+}
+
+func (f fakeSecretGetter) GetSecret(ctx context.Context, secretName string) (string, error) {
+	return f.values[secretName]["password"], nil
+}
+
+func (f fakeSecretGetter) GetSecretField(ctx context.Context, secretName string, key string) (string, error) {
+	return f.values[secretName][key], nil
+}
+
+func TestSecretReference(t *testing.T) {
+	client := fakeSecretGetter{values: map[string]map[string]string{
+		"rds!db":              {"username": "postgres", "password": "dbSecret"},
+		"berliner/jwt_secret": {"secret": "randomJWTSecret"},
+	}}
+
+	testTable := []struct {
+		name     string
+		config   string
+		expected secretRef
+		value    string
+		isError  bool
+	}{
+		{
+			name:     "plain string",
+			config:   "aws:\n  secrets:\n    db_password: rds!db\n",
+			expected: secretRef{Name: "rds!db"},
+			value:    "dbSecret",
+		},
+		{
+			name:     "name and field",
+			config:   "aws:\n  secrets:\n    db_password:\n      name: berliner/jwt_secret\n      field: secret\n",
+			expected: secretRef{Name: "berliner/jwt_secret", Field: "secret"},
+			value:    "randomJWTSecret",
+		},
+		{
+			name:     "name only",
+			config:   "aws:\n  secrets:\n    db_password:\n      name: rds!db\n",
+			expected: secretRef{Name: "rds!db"},
+			value:    "dbSecret",
+		},
+		{
+			name:    "missing name",
+			config:  "aws:\n  secrets:\n    db_password:\n      field: password\n",
+			isError: true,
+		},
+		{
+			name:    "not set",
+			config:  "aws:\n  enabled: true\n",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(testCase.config)); err != nil {
+				t.Fatalf("Could not read config: %s", err)
+			}
+			ref, err := secretReference(v, "aws.secrets.db_password")
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if testCase.isError {
+				return
+			}
+			if ref != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ref)
+			}
+			if value, _ := resolveSecret(context.Background(), client, ref); value != testCase.value {
+				t.Errorf("Expected %v, got %v", testCase.value, value)
+			}
+		})
+	}
+}
+
+func TestValidateSecretsBackend(t *testing.T) {
+	db := "db:\n  user: postgres\n  address: localhost:5432\n  name: berliner\n"
+	testTable := []struct {
+		name    string
+		config  string
+		backend string
+		isError bool
+	}{
+		{
+			name:    "env by default",
+			config:  db,
+			backend: secretsBackendEnv,
+		},
+		{
+			name:    "aws enabled",
+			config:  db + "aws:\n  enabled: true\n  region: eu-north-1\n  secrets:\n    db_password: berliner/db\n    jwt_secret: berliner/jwt\n",
+			backend: secretsBackendAWS,
+		},
+		{
+			name:    "vault",
+			config:  db + "secrets:\n  backend: vault\nvault:\n  address: http://localhost:8200\n  auth: kubernetes\n  kubernetes_role: berliner\n  secrets:\n    db_password:\n      name: berliner/db\n      field: password\n    jwt_secret:\n      name: berliner/jwt\n      field: secret\n",
+			backend: secretsBackendVault,
+		},
+		{
+			name:    "vault without role",
+			config:  db + "secrets:\n  backend: vault\nvault:\n  address: http://localhost:8200\n  auth: kubernetes\n  secrets:\n    db_password: berliner/db\n    jwt_secret: berliner/jwt\n",
+			backend: secretsBackendVault,
+			isError: true,
+		},
+		{
+			name:    "vault approle",
+			config:  db + "secrets:\n  backend: vault\nvault:\n  address: http://localhost:8200\n  auth: approle\n  approle_role_id: berliner-role\n  secrets:\n    db_password: berliner/db\n    jwt_secret: berliner/jwt\n",
+			backend: secretsBackendVault,
+		},
+		{
+			name:    "vault approle without role id",
+			config:  db + "secrets:\n  backend: vault\nvault:\n  address: http://localhost:8200\n  auth: approle\n  secrets:\n    db_password: berliner/db\n    jwt_secret: berliner/jwt\n",
+			backend: secretsBackendVault,
+			isError: true,
+		},
+		{
+			name:    "file",
+			config:  db + "secrets:\n  backend: file\nfile:\n  dir: /run/secrets\n",
+			backend: secretsBackendFile,
+		},
+		{
+			name:    "file without db",
+			config:  "secrets:\n  backend: file\n",
+			backend: secretsBackendFile,
+			isError: true,
+		},
+		{
+			name:    "unknown backend",
+			config:  db + "secrets:\n  backend: gcp\n",
+			backend: "gcp",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(testCase.config)); err != nil {
+				t.Fatalf("Could not read config: %s", err)
+			}
+			if backend := secretsBackend(v); backend != testCase.backend {
+				t.Errorf("Expected backend %v, got %v", testCase.backend, backend)
+			}
+			if err := validateConfig(v); (err != nil) != testCase.isError {
+				t.Errorf("Expected error %v, got %v", testCase.isError, err)
+			}
+		})
+	}
+}
+
+func TestLoadSecretsVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			fmt.Fprint(w, `{"data":{"ttl":0,"renewable":false}}`)
+		case "/v1/secret/data/berliner/db":
+			fmt.Fprint(w, `{"data":{"data":{"username":"postgres","password":"dbSecret"}}}`)
+		case "/v1/secret/data/berliner/jwt":
+			fmt.Fprint(w, `{"data":{"data":{"secret":"randomJWTSecret"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	config := fmt.Sprintf("secrets:\n  backend: vault\nvault:\n  address: %s\n  secrets:\n    db_password: berliner/db\n    jwt_secret:\n      name: berliner/jwt\n      field: secret\n", server.URL)
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dbCreds, jwtSecret, err := loadSecrets(ctx, v, t.TempDir())
+	expected := secrets.DatabaseCredentials{Username: "postgres", Password: "dbSecret"}
+	if dbCreds != expected || jwtSecret != "randomJWTSecret" {
+		t.Errorf("Expected %v and randomJWTSecret, got %v and %v, error: %v", expected, dbCreds, jwtSecret, err)
+	}
+}
+
+func TestLoadEnvSecrets(t *testing.T) {
+	files := t.TempDir()
+	writeSecret := func(name, content string) string {
+		path := filepath.Join(files, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Could not write secret file: %s", err)
+		}
+		return path
+	}
+	dbPasswordFile := writeSecret("db_password", "fileSecret\n")
+	jwtSecretFile := writeSecret("jwt_secret", "fileJWTSecret\r\n")
+	emptyFile := writeSecret("empty", "\n")
+
+	testTable := []struct {
+		name       string
+		env        map[string]string
+		dotenv     string
+		dbPassword string
+		jwtSecret  string
+		err        string
+	}{
+		{
+			name:       "files take precedence",
+			env:        map[string]string{"DB_PASSWORD_FILE": dbPasswordFile, "DB_PASSWORD": "inline", "JWT_SECRET_FILE": jwtSecretFile},
+			dotenv:     "DB_PASSWORD=dotenv\nJWT_SECRET=dotenvJWTSecret\n",
+			dbPassword: "fileSecret",
+			jwtSecret:  "fileJWTSecret",
+		},
+		{
+			name:       "files without .env",
+			env:        map[string]string{"DB_PASSWORD_FILE": dbPasswordFile, "JWT_SECRET_FILE": jwtSecretFile},
+			dbPassword: "fileSecret",
+			jwtSecret:  "fileJWTSecret",
+		},
+		{
+			name:       "environment and .env",
+			env:        map[string]string{"DB_PASSWORD": "inline"},
+			dotenv:     "DB_PASSWORD=dotenv\nJWT_SECRET=dotenvJWTSecret\n",
+			dbPassword: "inline",
+			jwtSecret:  "dotenvJWTSecret",
+		},
+		{
+			name:   "missing file",
+			env:    map[string]string{"DB_PASSWORD_FILE": filepath.Join(files, "missing"), "JWT_SECRET_FILE": jwtSecretFile},
+			dotenv: "DB_PASSWORD=dotenv\n",
+			err:    "failed to read DB_PASSWORD_FILE",
+		},
+		{
+			name: "empty file",
+			env:  map[string]string{"DB_PASSWORD_FILE": dbPasswordFile, "JWT_SECRET_FILE": emptyFile},
+			err:  "failed to read JWT_SECRET_FILE: " + emptyFile + " is empty",
+		},
+		{
+			name: "missing .env",
+			env:  map[string]string{"DB_PASSWORD_FILE": dbPasswordFile},
+			err:  "failed to load .env file",
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			for _, key := range []string{"DB_PASSWORD", "DB_PASSWORD_FILE", "JWT_SECRET", "JWT_SECRET_FILE"} {
+				t.Setenv(key, testCase.env[key])
+			}
+			dbPassword, jwtSecret, err := loadEnvSecrets(writeConfigDir(t, "", testCase.dotenv))
+			if testCase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.err) {
+					t.Fatalf("Expected error containing %q, got %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Could not load secrets: %s", err)
+			}
+			if dbPassword != testCase.dbPassword || jwtSecret != testCase.jwtSecret {
+				t.Errorf("Expected %v and %v, got %v and %v", testCase.dbPassword, testCase.jwtSecret, dbPassword, jwtSecret)
+			}
+		})
+	}
+}
+
+func TestDatabaseConfig(t *testing.T) {
+	db := "db:\n  user: postgres\n  address: localhost:5432\n  name: berliner\n"
+	testTable := []struct {
+		name     string
+		config   string
+		creds    secrets.DatabaseCredentials
+		expected config.DB
+		isError  bool
+	}{
+		{
+			name:     "full secret",
+			config:   db,
+			creds:    secrets.DatabaseCredentials{Username: "rotated", Password: "dbSecret", Host: "rds.example.com", Port: 6432, DBName: "prod"},
+			expected: config.DB{User: "rotated", Password: "dbSecret", Address: "rds.example.com:6432", Name: "prod", SSLMode: config.DefaultSSLMode},
+		},
+		{
+			name:     "partial secret",
+			config:   db,
+			creds:    secrets.DatabaseCredentials{Username: "rotated", Password: "dbSecret", Port: 6432},
+			expected: config.DB{User: "rotated", Password: "dbSecret", Address: "localhost:6432", Name: "berliner", SSLMode: config.DefaultSSLMode},
+		},
+		{
+			name:     "password only",
+			config:   db,
+			creds:    secrets.DatabaseCredentials{Password: "dbSecret"},
+			expected: config.DB{User: "postgres", Password: "dbSecret", Address: "localhost:5432", Name: "berliner", SSLMode: config.DefaultSSLMode},
+		},
+		{
+			name:    "missing in both",
+			config:  "db:\n  address: localhost:5432\n",
+			creds:   secrets.DatabaseCredentials{Password: "dbSecret"},
+			isError: true,
+		},
+		{
+			name:    "no password",
+			config:  db,
+			creds:   secrets.DatabaseCredentials{Username: "rotated"},
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			v := viper.New()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(testCase.config)); err != nil {
+				t.Fatalf("Could not read config: %s", err)
+			}
+			ans, err := databaseConfig(v, testCase.creds)
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if !testCase.isError && ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestSecretsOptions(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	config := "aws:\n  secrets_cache_ttl: 1m\n  secrets_retry:\n    max_attempts: 2\n    base_delay: 50ms\n"
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+	if opts := secretsOptions(v, "aws"); len(opts) != 2 {
+		t.Errorf("Expected cache and retry options, got %v options", len(opts))
+	}
+	if opts := secretsOptions(v, "vault"); len(opts) != 0 {
+		t.Errorf("Expected no vault options, got %v options", len(opts))
+	}
+}
@@ -1,8 +1,10 @@
 package models
 
 import (
+	"fmt"
 	"net/mail"
 	"regexp"
+	"unicode/utf8"
 )
 
 // interface for all models
@@ -32,6 +34,23 @@ func (user User) IsValid() map[string]string {
 	return validMap
 }
 
+// only the set fields are validated, with the rules of signup
+func (update UserUpdate) IsValid() map[string]string {
+	validMap := make(map[string]string)
+
+	if update.FirstName != nil && !validName(*update.FirstName) {
+		validMap["firstName"] = "Invalid first name"
+	}
+	if update.LastName != nil && !validName(*update.LastName) {
+		validMap["lastName"] = "Invalid last name"
+	}
+	if update.Email != nil && !validEmail(*update.Email) {
+		validMap["email"] = "Invalid email"
+	}
+
+	return validMap
+}
+
 func (channel Channel) IsValid() map[string]string {
 	validMap := make(map[string]string)
 
@@ -95,12 +114,39 @@ func validChannelName(name string) bool {
 }
 
 func validPassword(password string) bool {
-	patterns := []string{"^[a-zA-Z0-9_@$!%*#?&.]{8,40}$", "[a-z]+", "[A-Z]+", "[\\d]+", "[@$!%*#?&.]+"}
-	for _, pattern := range patterns {
-		tmp, _ := regexp.MatchString(pattern, password)
-		if !tmp {
-			return false
+	return len(PasswordPolicy(password)) == 0
+}
+
+// limits of the password length
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 40
+)
+
+// rules of the password policy besides its length, checked in this order
+var passwordRules = []struct {
+	pattern *regexp.Regexp
+	err     ValidationError
+}{
+	{regexp.MustCompile("^[a-zA-Z0-9_@$!%*#?&.]*$"), ValidationError{Code: "password_invalid_characters", Message: "Password can only contain letters, digits and _@$!%*#?&."}},
+	{regexp.MustCompile("[a-z]"), ValidationError{Code: "password_no_lowercase", Message: "Password must contain a lowercase letter"}},
+	{regexp.MustCompile("[A-Z]"), ValidationError{Code: "password_no_uppercase", Message: "Password must contain an uppercase letter"}},
+	{regexp.MustCompile("[0-9]"), ValidationError{Code: "password_no_digit", Message: "Password must contain a digit"}},
+	{regexp.MustCompile("[@$!%*#?&.]"), ValidationError{Code: "password_no_special", Message: "Password must contain one of @$!%*#?&."}},
+}
+
+// PasswordPolicy returns every rule of the password policy the password does not meet
+func PasswordPolicy(password string) []ValidationError {
+	unmet := []ValidationError{}
+	if length := utf8.RuneCountInString(password); length < minPasswordLength {
+		unmet = append(unmet, ValidationError{Code: "password_too_short", Message: fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+	} else if length > maxPasswordLength {
+		unmet = append(unmet, ValidationError{Code: "password_too_long", Message: fmt.Sprintf("Password can be at most %d characters", maxPasswordLength)})
+	}
+	for _, rule := range passwordRules {
+		if !rule.pattern.MatchString(password) {
+			unmet = append(unmet, rule.err)
 		}
 	}
-	return true
+	return unmet
 }
@@ -2,8 +2,12 @@ package models
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // nullable  int64
@@ -27,34 +31,92 @@ func (ni *NullInt64) UnmarshalJSON(b []byte) error {
 	return err
 }
 
+// timestamp which is always kept in UTC and serialised as RFC3339 with second precision
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp returns the given time as a UTC Timestamp
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{t.UTC()}
+}
+
+// method for Marshalling timestamp
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(time.RFC3339))
+}
+
+// method for Unmarshalling timestamp, any RFC3339 offset is accepted and normalised to UTC
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
+
+// method for scanning timestamp from the database
+func (t *Timestamp) Scan(value interface{}) error {
+	v, ok := value.(time.Time)
+	if !ok {
+		return fmt.Errorf("can not scan %T into Timestamp", value)
+	}
+	t.Time = v.UTC()
+	return nil
+}
+
+// method for writing timestamp to the database
+func (t Timestamp) Value() (driver.Value, error) {
+	return t.UTC(), nil
+}
+
 // all models and their attributes(collumns) are defined here
 
 type Channel struct {
-	Id              int    `json:"id" db:"id"`
+	Id          int    `json:"id" db:"id"`
 	LeaderId    int    `json:"leaderId" db:"leader_id"`
 	Name        string `json:"name" db:"name"`
 	Description string `json:"description" db:"description"`
+	IsActive    bool   `json:"isActive" db:"is_active"`
+	// whether users can request to join the channel
+	AcceptingMembers bool `json:"acceptingMembers" db:"accepting_members"`
 }
 
 type User struct {
-	Id        int    `json:"id" db:"id"`
-	Username  string `json:"username" db:"username"`
-	FirstName string `json:"firstName" db:"first_name"`
-	LastName  string `json:"lastName" db:"last_name"`
-	Password  string `json:"password" db:"password"`
-	Email     string `json:"email" db:"email"`
+	Id         int    `json:"id" db:"id"`
+	Username   string `json:"username" db:"username"`
+	FirstName  string `json:"firstName" db:"first_name"`
+	LastName   string `json:"lastName" db:"last_name"`
+	Password   string `json:"password" db:"password"`
+	Email      string `json:"email" db:"email"`
+	IsVerified bool   `json:"isVerified" db:"is_verified"`
+	// "password", or the identity provider of an account without a password such as "google"
+	AuthProvider string `json:"-" db:"auth_provider"`
+	// RoleUser, or RoleAdmin for platform admins
+	Role string `json:"-" db:"role"`
 }
 
+// roles of users
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type Membership struct {
-	Id       int  `json:"id" db:"id"`
-	UserId   int  `json:"userId" db:"user_id"`
-	ChannelId   int  `json:"channelId" db:"channel_id"`
-	IsEditor bool `json:"isEditor" db:"is_editor"`
+	Id        int  `json:"id" db:"id"`
+	UserId    int  `json:"userId" db:"user_id"`
+	ChannelId int  `json:"channelId" db:"channel_id"`
+	IsEditor  bool `json:"isEditor" db:"is_editor"`
 }
 type Post struct {
 	Id         int       `json:"id" db:"id"`
-	UpdatedAt  time.Time `json:"updatedAt" db:"updated_at"`
-	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt  Timestamp `json:"updatedAt" db:"updated_at"`
+	CreatedAt  Timestamp `json:"createdAt" db:"created_at"`
 	AuthorType string    `json:"authorType" db:"author_type"`
 	Content    string    `json:"content" db:"content"`
 	IsPublic   bool      `json:"isPublic" db:"is_public"`
@@ -69,13 +131,209 @@ type ChannelPost struct {
 	ChannelId int `json:"channelId" db:"channel_id"`
 }
 
+type Request struct {
+	Id         int  `json:"id" db:"id"`
+	ChannelId  int  `json:"channelId" db:"channel_id"`
+	UserId     int  `json:"userId" db:"user_id"`
+	IsAccepted bool `json:"isAccepted" db:"is_accepted"`
+}
+
 type Following struct {
-	Id         int `json:"id" db:"id"`
-	UserId     int `json:"userId" db:"user_id"`
-	FollowerId int `json:"follwerId" db:"follower_id"`
+	Id         int       `json:"id" db:"id"`
+	UserId     int       `json:"userId" db:"user_id"`
+	FollowerId int       `json:"follwerId" db:"follower_id"`
+	CreatedAt  Timestamp `json:"createdAt" db:"created_at"`
+}
+
+type InstanceStats struct {
+	Users       int `json:"users" db:"users"`
+	Channels    int `json:"channels" db:"channels"`
+	Posts       int `json:"posts" db:"posts"`
+	ActiveUsers int `json:"activeUsers" db:"active_users"`
+}
+
+type ChannelRelationship struct {
+	IsMember          bool `json:"isMember" db:"is_member"`
+	IsEditor          bool `json:"isEditor" db:"is_editor"`
+	IsLeader          bool `json:"isLeader" db:"is_leader"`
+	HasPendingRequest bool `json:"hasPendingRequest" db:"has_pending_request"`
+}
+
+// a pending join request with the channel it was sent to and the user who sent it
+type RequestWithUser struct {
+	Request
+	ChannelName string `json:"channelName" db:"channel_name"`
+	Username    string `json:"username" db:"username"`
+	FirstName   string `json:"firstName" db:"first_name"`
+	LastName    string `json:"lastName" db:"last_name"`
+}
+
+// a channel of the user with the role of the user in it, leader, editor or member
+type ChannelRole struct {
+	Channel
+	Role string `json:"role" db:"role"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag" db:"tag"`
+	Count int    `json:"count" db:"count"`
+}
+
+type GrowthPoint struct {
+	Start Timestamp `json:"start" db:"start"`
+	Count int       `json:"count" db:"count"`
+}
+
+type ChannelActiveForm struct {
+	Active bool `json:"active"`
+}
+
+type ChannelAcceptingForm struct {
+	Accepting bool `json:"accepting"`
+}
+
+type BulkDeleteForm struct {
+	AuthorType string `json:"authorType"`
+	Ids        []int  `json:"ids"`
 }
 
 type AuthorizationForm struct {
 	Username string
 	Password string
 }
+
+type RefreshForm struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// id token of an identity provider the user signs in with
+type IdTokenForm struct {
+	IdToken string `json:"idToken"`
+}
+
+// only the sha-256 hash of a refresh token is stored
+type RefreshToken struct {
+	Id        int       `db:"id"`
+	UserId    int       `db:"user_id"`
+	TokenHash string    `db:"token_hash"`
+	CreatedAt Timestamp `db:"created_at"`
+	ExpiresAt Timestamp `db:"expires_at"`
+	Revoked   bool      `db:"revoked"`
+	// the session the token belongs to, every refresh of a login keeps it
+	// null in the tokens issued before sessions were added
+	SessionId NullInt64 `db:"session_id"`
+}
+
+// a login of a user, the refresh tokens of a login are rotated within its session
+type Session struct {
+	Id         int       `json:"id" db:"id"`
+	UserId     int       `json:"-" db:"user_id"`
+	Device     string    `json:"device" db:"device"`
+	CreatedAt  Timestamp `json:"createdAt" db:"created_at"`
+	LastUsedAt Timestamp `json:"lastUsedAt" db:"last_used_at"`
+	// whether the session is the one of the request
+	Current bool `json:"current" db:"-"`
+}
+
+type ForgotPasswordForm struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordForm struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// fields of the user to update, nil fields are kept
+type UserUpdate struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Email     *string `json:"email"`
+}
+
+// form of a logged in user changing their password
+type ChangePasswordForm struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// a user has at most one password reset, only the sha-256 hash of its token is stored
+type PasswordReset struct {
+	UserId    int       `db:"user_id"`
+	TokenHash string    `db:"token_hash"`
+	ExpiresAt Timestamp `db:"expires_at"`
+}
+
+// a user has at most one email verification, only the sha-256 hash of its token is stored
+type EmailVerification struct {
+	UserId    int       `db:"user_id"`
+	TokenHash string    `db:"token_hash"`
+	ExpiresAt Timestamp `db:"expires_at"`
+}
+
+// two-factor authentication of a user, the TOTP secret is stored encrypted
+// codes are only asked for at login once a first code confirmed the secret
+type UserMFA struct {
+	UserId  int    `db:"user_id"`
+	Secret  string `db:"secret"`
+	Enabled bool   `db:"enabled"`
+	// time step of the last code used, codes of it and earlier steps are rejected
+	LastStep  int64     `db:"last_step"`
+	CreatedAt Timestamp `db:"created_at"`
+}
+
+// password login of a user with two-factor authentication waiting for a code, only the sha-256 hash of its token is stored
+type MFAChallenge struct {
+	Id        int    `db:"id"`
+	UserId    int    `db:"user_id"`
+	TokenHash string `db:"token_hash"`
+	// device of the session started once the code is given
+	Device    string    `db:"device"`
+	ExpiresAt Timestamp `db:"expires_at"`
+	// wrong codes given for the challenge
+	Attempts int `db:"attempts"`
+}
+
+// code of an authenticator app, or a recovery code where they are accepted
+type MFACodeForm struct {
+	Code string `json:"code"`
+}
+
+// token of a password login waiting for a code and the code
+type MFAVerifyForm struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+// access token revoked before it expired, kept until then
+type RevokedToken struct {
+	Jti       string    `db:"jti"`
+	ExpiresAt Timestamp `db:"expires_at"`
+}
+
+// key of a service calling the api without a user, only the sha-256 hash of the key is stored
+type APIKey struct {
+	Id      int    `json:"id" db:"id"`
+	Name    string `json:"name" db:"name"`
+	KeyHash string `json:"-" db:"key_hash"`
+	// routes the key can call
+	Scopes    pq.StringArray `json:"scopes" db:"scopes"`
+	CreatedAt Timestamp      `json:"createdAt" db:"created_at"`
+	// null while the key can be used
+	RevokedAt *Timestamp `json:"revokedAt" db:"revoked_at"`
+}
+
+type APIKeyForm struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type PasswordCheckForm struct {
+	Password string `json:"password"`
+}
+
+// rule of a validation the input did not meet
+type ValidationError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampMarshalJSON(t *testing.T) {
+	testTable := []struct {
+		name     string
+		input    time.Time
+		expected string
+	}{
+		{
+			name:     "utc",
+			input:    time.Date(2030, time.January, 10, 12, 30, 15, 0, time.UTC),
+			expected: `"2030-01-10T12:30:15Z"`,
+		},
+		{
+			name:     "offset and nanoseconds",
+			input:    time.Date(2030, time.January, 10, 17, 30, 15, 999, time.FixedZone("UTC+5", 5*60*60)),
+			expected: `"2030-01-10T12:30:15Z"`,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := json.Marshal(Timestamp{testCase.input})
+			if string(ans) != testCase.expected {
+				t.Errorf("Expected %v, got %s, error: %v", testCase.expected, ans, err)
+			}
+		})
+	}
+}
+
+func TestTimestampUnmarshalJSON(t *testing.T) {
+	testTable := []struct {
+		name     string
+		input    string
+		expected time.Time
+		isError  bool
+	}{
+		{
+			name:     "utc",
+			input:    `"2030-01-10T12:30:15Z"`,
+			expected: time.Date(2030, time.January, 10, 12, 30, 15, 0, time.UTC),
+		},
+		{
+			name:     "negative offset",
+			input:    `"2030-01-10T07:30:15-05:00"`,
+			expected: time.Date(2030, time.January, 10, 12, 30, 15, 0, time.UTC),
+		},
+		{
+			name:    "not rfc3339",
+			input:   `"10.01.2030 12:30"`,
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var ans Timestamp
+			err := json.Unmarshal([]byte(testCase.input), &ans)
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if !testCase.isError && (!ans.Equal(testCase.expected) || ans.Location() != time.UTC) {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans.Time)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	testTable := []struct {
+		name     string
+		password string
+		expected []string
+	}{
+		{
+			name:     "strong",
+			password: "Qqwerty1!.",
+			expected: []string{},
+		},
+		{
+			name:     "weak",
+			password: "qwerty",
+			expected: []string{"password_too_short", "password_no_uppercase", "password_no_digit", "password_no_special"},
+		},
+		{
+			name:     "too long with spaces",
+			password: strings.Repeat("Qq1! ", 9),
+			expected: []string{"password_too_long", "password_invalid_characters"},
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			codes := []string{}
+			for _, unmet := range PasswordPolicy(testCase.password) {
+				codes = append(codes, unmet.Code)
+			}
+			if !reflect.DeepEqual(codes, testCase.expected) {
+				t.Errorf("Expected %v, got %v", testCase.expected, codes)
+			}
+			if valid := validPassword(testCase.password); valid != (len(testCase.expected) == 0) {
+				t.Errorf("Expected the signup validation to agree with the policy, got %v", valid)
+			}
+		})
+	}
+}
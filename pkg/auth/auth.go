@@ -0,0 +1,109 @@
+package auth
+
+// Issuing and verifying the tokens of logged in users
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/I1Asyl/berliner_backend/pkg/config"
+)
+
+// returned when a token can not be verified
+var ErrInvalidToken = errors.New("invalid token")
+
+// returned when a valid token is past its expiry
+var ErrTokenExpired = errors.New("token is expired")
+
+// returned with ErrInvalidToken when a token can not be decoded
+var ErrTokenMalformed = errors.New("token is malformed")
+
+// returned with ErrInvalidToken when the signature or the authentication tag of a token does not match
+var ErrTokenInvalidSignature = errors.New("token signature is invalid")
+
+// returned with ErrInvalidToken when an authentic token is of another issuer or audience
+var ErrClaimsMismatch = errors.New("token issuer or audience does not match")
+
+// returned when a token manager is created without a key
+var ErrKeyNotSet = errors.New("token key is not set")
+
+// Claims are what a token says about its holder
+type Claims struct {
+	// unique id the token can be revoked by, empty in the tokens issued before it was added
+	Id string
+	// 0 in the tokens issued before the id was added to them
+	UserId   int
+	Username string
+	// the login session the token was issued to, 0 in the tokens issued before sessions were added
+	SessionId int
+	Issuer    string
+	Audience  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenManager issues the tokens of logged in users and verifies the tokens sent back
+type TokenManager interface {
+	// Issue returns a token of the claims, the issuer and the times which are not set are filled in
+	Issue(claims Claims) (string, error)
+	// Verify returns the claims of a token which is authentic, not expired and of the configured issuer
+	Verify(token string) (Claims, error)
+}
+
+// Clock is the source of the current time of a token manager
+type Clock interface {
+	Now() time.Time
+}
+
+// Options configure a token manager
+type Options struct {
+	// secret the tokens are signed or encrypted with
+	Key []byte
+	// how long a token is valid
+	TTL time.Duration
+	// written into every token and required when a token is verified
+	Issuer string
+	// like the issuer, tokens have no audience when it is empty
+	Audience string
+	Clock    Clock
+}
+
+// NewTokenManager returns the token manager of the configured format keyed with the jwt secret
+func NewTokenManager(tokens config.Tokens, jwt config.JWT, clock Clock) (TokenManager, error) {
+	opts := Options{Key: []byte(jwt.Secret), TTL: tokens.TTL, Issuer: tokens.Issuer, Audience: tokens.Audience, Clock: clock}
+	switch tokens.Format {
+	case "jwt":
+		return NewJWT(opts, tokens.Algorithm)
+	case "paseto":
+		return NewPASETO(opts)
+	}
+	return nil, fmt.Errorf("unknown token format: %s", tokens.Format)
+}
+
+// fills in the issuer, the audience and the times which are not set
+func (o Options) stamp(claims Claims) Claims {
+	claims.Issuer = o.Issuer
+	claims.Audience = o.Audience
+	if claims.IssuedAt.IsZero() {
+		claims.IssuedAt = o.Clock.Now()
+	}
+	if claims.ExpiresAt.IsZero() {
+		claims.ExpiresAt = claims.IssuedAt.Add(o.TTL)
+	}
+	return claims
+}
+
+// checks the expiry, the issuer and the audience of verified claims
+func (o Options) check(claims Claims) (Claims, error) {
+	if claims.Issuer != o.Issuer {
+		return Claims{}, fmt.Errorf("%w: %w: unexpected issuer %q", ErrInvalidToken, ErrClaimsMismatch, claims.Issuer)
+	}
+	if claims.Audience != o.Audience {
+		return Claims{}, fmt.Errorf("%w: %w: unexpected audience %q", ErrInvalidToken, ErrClaimsMismatch, claims.Audience)
+	}
+	if !o.Clock.Now().Before(claims.ExpiresAt) {
+		return Claims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
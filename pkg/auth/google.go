@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// GoogleJWKSURL serves the public keys Google signs its id tokens with
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// issuers of Google id tokens
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// how long the keys of Google are used before they are fetched again
+const googleKeysTTL = time.Hour
+
+// keys are fetched again for an unknown key id at most this often, so forged key ids can not flood Google
+const googleKeysRefetch = time.Minute
+
+// returned when an identity provider did not verify the email of an id token
+var ErrEmailNotVerified = errors.New("email is not verified by the identity provider")
+
+// Identity is the user an identity provider vouches for
+type Identity struct {
+	// id of the user at the provider
+	Subject    string
+	Email      string
+	GivenName  string
+	FamilyName string
+}
+
+// TokenVerifier verifies the id tokens of an identity provider
+type TokenVerifier interface {
+	// Verify returns the identity of an id token which is authentic, not expired and issued to this app
+	Verify(ctx context.Context, token string) (Identity, error)
+}
+
+// claims of a Google id token
+type googleClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+// GoogleVerifier verifies Google id tokens against the keys of Google
+type GoogleVerifier struct {
+	// the audience of the id tokens issued to this app
+	clientId string
+	jwksURL  string
+	client   *http.Client
+	clock    Clock
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewGoogleVerifier returns a verifier of the id tokens Google issued to the client, with the keys served at jwksURL
+func NewGoogleVerifier(clientId string, jwksURL string, clock Clock) *GoogleVerifier {
+	return &GoogleVerifier{clientId: clientId, jwksURL: jwksURL, client: &http.Client{Timeout: 10 * time.Second}, clock: clock}
+}
+
+// Verify checks the signature, the expiry, the issuer and the audience of a Google id token
+// an identity without a verified email is rejected with ErrEmailNotVerified
+func (g *GoogleVerifier) Verify(ctx context.Context, token string) (Identity, error) {
+	parsed := googleClaims{}
+	_, err := jwt.ParseWithClaims(token, &parsed, func(tok *jwt.Token) (interface{}, error) {
+		kid, _ := tok.Header["kid"].(string)
+		return g.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(g.clientId), jwt.WithTimeFunc(g.clock.Now))
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return Identity{}, fmt.Errorf("%w: %v", ErrTokenExpired, err)
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return Identity{}, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return Identity{}, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrTokenInvalidSignature, err)
+	case err != nil:
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !slices.Contains(googleIssuers, parsed.Issuer) || parsed.ExpiresAt == nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, ErrClaimsMismatch)
+	}
+	if parsed.Email == "" || !parsed.EmailVerified {
+		return Identity{}, ErrEmailNotVerified
+	}
+	return Identity{Subject: parsed.Subject, Email: parsed.Email, GivenName: parsed.GivenName, FamilyName: parsed.FamilyName}, nil
+}
+
+// returns the key of the id, the keys are fetched when they are stale or the id is unknown
+func (g *GoogleVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock.Now()
+	key, ok := g.keys[kid]
+	stale := now.Sub(g.fetchedAt) >= googleKeysTTL
+	if ok && !stale {
+		return key, nil
+	}
+	if !stale && now.Sub(g.fetchedAt) < googleKeysRefetch {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	keys, err := g.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.keys, g.fetchedAt = keys, now
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// fetches the RSA keys of the JWKS by their key ids
+func (g *GoogleVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch google keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch google keys: %s", resp.Status)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("could not decode google keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of google key %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of google key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
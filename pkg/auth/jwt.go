@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claims of a jwt token, the username claim keeps its name from the tokens issued before
+// the user id is left out when it is not set, so those tokens are unchanged
+type jwtClaims struct {
+	UserId    int `json:",omitempty"`
+	Username  string
+	SessionId int `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// JWT issues HMAC or RSA signed json web tokens
+type JWT struct {
+	opts   Options
+	method jwt.SigningMethod
+	// the secret with HMAC, the private and the public key with RSA
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewJWT returns a token manager of jwt tokens signed with algorithm, HS256, HS384, HS512, RS256, RS384 or RS512
+// with the RS algorithms the key is a PEM encoded RSA private key, PKCS #1 or PKCS #8,
+// so other services can verify the tokens with its public key alone
+func NewJWT(opts Options, algorithm string) (*JWT, error) {
+	if len(opts.Key) == 0 {
+		return nil, ErrKeyNotSet
+	}
+	switch method := jwt.GetSigningMethod(algorithm).(type) {
+	case *jwt.SigningMethodHMAC:
+		return &JWT{opts: opts, method: method, signKey: opts.Key, verifyKey: opts.Key}, nil
+	case *jwt.SigningMethodRSA:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s key: %w", algorithm, err)
+		}
+		return &JWT{opts: opts, method: method, signKey: private, verifyKey: &private.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported jwt algorithm: %s", algorithm)
+}
+
+// Issue returns a signed jwt token of the claims
+func (j *JWT) Issue(claims Claims) (string, error) {
+	claims = j.opts.stamp(claims)
+	token := jwt.NewWithClaims(j.method, jwtClaims{
+		claims.UserId,
+		claims.Username,
+		claims.SessionId,
+		jwt.RegisteredClaims{
+			ID:        claims.Id,
+			Issuer:    claims.Issuer,
+			Audience:  audience(claims.Audience),
+			IssuedAt:  jwt.NewNumericDate(claims.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(claims.ExpiresAt),
+		},
+	})
+	return token.SignedString(j.signKey)
+}
+
+// Verify checks the signature, the algorithm, the expiry and the issuer of a jwt token
+func (j *JWT) Verify(token string) (Claims, error) {
+	parsed := jwtClaims{}
+	// the time based claims are checked together with the issuer afterwards
+	// only the configured algorithm is accepted, so an HS256 token keyed with the public key is rejected
+	_, err := jwt.ParseWithClaims(token, &parsed, func(tok *jwt.Token) (interface{}, error) {
+		return j.verifyKey, nil
+	}, jwt.WithValidMethods([]string{j.method.Alg()}), jwt.WithoutClaimsValidation())
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return Claims{}, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		// also returned for a token of another algorithm
+		return Claims{}, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrTokenInvalidSignature, err)
+	case err != nil:
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return j.opts.check(Claims{
+		Id:        parsed.ID,
+		UserId:    parsed.UserId,
+		Username:  parsed.Username,
+		SessionId: parsed.SessionId,
+		Issuer:    parsed.Issuer,
+		Audience:  strings.Join(parsed.Audience, " "),
+		IssuedAt:  numericTime(parsed.IssuedAt),
+		ExpiresAt: numericTime(parsed.ExpiresAt),
+	})
+}
+
+// returns the aud claim of the audience, left out when it is empty
+func audience(aud string) jwt.ClaimStrings {
+	if aud == "" {
+		return nil
+	}
+	return jwt.ClaimStrings{aud}
+}
+
+// returns the time of a numeric date, zero when it is not set
+func numericTime(date *jwt.NumericDate) time.Time {
+	if date == nil {
+		return time.Time{}
+	}
+	return date.Time
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// header of v4.local tokens
+const pasetoHeader = "v4.local."
+
+// sizes of the parts of a v4.local token
+const (
+	pasetoNonceSize = 32
+	pasetoTagSize   = 32
+)
+
+// claims of a paseto token, times are RFC 3339 strings as the paseto registered claims are
+type pasetoClaims struct {
+	Id        string    `json:"jti,omitempty"`
+	UserId    int       `json:"user_id,omitempty"`
+	Username  string    `json:"username"`
+	SessionId int       `json:"sid,omitempty"`
+	Issuer    string    `json:"iss"`
+	Audience  string    `json:"aud,omitempty"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// PASETO issues v4.local tokens, encrypted and authenticated with a symmetric key
+type PASETO struct {
+	opts Options
+	key  [32]byte
+}
+
+// NewPASETO returns a token manager of paseto v4.local tokens
+// the secret can have any length, the 256 bit key is derived from it with BLAKE2b
+func NewPASETO(opts Options) (*PASETO, error) {
+	if len(opts.Key) == 0 {
+		return nil, ErrKeyNotSet
+	}
+	return &PASETO{opts: opts, key: blake2b.Sum256(opts.Key)}, nil
+}
+
+// Issue returns an encrypted paseto token of the claims
+func (p *PASETO) Issue(claims Claims) (string, error) {
+	claims = p.opts.stamp(claims)
+	message, err := json.Marshal(pasetoClaims{
+		Id:        claims.Id,
+		UserId:    claims.UserId,
+		Username:  claims.Username,
+		SessionId: claims.SessionId,
+		Issuer:    claims.Issuer,
+		Audience:  claims.Audience,
+		IssuedAt:  claims.IssuedAt.UTC(),
+		ExpiresAt: claims.ExpiresAt.UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, pasetoNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return pasetoEncrypt(p.key[:], nonce, message)
+}
+
+// Verify authenticates and decrypts a paseto token and checks its expiry and issuer
+func (p *PASETO) Verify(token string) (Claims, error) {
+	message, err := pasetoDecrypt(p.key[:], token)
+	if err != nil {
+		return Claims{}, err
+	}
+	parsed := pasetoClaims{}
+	if err := json.Unmarshal(message, &parsed); err != nil {
+		return Claims{}, fmt.Errorf("%w: %w: %v", ErrInvalidToken, ErrTokenMalformed, err)
+	}
+	return p.opts.check(Claims{
+		Id:        parsed.Id,
+		UserId:    parsed.UserId,
+		Username:  parsed.Username,
+		SessionId: parsed.SessionId,
+		Issuer:    parsed.Issuer,
+		Audience:  parsed.Audience,
+		IssuedAt:  parsed.IssuedAt,
+		ExpiresAt: parsed.ExpiresAt,
+	})
+}
+
+// pasetoEncrypt returns the v4.local token of the message without a footer
+func pasetoEncrypt(key, nonce, message []byte) (string, error) {
+	encryptionKey, counterNonce, authKey, err := splitKey(key, nonce)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+	tag, err := pasetoTag(authKey, nonce, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	payload := bytes.Join([][]byte{nonce, ciphertext, tag}, nil)
+	return pasetoHeader + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// pasetoDecrypt authenticates a v4.local token without a footer and returns its message
+func pasetoDecrypt(key []byte, token string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(token, pasetoHeader)
+	if !ok {
+		return nil, fmt.Errorf("%w: %w: not a v4.local token", ErrInvalidToken, ErrTokenMalformed)
+	}
+	// the tokens are issued without a footer
+	if strings.Contains(encoded, ".") {
+		return nil, fmt.Errorf("%w: %w: unexpected footer", ErrInvalidToken, ErrTokenMalformed)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) < pasetoNonceSize+pasetoTagSize {
+		return nil, fmt.Errorf("%w: %w: malformed payload", ErrInvalidToken, ErrTokenMalformed)
+	}
+	nonce := payload[:pasetoNonceSize]
+	ciphertext := payload[pasetoNonceSize : len(payload)-pasetoTagSize]
+	tag := payload[len(payload)-pasetoTagSize:]
+
+	encryptionKey, counterNonce, authKey, err := splitKey(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := pasetoTag(authKey, nonce, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(tag, expected) {
+		return nil, fmt.Errorf("%w: %w: authentication failed", ErrInvalidToken, ErrTokenInvalidSignature)
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// splitKey derives the encryption key, the XChaCha20 nonce and the authentication key of a token from its nonce
+func splitKey(key, nonce []byte) ([]byte, []byte, []byte, error) {
+	encryption, err := blake2b.New(56, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encryption.Write([]byte("paseto-encryption-key"))
+	encryption.Write(nonce)
+	tmp := encryption.Sum(nil)
+
+	authentication, err := blake2b.New256(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authentication.Write([]byte("paseto-auth-key-for-aead"))
+	authentication.Write(nonce)
+	return tmp[:32], tmp[32:], authentication.Sum(nil), nil
+}
+
+// pasetoTag returns the BLAKE2b-MAC of the pre-authentication encoding of the header, nonce, ciphertext,
+// empty footer and empty implicit assertion
+func pasetoTag(authKey, nonce, ciphertext []byte) ([]byte, error) {
+	mac, err := blake2b.New256(authKey)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(preAuthEncode([]byte(pasetoHeader), nonce, ciphertext, nil, nil))
+	return mac.Sum(nil), nil
+}
+
+// preAuthEncode is the PAE of the paseto spec, every piece is prefixed with its little endian 64 bit length
+func preAuthEncode(pieces ...[]byte) []byte {
+	encoded := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, piece := range pieces {
+		encoded = binary.LittleEndian.AppendUint64(encoded, uint64(len(piece)))
+		encoded = append(encoded, piece...)
+	}
+	return encoded
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238, the defaults every authenticator app supports
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// codes are the truncated hash modulo 10^totpDigits
+	totpModulus = 1_000_000
+	// size of a generated secret, the size of a SHA-1 digest as RFC 4226 recommends
+	totpSecretBytes = 20
+)
+
+// codes of the steps before and after the current one are accepted, clocks of phones drift
+const totpSkew = 1
+
+// secrets are encoded as unpadded base32, the encoding of provisioning URIs
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// returned when a sealed secret was not sealed with the key of the box or was altered
+var ErrSealedSecret = errors.New("sealed secret can not be opened")
+
+// GenerateTOTPSecret returns a new random TOTP secret encoded as base32
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPStep returns the time step of the time, codes change once per step
+func TOTPStep(at time.Time) int64 {
+	return at.Unix() / int64(totpPeriod/time.Second)
+}
+
+// TOTPCode returns the code of the secret in the time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus), nil
+}
+
+// ValidateTOTP returns the step the code of the secret belongs to at the time
+// only steps after lastStep are accepted, so a code can not be used twice
+func ValidateTOTP(secret, code string, at time.Time, lastStep int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := TOTPStep(at)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI returns the otpauth URI authenticator apps read from a QR code
+func TOTPURI(issuer, account, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// SecretBox encrypts the secrets which are stored, such as TOTP secrets, with AES-GCM
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox returns a box with a key derived from the secret of the app for the purpose
+// boxes of different purposes can not open the secrets of each other
+func NewSecretBox(secret string, purpose string) *SecretBox {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	// a sha-256 sum is always a valid AES-256 key, so neither call fails
+	block, _ := aes.NewCipher(mac.Sum(nil))
+	aead, _ := cipher.NewGCM(block)
+	return &SecretBox{aead: aead}
+}
+
+// Seal encrypts the plaintext, the nonce is prepended and the result is encoded as base64
+func (b *SecretBox) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// Open decrypts a secret of Seal
+func (b *SecretBox) Open(sealed string) (string, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return "", ErrSealedSecret
+	}
+	plaintext, err := b.aead.Open(nil, raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrSealedSecret
+	}
+	return string(plaintext), nil
+}
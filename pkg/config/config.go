@@ -0,0 +1,351 @@
+package config
+
+// Configuration of the whole application
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaults of optional keys
+const (
+	DefaultPort           = 8080
+	DefaultSSLMode        = "require"
+	DefaultDailyPostLimit = 50
+	DefaultCORSOrigin     = "http://localhost:5173"
+	DefaultLogLevel       = "info"
+	DefaultTokenFormat    = "jwt"
+	DefaultTokenAlgorithm = "HS256"
+	DefaultTokenTTL       = 24 * time.Hour
+	DefaultTokenIssuer    = "berliner"
+	DefaultRefreshTTL     = 30 * 24 * time.Hour
+	DefaultRejoinCooldown = 7 * 24 * time.Hour
+	DefaultLoginAttempts  = 5
+	DefaultLoginWindow    = 15 * time.Minute
+	DefaultAuthRequests   = 60
+)
+
+// Config is populated once at startup and passed to the components which need it
+type Config struct {
+	DB     DB
+	JWT    JWT
+	Tokens Tokens
+	Auth   Auth
+	Server Server
+	AWS    AWS
+	Posts  Posts
+	// how channel descriptions are returned
+	Channels Channels
+	Admin    Admin
+	// identity providers users can sign in with
+	OAuth OAuth
+	// page sizes of the paginated endpoints
+	Pagination Pagination
+	Log        Log
+}
+
+// DB holds the connection settings of the database
+type DB struct {
+	User     string
+	Password string
+	Address  string
+	Name     string
+	SSLMode  string
+	// connection pool limits, zero keeps the database/sql defaults
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// JWT holds the key used to sign and verify tokens
+type JWT struct {
+	Secret string
+}
+
+// Tokens holds the settings of the login tokens, they are signed or encrypted with the jwt secret
+type Tokens struct {
+	// jwt or paseto, paseto tokens are v4.local
+	Format string
+	// signing algorithm of jwt tokens, HS256, HS384, HS512 or RS256, RS384, RS512 with a PEM private key as the jwt secret
+	Algorithm string
+	// how long a token is valid
+	TTL time.Duration
+	// written into every token and required when a token is verified
+	Issuer string
+	// like the issuer, empty for tokens without an audience
+	Audience string
+	// how long a refresh token can be exchanged for new tokens
+	RefreshTTL time.Duration
+}
+
+// Auth holds the settings of the registration
+type Auth struct {
+	// when false only admins can create accounts
+	SignupsEnabled bool
+	RateLimit      RateLimit
+	RequestLimit   RequestLimit
+}
+
+// RateLimit holds the limit of failed logins per username and per client ip
+type RateLimit struct {
+	// failed logins allowed in the window, zero turns the limit off
+	MaxAttempts int
+	// the sliding window the failed logins are counted in
+	Window time.Duration
+}
+
+// RequestLimit holds the limit of requests to the authentication routes per client ip
+type RequestLimit struct {
+	// requests allowed per minute, zero turns the limit off
+	PerMinute int
+	// requests allowed at once, zero allows as many as PerMinute
+	Burst int
+}
+
+// Server holds the settings of the http server
+type Server struct {
+	Port int
+	// origins allowed by CORS
+	AllowedOrigins []string
+	TLS            TLS
+}
+
+// TLS holds the certificate of the http server
+type TLS struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+}
+
+// AWS holds the settings of the aws secrets backend
+type AWS struct {
+	Enabled        bool
+	Region         string
+	Endpoint       string
+	SecretsBackend string
+}
+
+// Posts holds the limits of posts
+type Posts struct {
+	// maximum number of posts a user can create per day
+	DailyLimit int
+}
+
+// Channels holds the channel settings
+type Channels struct {
+	// render descriptions as markdown, otherwise they are returned as escaped text
+	MarkdownDescriptions bool
+	// how long a user whose join request was rejected waits before requesting again
+	RejoinCooldown time.Duration
+}
+
+// Pagination holds the page sizes used when a request sets no limit
+type Pagination struct {
+	// page size of the endpoints without their own default, zero keeps the built-in defaults
+	Default  int
+	Defaults PageDefaults
+}
+
+// PageDefaults holds the default page size of each paginated endpoint, read from pagination.defaults.<endpoint>
+type PageDefaults struct {
+	// /feed/channels and /feed/people
+	Feed int
+	// /posts/search
+	Search int
+	// /users/me/channel-suggestions
+	Suggestions int
+	// /users/:id/top-hashtags
+	Hashtags int
+	// /admin/channels/inactive
+	InactiveChannels int
+	// /users/me/requests-inbox
+	Requests int
+	// /users/search
+	Users int
+	// /channels/public
+	Channels int
+}
+
+// Admin holds the platform admins
+type Admin struct {
+	Usernames []string
+}
+
+// OAuth holds the apps registered at the identity providers
+type OAuth struct {
+	Google OAuthClient
+}
+
+// OAuthClient holds an app registered at an identity provider
+type OAuthClient struct {
+	// id of the app, the audience of the id tokens issued to it, signing in with the provider is off when empty
+	ClientId string
+}
+
+// Log holds the logging settings
+type Log struct {
+	// debug, info, warn or error
+	Level string
+}
+
+// FromViper reads the config keys, defaults are used for optional keys which are not set
+// the database credentials and the jwt secret are loaded from the secrets backend by the caller
+func FromViper(v *viper.Viper) Config {
+	cfg := Config{
+		DB: DB{
+			User:    v.GetString("db.user"),
+			Address: v.GetString("db.address"),
+			Name:    v.GetString("db.name"),
+			SSLMode: v.GetString("db.sslmode"),
+
+			MaxOpenConns:    v.GetInt("db.max_open_conns"),
+			MaxIdleConns:    v.GetInt("db.max_idle_conns"),
+			ConnMaxLifetime: v.GetDuration("db.conn_max_lifetime"),
+		},
+		Tokens: Tokens{
+			Format:     v.GetString("tokens.format"),
+			Algorithm:  v.GetString("tokens.algorithm"),
+			TTL:        v.GetDuration("tokens.ttl"),
+			Issuer:     v.GetString("tokens.issuer"),
+			Audience:   v.GetString("tokens.audience"),
+			RefreshTTL: v.GetDuration("tokens.refresh_ttl"),
+		},
+		Auth: Auth{
+			SignupsEnabled: true,
+			RateLimit: RateLimit{
+				MaxAttempts: DefaultLoginAttempts,
+				Window:      v.GetDuration("auth.rate_limit.window"),
+			},
+			RequestLimit: RequestLimit{
+				PerMinute: DefaultAuthRequests,
+				Burst:     v.GetInt("auth.request_limit.burst"),
+			},
+		},
+		Server: Server{
+			Port:           v.GetInt("server.port"),
+			AllowedOrigins: v.GetStringSlice("server.allowed_origins"),
+			TLS: TLS{
+				Enabled:  v.GetBool("server.tls.enabled"),
+				CertFile: v.GetString("server.tls.cert_file"),
+				KeyFile:  v.GetString("server.tls.key_file"),
+			},
+		},
+		AWS: AWS{
+			Enabled:        v.GetBool("aws.enabled"),
+			Region:         v.GetString("aws.region"),
+			Endpoint:       v.GetString("aws.endpoint"),
+			SecretsBackend: v.GetString("aws.secrets_backend"),
+		},
+		Posts: Posts{
+			DailyLimit: DefaultDailyPostLimit,
+		},
+		Channels: Channels{
+			MarkdownDescriptions: v.GetBool("channels.markdown_descriptions"),
+			RejoinCooldown:       DefaultRejoinCooldown,
+		},
+		Admin: Admin{
+			Usernames: v.GetStringSlice("admin.usernames"),
+		},
+		OAuth: OAuth{
+			Google: OAuthClient{ClientId: v.GetString("oauth.google.client_id")},
+		},
+		Pagination: Pagination{
+			Default: v.GetInt("pagination.default"),
+			Defaults: PageDefaults{
+				Feed:             v.GetInt("pagination.defaults.feed"),
+				Search:           v.GetInt("pagination.defaults.search"),
+				Suggestions:      v.GetInt("pagination.defaults.suggestions"),
+				Hashtags:         v.GetInt("pagination.defaults.hashtags"),
+				InactiveChannels: v.GetInt("pagination.defaults.inactive_channels"),
+				Requests:         v.GetInt("pagination.defaults.requests"),
+				Users:            v.GetInt("pagination.defaults.users"),
+				Channels:         v.GetInt("pagination.defaults.channels"),
+			},
+		},
+		Log: Log{
+			Level: v.GetString("log.level"),
+		},
+	}
+	if cfg.Log.Level == "" {
+		cfg.Log.Level = DefaultLogLevel
+	}
+	if cfg.Tokens.Format == "" {
+		cfg.Tokens.Format = DefaultTokenFormat
+	}
+	if cfg.Tokens.Algorithm == "" {
+		cfg.Tokens.Algorithm = DefaultTokenAlgorithm
+	}
+	if cfg.Tokens.TTL == 0 {
+		cfg.Tokens.TTL = DefaultTokenTTL
+	}
+	if cfg.Tokens.Issuer == "" {
+		cfg.Tokens.Issuer = DefaultTokenIssuer
+	}
+	if cfg.Tokens.RefreshTTL == 0 {
+		cfg.Tokens.RefreshTTL = DefaultRefreshTTL
+	}
+	if cfg.Auth.RateLimit.Window == 0 {
+		cfg.Auth.RateLimit.Window = DefaultLoginWindow
+	}
+	if cfg.DB.SSLMode == "" {
+		cfg.DB.SSLMode = DefaultSSLMode
+	}
+	if cfg.Server.Port == 0 {
+		// PORT is still honoured as it was read by gin before the port could be configured
+		if port, err := strconv.Atoi(os.Getenv("PORT")); err == nil {
+			cfg.Server.Port = port
+		} else {
+			cfg.Server.Port = DefaultPort
+		}
+	}
+	if len(cfg.Server.AllowedOrigins) == 0 {
+		cfg.Server.AllowedOrigins = []string{DefaultCORSOrigin}
+	}
+	for _, size := range cfg.Pagination.Defaults.sizes() {
+		if *size == 0 {
+			*size = cfg.Pagination.Default
+		}
+	}
+	if v.IsSet("auth.signups_enabled") {
+		cfg.Auth.SignupsEnabled = v.GetBool("auth.signups_enabled")
+	}
+	if v.IsSet("auth.rate_limit.max_attempts") {
+		cfg.Auth.RateLimit.MaxAttempts = v.GetInt("auth.rate_limit.max_attempts")
+	}
+	if v.IsSet("auth.request_limit.per_minute") {
+		cfg.Auth.RequestLimit.PerMinute = v.GetInt("auth.request_limit.per_minute")
+	}
+	if v.IsSet("channels.rejoin_cooldown") {
+		cfg.Channels.RejoinCooldown = v.GetDuration("channels.rejoin_cooldown")
+	}
+	if v.IsSet("posts.daily_limit") {
+		cfg.Posts.DailyLimit = v.GetInt("posts.daily_limit")
+	}
+	return cfg
+}
+
+// returns the page sizes of all endpoints
+func (d *PageDefaults) sizes() []*int {
+	return []*int{&d.Feed, &d.Search, &d.Suggestions, &d.Hashtags, &d.InactiveChannels, &d.Requests, &d.Users, &d.Channels}
+}
+
+// DSN builds the PostgreSQL DSN
+func (db DB) DSN() string {
+	u := &url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(db.User, db.Password),
+		Host:   db.Address,
+		Path:   db.Name,
+	}
+
+	q := u.Query()
+	q.Set("sslmode", db.SSLMode)
+	q.Set("connect_timeout", "10") // Fail after 10 seconds instead of hanging
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding config keys
+const EnvPrefix = "BERLINER"
+
+// replaces the separators of nested keys
+var envKeyReplacer = strings.NewReplacer(".", "_")
+
+// BindEnv lets environment variables override the config files
+// a key maps to BERLINER_ followed by the upper case key with dots replaced by underscores,
+// e.g. BERLINER_SERVER_PORT overrides server.port and BERLINER_AWS_SECRETS_CACHE_TTL overrides aws.secrets_cache_ttl
+// booleans and durations are parsed like in the yaml files and lists are separated by spaces
+func BindEnv(v *viper.Viper) {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(envKeyReplacer)
+	v.AutomaticEnv()
+}
+
+// EnvVar returns the environment variable overriding a key
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// editors write a file in several steps, events closer than this are reloaded once
+const reloadDebounce = 100 * time.Millisecond
+
+// Watcher reloads the config files at runtime and applies the hot keys to the subscribed components
+// hot keys are log.level, server.allowed_origins, posts.daily_limit and auth.signups_enabled,
+// changes of every other key are logged as requiring a restart and ignored
+type Watcher struct {
+	// reads the config files, without the secrets
+	load func() (Config, error)
+
+	mu sync.Mutex
+	// the applied config, with the secrets
+	current Config
+	// the config files as last read, changes are detected against it
+	file        Config
+	subscribers []func(Config)
+}
+
+// NewWatcher creates a watcher of the running config, load reads the config files again
+func NewWatcher(current Config, load func() (Config, error)) (*Watcher, error) {
+	file, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{load: load, current: current, file: file}, nil
+}
+
+// Subscribe registers a callback called with the new config whenever a hot key changes
+func (w *Watcher) Subscribe(fn func(Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Current returns the applied config
+func (w *Watcher) Current() Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Reload reads the config files and applies the changed hot keys
+// when the files can not be read or the new config is invalid the previous config is kept
+func (w *Watcher) Reload() error {
+	file, err := w.load()
+	if err != nil {
+		return fmt.Errorf("failed to reload config, keeping the previous one: %w", err)
+	}
+
+	w.mu.Lock()
+	next := w.current
+	applyHot(&next, file)
+	if err := next.Validate(); err != nil {
+		w.mu.Unlock()
+		return fmt.Errorf("invalid config, keeping the previous one:\n%w", err)
+	}
+	for _, section := range coldChanges(w.file, file) {
+		log.Printf("warning: config section %s changed, restart to apply it", section)
+	}
+	hot := hotChanges(w.current, next)
+	w.file = file
+	w.current = next
+	subscribers := slices.Clone(w.subscribers)
+	w.mu.Unlock()
+
+	if len(hot) == 0 {
+		return nil
+	}
+	log.Printf("config reloaded, changed %s", strings.Join(hot, ", "))
+	for _, fn := range subscribers {
+		fn(next)
+	}
+	return nil
+}
+
+// Run reloads the config when a file in dir changes or the process receives SIGHUP, until ctx is done
+func (w *Watcher) Run(ctx context.Context, dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// the directory is watched so files replaced by a rename, as kubernetes does with config maps, are seen
+	if err := watcher.Add(dir); err != nil {
+		return err
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.Events:
+			if isConfigFile(event.Name) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce = time.After(reloadDebounce)
+			}
+			continue
+		case err := <-watcher.Errors:
+			log.Printf("warning: watching config files failed: %v", err)
+			continue
+		case <-debounce:
+		case <-hangup:
+		}
+		debounce = nil
+		if err := w.Reload(); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+}
+
+// reports whether name is config.yaml or a profile file
+func isConfigFile(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, "config") && strings.HasSuffix(base, ".yaml")
+}
+
+// copies the hot keys of src into dst
+func applyHot(dst *Config, src Config) {
+	dst.Log = src.Log
+	dst.Server.AllowedOrigins = src.Server.AllowedOrigins
+	dst.Posts = src.Posts
+	dst.Auth = src.Auth
+}
+
+// returns the hot keys which differ
+func hotChanges(old, new Config) []string {
+	var changed []string
+	if old.Log != new.Log {
+		changed = append(changed, "log.level")
+	}
+	if !slices.Equal(old.Server.AllowedOrigins, new.Server.AllowedOrigins) {
+		changed = append(changed, "server.allowed_origins")
+	}
+	if old.Posts != new.Posts {
+		changed = append(changed, "posts.daily_limit")
+	}
+	if old.Auth.SignupsEnabled != new.Auth.SignupsEnabled {
+		changed = append(changed, "auth.signups_enabled")
+	}
+	if old.Auth.RateLimit != new.Auth.RateLimit {
+		changed = append(changed, "auth.rate_limit")
+	}
+	if old.Auth.RequestLimit != new.Auth.RequestLimit {
+		changed = append(changed, "auth.request_limit")
+	}
+	return changed
+}
+
+// returns the sections whose keys other than the hot ones differ
+func coldChanges(old, new Config) []string {
+	applyHot(&old, Config{})
+	applyHot(&new, Config{})
+	var changed []string
+	for _, section := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"db", old.DB, new.DB},
+		{"jwt", old.JWT, new.JWT},
+		{"tokens", old.Tokens, new.Tokens},
+		{"server", old.Server, new.Server},
+		{"aws", old.AWS, new.AWS},
+		{"channels", old.Channels, new.Channels},
+		{"admin", old.Admin, new.Admin},
+		{"oauth", old.OAuth, new.OAuth},
+		{"pagination", old.Pagination, new.Pagination},
+	} {
+		if !reflect.DeepEqual(section.old, section.new) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
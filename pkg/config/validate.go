@@ -0,0 +1,278 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// limits of the config values
+const (
+	minJWTSecretLength = 32
+	maxPoolSize        = 1000
+)
+
+// Validate checks every value of the config and returns all problems at once
+func (c Config) Validate() error {
+	var errs []error
+	for _, field := range []struct{ value, name string }{
+		{c.DB.User, "db.user"},
+		{c.DB.Password, "db password"},
+		{c.DB.Address, "db.address"},
+		{c.DB.Name, "db.name"},
+	} {
+		if field.value == "" {
+			errs = append(errs, fmt.Errorf("%s is not set", field.name))
+		}
+	}
+	switch c.DB.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("db.sslmode is not a valid sslmode: %s", c.DB.SSLMode))
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxOpenConns > maxPoolSize {
+		errs = append(errs, fmt.Errorf("db.max_open_conns must be between 0 and %d: %d", maxPoolSize, c.DB.MaxOpenConns))
+	}
+	if c.DB.MaxIdleConns < 0 || c.DB.MaxIdleConns > maxPoolSize {
+		errs = append(errs, fmt.Errorf("db.max_idle_conns must be between 0 and %d: %d", maxPoolSize, c.DB.MaxIdleConns))
+	}
+	if c.DB.MaxOpenConns > 0 && c.DB.MaxIdleConns > c.DB.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("db.max_idle_conns can not be larger than db.max_open_conns: %d > %d", c.DB.MaxIdleConns, c.DB.MaxOpenConns))
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("db.conn_max_lifetime can not be negative: %s", c.DB.ConnMaxLifetime))
+	}
+
+	if c.JWT.Secret == "" {
+		errs = append(errs, errors.New("jwt secret is not set"))
+	} else if len(c.JWT.Secret) < minJWTSecretLength {
+		errs = append(errs, fmt.Errorf("jwt secret must be at least %d bytes, got %d", minJWTSecretLength, len(c.JWT.Secret)))
+	}
+
+	switch c.Tokens.Format {
+	case "jwt", "paseto":
+	default:
+		errs = append(errs, fmt.Errorf("tokens.format must be jwt or paseto: %s", c.Tokens.Format))
+	}
+	switch c.Tokens.Algorithm {
+	case "HS256", "HS384", "HS512":
+	case "RS256", "RS384", "RS512":
+		if c.Tokens.Format != "jwt" {
+			errs = append(errs, fmt.Errorf("tokens.algorithm %s needs tokens.format jwt: %s", c.Tokens.Algorithm, c.Tokens.Format))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tokens.algorithm must be HS256, HS384, HS512, RS256, RS384 or RS512: %s", c.Tokens.Algorithm))
+	}
+	if c.Tokens.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("tokens.ttl must be positive: %s", c.Tokens.TTL))
+	}
+	if c.Tokens.RefreshTTL <= 0 {
+		errs = append(errs, fmt.Errorf("tokens.refresh_ttl must be positive: %s", c.Tokens.RefreshTTL))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port must be between 1 and 65535: %d", c.Server.Port))
+	}
+	for _, origin := range c.Server.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("server.allowed_origins has an invalid origin: %s", origin))
+		}
+	}
+	if c.Server.TLS.Enabled {
+		for _, file := range []struct{ path, key string }{
+			{c.Server.TLS.CertFile, "server.tls.cert_file"},
+			{c.Server.TLS.KeyFile, "server.tls.key_file"},
+		} {
+			if file.path == "" {
+				errs = append(errs, fmt.Errorf("%s is not set", file.key))
+			} else if _, err := os.Stat(file.path); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", file.key, err))
+			}
+		}
+	}
+
+	if c.Posts.DailyLimit < 0 {
+		errs = append(errs, fmt.Errorf("posts.daily_limit can not be negative: %d", c.Posts.DailyLimit))
+	}
+	if c.Auth.RateLimit.MaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("auth.rate_limit.max_attempts can not be negative: %d", c.Auth.RateLimit.MaxAttempts))
+	}
+	if c.Auth.RateLimit.MaxAttempts > 0 && c.Auth.RateLimit.Window <= 0 {
+		errs = append(errs, fmt.Errorf("auth.rate_limit.window must be positive: %s", c.Auth.RateLimit.Window))
+	}
+	if c.Auth.RequestLimit.PerMinute < 0 {
+		errs = append(errs, fmt.Errorf("auth.request_limit.per_minute can not be negative: %d", c.Auth.RequestLimit.PerMinute))
+	}
+	if c.Auth.RequestLimit.Burst < 0 {
+		errs = append(errs, fmt.Errorf("auth.request_limit.burst can not be negative: %d", c.Auth.RequestLimit.Burst))
+	}
+	if c.Channels.RejoinCooldown < 0 {
+		errs = append(errs, fmt.Errorf("channels.rejoin_cooldown can not be negative: %s", c.Channels.RejoinCooldown))
+	}
+	if c.Pagination.Default < 0 {
+		errs = append(errs, fmt.Errorf("pagination.default can not be negative: %d", c.Pagination.Default))
+	}
+	for _, page := range []struct {
+		size     int
+		endpoint string
+	}{
+		{c.Pagination.Defaults.Feed, "feed"},
+		{c.Pagination.Defaults.Search, "search"},
+		{c.Pagination.Defaults.Suggestions, "suggestions"},
+		{c.Pagination.Defaults.Hashtags, "hashtags"},
+		{c.Pagination.Defaults.InactiveChannels, "inactive_channels"},
+		{c.Pagination.Defaults.Requests, "requests"},
+		{c.Pagination.Defaults.Users, "users"},
+		{c.Pagination.Defaults.Channels, "channels"},
+	} {
+		if page.size < 0 {
+			errs = append(errs, fmt.Errorf("pagination.defaults.%s can not be negative: %d", page.endpoint, page.size))
+		}
+	}
+	if _, err := c.Log.SlogLevel(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// SlogLevel returns the log level as a slog level
+func (l Log) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	switch l.Level {
+	case "debug", "info", "warn", "error":
+		return level, level.UnmarshalText([]byte(l.Level))
+	}
+	return level, fmt.Errorf("log.level must be debug, info, warn or error: %s", l.Level)
+}
+
+// kinds of the keys which are not strings
+var typedKeys = []struct{ key, kind string }{
+	{"db.max_open_conns", "an integer"},
+	{"db.max_idle_conns", "an integer"},
+	{"db.conn_max_lifetime", "a duration"},
+	{"db.rotation.failure_threshold", "an integer"},
+	{"db.rotation.poll_interval", "a duration"},
+	{"server.port", "an integer"},
+	{"server.tls.enabled", "a boolean"},
+	{"tokens.ttl", "a duration"},
+	{"tokens.refresh_ttl", "a duration"},
+	{"auth.signups_enabled", "a boolean"},
+	{"auth.rate_limit.max_attempts", "an integer"},
+	{"auth.rate_limit.window", "a duration"},
+	{"auth.request_limit.per_minute", "an integer"},
+	{"auth.request_limit.burst", "an integer"},
+	{"posts.daily_limit", "an integer"},
+	{"channels.markdown_descriptions", "a boolean"},
+	{"channels.rejoin_cooldown", "a duration"},
+	{"pagination.default", "an integer"},
+	{"pagination.defaults.feed", "an integer"},
+	{"pagination.defaults.search", "an integer"},
+	{"pagination.defaults.suggestions", "an integer"},
+	{"pagination.defaults.hashtags", "an integer"},
+	{"pagination.defaults.inactive_channels", "an integer"},
+	{"pagination.defaults.requests", "an integer"},
+	{"pagination.defaults.users", "an integer"},
+	{"pagination.defaults.channels", "an integer"},
+	{"aws.enabled", "a boolean"},
+	{"aws.secrets_cache_ttl", "a duration"},
+	{"aws.secrets_serve_stale", "a boolean"},
+	{"aws.secrets_retry.max_attempts", "an integer"},
+	{"aws.secrets_retry.base_delay", "a duration"},
+	{"aws.secrets_retry.max_delay", "a duration"},
+	{"aws.secrets_retry.attempt_timeout", "a duration"},
+	{"vault.secrets_cache_ttl", "a duration"},
+	{"vault.secrets_serve_stale", "a boolean"},
+	{"vault.secrets_retry.max_attempts", "an integer"},
+	{"vault.secrets_retry.base_delay", "a duration"},
+	{"vault.secrets_retry.max_delay", "a duration"},
+	{"vault.secrets_retry.attempt_timeout", "a duration"},
+}
+
+// CheckTypes returns all values which can not be parsed as the type of their key
+// viper reads such values as zero, so they are rejected before the config is used
+// values set by an environment variable are reported with its name
+func CheckTypes(v *viper.Viper) error {
+	var errs []error
+	for _, typed := range typedKeys {
+		if !v.IsSet(typed.key) {
+			continue
+		}
+		raw := fmt.Sprint(v.Get(typed.key))
+		var err error
+		switch typed.kind {
+		case "an integer":
+			_, err = strconv.Atoi(raw)
+		case "a boolean":
+			_, err = strconv.ParseBool(raw)
+		case "a duration":
+			// a plain number is read as nanoseconds
+			if _, numErr := strconv.Atoi(raw); numErr != nil {
+				_, err = time.ParseDuration(raw)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		name := typed.key
+		if _, ok := os.LookupEnv(EnvVar(typed.key)); ok {
+			name += " (" + EnvVar(typed.key) + ")"
+		}
+		errs = append(errs, fmt.Errorf("%s must be %s: %s", name, typed.kind, raw))
+	}
+	return errors.Join(errs...)
+}
+
+// keys read by the application and by the migration tool of berliner_database
+// a key ending with .* allows every key below it
+var knownKeys = []string{
+	"db.user", "db.password", "db.name", "db.address", "db.protocol", "db.migrationsurl", "db.sslmode",
+	"db.max_open_conns", "db.max_idle_conns", "db.conn_max_lifetime",
+	"db.rotation.failure_threshold", "db.rotation.poll_interval",
+	"server.port", "server.allowed_origins", "server.tls.enabled", "server.tls.cert_file", "server.tls.key_file",
+	"tokens.format", "tokens.algorithm", "tokens.ttl", "tokens.issuer", "tokens.audience", "tokens.refresh_ttl",
+	"auth.signups_enabled", "auth.rate_limit.max_attempts", "auth.rate_limit.window",
+	"auth.request_limit.per_minute", "auth.request_limit.burst",
+	"posts.daily_limit",
+	"channels.markdown_descriptions", "channels.rejoin_cooldown",
+	"admin.usernames",
+	"oauth.google.client_id",
+	"pagination.default", "pagination.defaults.feed", "pagination.defaults.search", "pagination.defaults.suggestions",
+	"pagination.defaults.hashtags", "pagination.defaults.inactive_channels", "pagination.defaults.requests",
+	"pagination.defaults.users", "pagination.defaults.channels",
+	"log.level",
+	"secrets.backend",
+	"aws.enabled", "aws.region", "aws.endpoint", "aws.assume_role_arn", "aws.external_id", "aws.secrets_backend", "aws.secrets_cache_ttl", "aws.secrets_serve_stale",
+	"aws.secrets_retry.max_attempts", "aws.secrets_retry.base_delay", "aws.secrets_retry.max_delay", "aws.secrets_retry.attempt_timeout",
+	"aws.secrets.*",
+	"vault.address", "vault.mount", "vault.auth", "vault.kubernetes_role", "vault.kubernetes_mount", "vault.kubernetes_token_path", "vault.approle_role_id", "vault.approle_mount",
+	"vault.secrets_cache_ttl", "vault.secrets_serve_stale",
+	"vault.secrets_retry.max_attempts", "vault.secrets_retry.base_delay", "vault.secrets_retry.max_delay", "vault.secrets_retry.attempt_timeout",
+	"vault.secrets.*",
+	"file.dir", "file.secrets.db_password", "file.secrets.jwt_secret",
+}
+
+// UnknownKeys returns the keys of the config which are not read by anything, usually typos
+func UnknownKeys(v *viper.Viper) []string {
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		known := slices.ContainsFunc(knownKeys, func(known string) bool {
+			if prefix, ok := strings.CutSuffix(known, "*"); ok {
+				return strings.HasPrefix(key, prefix)
+			}
+			return key == known
+		})
+		if !known {
+			unknown = append(unknown, key)
+		}
+	}
+	slices.Sort(unknown)
+	return unknown
+}
@@ -0,0 +1,120 @@
+package handler
+
+// Handler for admin apis
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// method for creating a user, allowed even when signups are disabled
+func (h Handler) createUser(ctx *gin.Context) {
+	var user models.User
+	if err := ctx.BindJSON(&user); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if invalid := h.services.Authorization.CreateUser(user); len(invalid) > 0 {
+		if err, ok := invalid["error"]; ok {
+			ctx.AbortWithError(500, errors.New(err))
+			return
+		}
+		ctx.AbortWithStatusJSON(422, invalid)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for getting totals of the whole instance
+func (h Handler) getInstanceStats(ctx *gin.Context) {
+	ans, err := h.services.Api.GetInstanceStats()
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// default number of days without posts after which a channel or a followed user is inactive
+const defaultInactiveDays = 90
+
+// method for getting channels without posts in the last days
+func (h Handler) getInactiveChannels(ctx *gin.Context) {
+	days, err := strconv.Atoi(ctx.DefaultQuery("days", strconv.Itoa(defaultInactiveDays)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.InactiveChannels)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetInactiveChannels(time.Duration(days)*24*time.Hour, limit)
+	if errors.Is(err, services.ErrInvalidInactivity) {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for creating an api key of a service, the key is only in this response
+func (h Handler) createAPIKey(ctx *gin.Context) {
+	var form models.APIKeyForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	key, apiKey, err := h.services.Authorization.CreateAPIKey(form)
+	if errors.Is(err, services.ErrAPIKeyName) {
+		ctx.AbortWithStatusJSON(422, map[string]string{"name": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrAPIKeyScope) {
+		ctx.AbortWithStatusJSON(422, map[string]string{"scopes": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{"key": key, "apiKey": apiKey})
+}
+
+// method for getting all api keys, the keys themselves are never returned
+func (h Handler) getAPIKeys(ctx *gin.Context) {
+	ans, err := h.services.Authorization.GetAPIKeys()
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for revoking an api key
+func (h Handler) revokeAPIKey(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	err = h.services.Authorization.RevokeAPIKey(id)
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
@@ -5,8 +5,11 @@ package handler
 import (
 	"errors"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -45,20 +48,37 @@ func (h Handler) createChannel(ctx *gin.Context) {
 	ctx.JSON(200, gin.H{})
 }
 
-// method for posting a post
+// method for posting a post of the user, or of the channel of the id query when its author type is channel
+// posting in a channel the user does not lead or edit gets 403
 func (h Handler) createPost(ctx *gin.Context) {
 	var post models.Post
-	id, err := strconv.Atoi(ctx.Query("id"))
-	if err != nil {
-		ctx.AbortWithError(400, err)
-	}
 	if err := ctx.BindJSON(&post); err != nil {
 		ctx.AbortWithError(401, errors.New("input json can not be marshalled to the post model"))
 		return
 	}
-	if invalid := h.services.Api.CreatePost(post, id); len(invalid) > 0 {
+	var channelId int
+	if post.AuthorType == "channel" {
+		id, err := strconv.Atoi(ctx.Query("id"))
+		if err != nil {
+			ctx.AbortWithError(400, err)
+			return
+		}
+		channelId = id
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	if invalid := h.services.Api.CreatePostAs(post, channelId, user); len(invalid) > 0 {
 		if err, ok := invalid["error"]; ok {
 			ctx.AbortWithError(500, errors.New(err))
+			return
+		}
+		if _, ok := invalid["common"]; ok {
+			ctx.AbortWithStatusJSON(403, invalid)
+			return
+		}
+		if err, ok := invalid["quota"]; ok {
+			ctx.AbortWithError(429, errors.New(err))
+			return
 		}
 		ctx.AbortWithError(422, errors.New("invalid data"))
 		return
@@ -79,6 +99,27 @@ func (h Handler) deletePost(ctx *gin.Context) {
 	ctx.JSON(200, gin.H{})
 }
 
+// method for deleting many posts at once
+func (h Handler) deletePosts(ctx *gin.Context) {
+	var form models.BulkDeleteForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	ans, err := h.services.Api.DeletePosts(user, form.AuthorType, form.Ids)
+	if errors.Is(err, services.ErrBulkDeleteLimit) || errors.Is(err, services.ErrInvalidAuthorType) {
+		ctx.AbortWithError(422, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
 // method for reading posts
 func (h Handler) getPosts(ctx *gin.Context) {
 	res, _ := ctx.Get("user")
@@ -102,6 +143,114 @@ func (h Handler) getPosts(ctx *gin.Context) {
 	ctx.JSON(200, ans)
 }
 
+// method for reading a page of the posts of the user's channels and the followed users together
+func (h Handler) getFeed(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Feed)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetAllPosts(user, limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for reading a page of the posts of the user's channels
+func (h Handler) getChannelFeed(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Feed)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetChannelFeed(user, limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for reading a page of the public channels, used to generate the sitemap
+func (h Handler) getPublicChannels(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Channels)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetAllPublicChannels(limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for reading a page of the pending requests to the channels the user leads
+func (h Handler) getRequestsInbox(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Requests)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetAllPendingRequestsForLeader(user.Id, limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for reading a page of the posts of the followed users
+func (h Handler) getPeopleFeed(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Feed)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetPeopleFeed(user, limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
 func (h Handler) getMyChannelPosts(ctx *gin.Context) {
 	res, _ := ctx.Get("user")
 	user := res.(models.User)
@@ -189,6 +338,178 @@ func (h Handler) getFollowing(ctx *gin.Context) {
 	ctx.JSON(200, ans)
 }
 
+// method for unfollowing the followed users who have not posted in the last days
+func (h Handler) cleanupFollowing(ctx *gin.Context) {
+	days, err := strconv.Atoi(ctx.DefaultQuery("days", strconv.Itoa(defaultInactiveDays)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	ans, err := h.services.Api.UnfollowInactive(user, time.Duration(days)*24*time.Hour)
+	if errors.Is(err, services.ErrInvalidInactivity) {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for getting the followers of the user
+func (h Handler) getFollowers(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	ans, err := h.services.Api.GetFollowers(user)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for searching posts by their content
+func (h Handler) searchPosts(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Search)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.SearchPosts(user, ctx.Query("q"), limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for getting posts by their hashtags, ?tags= is comma separated and ?mode= is and or or
+func (h Handler) getPostsByHashtags(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Search)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetPostsByHashtags(user, strings.Split(ctx.Query("tags"), ","), ctx.Query("mode"), limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for getting the public profile of a user, without the password and the email
+func (h Handler) getUser(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	user, err := h.services.Api.GetUserById(id)
+	if errors.Is(err, services.ErrUserNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, publicUser(user))
+}
+
+// method for searching users by the start of their username or names
+func (h Handler) searchUsers(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Users)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	users, err := h.services.Api.SearchUsers(ctx.Query("q"), limit, offset)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ans := []gin.H{}
+	for _, user := range users {
+		ans = append(ans, publicUser(user))
+	}
+	ctx.JSON(200, ans)
+}
+
+// returns the fields of the user which anyone logged in can see
+func publicUser(user models.User) gin.H {
+	return gin.H{
+		"id":        user.Id,
+		"username":  user.Username,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+	}
+}
+
+// method for getting channels suggested to the user
+func (h Handler) getChannelSuggestions(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Suggestions)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.SuggestChannels(user.Id, limit)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for getting the channels of the user with the role of the user in each
+func (h Handler) getMyChannelRoles(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	ans, err := h.services.Api.GetMyChannelRoles(user.Id)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for downloading all posts of the user as a JSON file
+func (h Handler) exportUserPosts(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	ans, err := h.services.Api.ExportUserPosts(user.Id)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.Header("Content-Disposition", `attachment; filename="posts.json"`)
+	ctx.JSON(200, ans)
+}
+
 // function for deleting a channel based on its id
 func (h Handler) deleteChannel(ctx *gin.Context) {
 	var channel models.Channel
@@ -216,3 +537,357 @@ func (h Handler) updateChannel(ctx *gin.Context) {
 
 	ctx.JSON(200, gin.H{})
 }
+
+// method for activating or deactivating a channel
+func (h Handler) setChannelActive(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	var form models.ChannelActiveForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	err = h.services.Api.SetChannelActive(id, form.Active, user)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if errors.Is(err, services.ErrNotChannelLeader) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for opening or closing a channel to join requests
+func (h Handler) setAcceptingMembers(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	var form models.ChannelAcceptingForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	err = h.services.Api.SetAcceptingMembers(id, form.Accepting, user)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if errors.Is(err, services.ErrNotChannelLeader) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for requesting to join a channel
+func (h Handler) requestToJoin(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	err = h.services.Api.RequestToJoin(id, user)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if errors.Is(err, services.ErrChannelClosed) {
+		ctx.AbortWithError(409, err)
+		return
+	}
+	var cooldown *services.RequestCooldownError
+	if errors.As(err, &cooldown) {
+		// whole seconds, rounded up so a retry at the given time is allowed
+		wait := cooldown.RetryAt.Sub(h.services.Clock.Now())
+		ctx.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		ctx.AbortWithError(429, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for withdrawing the pending join request of the user
+func (h Handler) cancelJoinRequest(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	err = h.services.Api.CancelJoinRequest(id, user)
+	if errors.Is(err, services.ErrRequestNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for rejecting the pending join request of a user, only for the leader and editors
+func (h Handler) rejectJoinRequest(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	userId, err := strconv.Atoi(ctx.Param("userId"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	err = h.services.Api.RejectJoinRequest(id, userId, user)
+	if errors.Is(err, services.ErrChannelNotFound) || errors.Is(err, services.ErrRequestNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if errors.Is(err, services.ErrNotChannelEditor) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for counting the pending join requests of a channel
+func (h Handler) getPendingRequestCount(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	count, err := h.services.Api.GetPendingRequestCount(id, user)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if errors.Is(err, services.ErrNotChannelEditor) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{"count": count})
+}
+
+// method for getting the relationship of the user to a channel
+func (h Handler) getChannelRelationship(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	ans, err := h.services.Api.GetChannelRelationship(id, user)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for getting the leader of a channel
+func (h Handler) getChannelLeader(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, found, err := h.services.Api.GetChannelLeader(id)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	if !found {
+		ctx.AbortWithError(404, errors.New("channel has no leader"))
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for hiding a user's posts from the current user's feed
+func (h Handler) muteUser(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	err = h.services.Api.MuteUser(user, id)
+	if errors.Is(err, services.ErrMuteSelf) {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if errors.Is(err, services.ErrUserNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for showing a muted user's posts in the current user's feed again
+func (h Handler) unmuteUser(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	if err := h.services.Api.UnmuteUser(user, id); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// method for getting the most used hashtags of a user
+func (h Handler) getUserTopHashtags(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(h.pagination.Defaults.Hashtags)))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetUserTopHashtags(id, limit)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// default range of the follower growth and the post frequency when from is not given
+const defaultGrowthRange = 30 * 24 * time.Hour
+
+// reads the from and to query parameters of a growth range, to defaults to now and from to 30 days before to
+func (h Handler) growthRange(ctx *gin.Context) (time.Time, time.Time, error) {
+	to := h.services.Clock.Now()
+	if value, ok := ctx.GetQuery("to"); ok {
+		var err error
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return to, to, err
+		}
+	}
+	from := to.Add(-defaultGrowthRange)
+	if value, ok := ctx.GetQuery("from"); ok {
+		var err error
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
+}
+
+// method for getting the number of new followers of the user per day or week
+func (h Handler) getFollowerGrowth(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+
+	from, to, err := h.growthRange(ctx)
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+
+	ans, err := h.services.Api.GetFollowerGrowth(user.Id, from, to, ctx.DefaultQuery("bucket", services.BucketDay))
+	if errors.Is(err, services.ErrInvalidBucket) || errors.Is(err, services.ErrInvalidGrowthRange) {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
+
+// method for getting the number of posts of a channel per day or week, only the leader of the channel can see it
+func (h Handler) getChannelPostFrequency(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+
+	from, to, err := h.growthRange(ctx)
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ans, err := h.services.Api.GetChannelPostFrequency(id, from, to, ctx.DefaultQuery("bucket", services.BucketDay), user)
+	if errors.Is(err, services.ErrChannelNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if errors.Is(err, services.ErrNotChannelLeader) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidBucket) || errors.Is(err, services.ErrInvalidGrowthRange) {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, ans)
+}
@@ -1,14 +1,33 @@
 package handler
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
+// names of the cookies and header used by cookie based authentication
+const (
+	sessionCookie = "session"
+	refreshCookie = "refresh_token"
+	csrfCookie    = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// the refresh cookie is only sent to the auth routes
+const refreshCookiePath = "/auth"
+
 type UserRepository interface {
 	AddUser()
 }
@@ -26,6 +45,10 @@ func (h *Handler) signUp(ctx *gin.Context) {
 
 	//check if user data is valid
 	if invalid := h.services.Authorization.AddUser(user); len(invalid) > 0 {
+		if err, ok := invalid["signups"]; ok {
+			ctx.AbortWithError(403, errors.New(err))
+			return
+		}
 		if err, ok := invalid["error"]; ok {
 			ctx.AbortWithError(500, errors.New(err))
 		}
@@ -33,6 +56,10 @@ func (h *Handler) signUp(ctx *gin.Context) {
 		fmt.Println(invalid)
 		return
 	}
+	// the account exists either way, a verification token can be requested again after logging in
+	if err := h.requestVerification(user.Username); err != nil {
+		slog.Warn("failed to create email verification token", "username", user.Username, "error", err)
+	}
 	ctx.JSON(200, gin.H{})
 }
 
@@ -45,20 +72,500 @@ func (h *Handler) login(ctx *gin.Context) {
 		return
 	}
 
+	// failed logins are counted per username and per client ip
+	keys := []string{"username:" + user.Username, "ip:" + ctx.ClientIP()}
+	if h.loginLimiter != nil {
+		if wait := h.loginLimiter.RetryAfter(keys...); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			ctx.AbortWithError(429, errors.New("too many failed logins"))
+			return
+		}
+	}
+
 	//check if user data is valid
-	exist, err := h.services.Authorization.CheckUserAndPassword(user)
+	exist, verified, err := h.services.Authorization.CheckUserAndPassword(user)
+	// not a guessed password, so it is not counted as a failed login
+	var providerErr *services.ProviderAccountError
+	if errors.As(err, &providerErr) {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(401, gin.H{"error": "password_not_set", "provider": providerErr.Provider})
+		return
+	}
 	if !exist || err != nil {
+		if h.loginLimiter != nil {
+			h.loginLimiter.Fail(keys...)
+		}
 		ctx.AbortWithError(401, errors.New("username or password is incorrect"))
 		return
 	}
-	// generate token
-	token, err := h.services.Authorization.GenerateToken(user, time.Now(), time.Now().Add(time.Hour*24))
+	if h.loginLimiter != nil {
+		h.loginLimiter.Reset(keys[0])
+	}
+	// users with two-factor authentication get the tokens from /auth/2fa/verify with a code
+	mfaToken, err := h.services.Authorization.StartMFAChallenge(user, deviceName(ctx.Request.UserAgent()))
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	if mfaToken != "" {
+		ctx.JSON(200, gin.H{"mfaRequired": true, "mfaToken": mfaToken})
+		return
+	}
+	// generate tokens
+	pair, err := h.services.Authorization.GenerateTokenPair(user, deviceName(ctx.Request.UserAgent()))
 	if err != nil {
 		ctx.AbortWithError(500, errors.New(err.Error()))
 		return
 	}
+	h.sendTokens(ctx, pair, gin.H{"isVerified": verified})
+}
+
+// verify method completing a password login of a user with two-factor authentication
+// wrong codes are counted as failed logins of the client ip
+func (h *Handler) verifyMFA(ctx *gin.Context) {
+	var form models.MFAVerifyForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	key := "ip:" + ctx.ClientIP()
+	if h.loginLimiter != nil {
+		if wait := h.loginLimiter.RetryAfter(key); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			ctx.AbortWithError(429, errors.New("too many failed logins"))
+			return
+		}
+	}
+	pair, err := h.services.Authorization.VerifyMFA(form.MFAToken, form.Code)
+	if errors.Is(err, services.ErrInvalidMFACode) && h.loginLimiter != nil {
+		h.loginLimiter.Fail(key)
+	}
+	if errors.Is(err, services.ErrInvalidMFAToken) || errors.Is(err, services.ErrInvalidMFACode) {
+		ctx.AbortWithError(401, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	h.sendTokens(ctx, pair, gin.H{})
+}
+
+// google sign in method, exchanges a Google id token for the same tokens as a login
+// the account with the email of the token is signed in, or created when there is none
+func (h *Handler) googleSignIn(ctx *gin.Context) {
+	if h.googleVerifier == nil {
+		ctx.AbortWithError(404, errors.New("signing in with google is not configured"))
+		return
+	}
+	var form models.IdTokenForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	identity, err := h.googleVerifier.Verify(ctx.Request.Context(), form.IdToken)
+	if errors.Is(err, auth.ErrEmailNotVerified) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(401, err)
+		return
+	}
+	pair, err := h.services.Authorization.SignInWithProvider(services.GoogleProvider, identity, deviceName(ctx.Request.UserAgent()))
+	if errors.Is(err, services.ErrSignupsDisabled) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if errors.Is(err, services.ErrUnverifiedAccount) {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(409, gin.H{"error": "email_not_verified"})
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	h.sendTokens(ctx, pair, gin.H{"isVerified": true})
+}
+
+// refresh method for exchanging a refresh token for new tokens
+// the refresh token is read from the body or, in cookie mode, from the refresh cookie
+func (h *Handler) refresh(ctx *gin.Context) {
+	var form models.RefreshForm
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.BindJSON(&form); err != nil {
+			ctx.AbortWithError(400, err)
+			return
+		}
+	}
+	if form.RefreshToken == "" {
+		form.RefreshToken, _ = ctx.Cookie(refreshCookie)
+	}
+	pair, err := h.services.Authorization.RefreshTokens(form.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenExpired) || errors.Is(err, services.ErrRefreshTokenRevoked) {
+		ctx.AbortWithError(401, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	h.sendTokens(ctx, pair, gin.H{})
+}
+
+// responds with the tokens, cookie mode keeps them out of reach of the client's javascript
+func (h *Handler) sendTokens(ctx *gin.Context, pair services.TokenPair, body gin.H) {
+	if ctx.Query("mode") == "cookie" {
+		now := h.services.Clock.Now()
+		ctx.SetSameSite(http.SameSiteLaxMode)
+		ctx.SetCookie(sessionCookie, pair.AccessToken, int(pair.AccessExpiresAt.Sub(now).Seconds()), "/", "", true, true)
+		ctx.SetCookie(refreshCookie, pair.RefreshToken, int(pair.RefreshExpiresAt.Sub(now).Seconds()), refreshCookiePath, "", true, true)
+		ctx.JSON(200, body)
+		return
+	}
+	body["token"] = pair.AccessToken
+	body["refreshToken"] = pair.RefreshToken
+	ctx.JSON(200, body)
+}
+
+// requestVerification creates an email verification token of the user, replacing the previous one
+func (h *Handler) requestVerification(username string) error {
+	token, err := h.services.Authorization.GenerateVerificationToken(username)
+	if err != nil {
+		return err
+	}
+	// there is no mail delivery yet, the token is only logged at debug level for development
+	slog.Debug("email verification requested", "username", username, "token", token)
+	return nil
+}
+
+// resendVerification method for requesting a new email verification token, earlier tokens stop working
+func (h *Handler) resendVerification(ctx *gin.Context) {
+	res, _ := ctx.Get("user")
+	user := res.(models.User)
+	token, err := h.services.Authorization.ResendVerification(user.Username)
+	if errors.Is(err, services.ErrVerificationCooldown) {
+		ctx.AbortWithError(429, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	slog.Debug("email verification requested", "username", user.Username, "token", token)
+	ctx.JSON(200, gin.H{})
+}
+
+// verifyEmail method for verifying the email of the user a verification token was sent to
+func (h *Handler) verifyEmail(ctx *gin.Context) {
+	err := h.services.Authorization.VerifyEmail(ctx.Query("token"))
+	if errors.Is(err, services.ErrInvalidVerificationToken) || errors.Is(err, services.ErrVerificationTokenExpired) {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// passwordCheck method for checking a password against the password policy without creating a user
+// responds with every unmet rule, so signup forms can show a checklist
+func (h *Handler) passwordCheck(ctx *gin.Context) {
+	var form models.PasswordCheckForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	unmet := h.services.Authorization.CheckPasswordPolicy(form.Password)
 	ctx.JSON(200, gin.H{
-		"token": token,
+		"valid":  len(unmet) == 0,
+		"errors": unmet,
 	})
+}
+
+// forgot password method for requesting a reset token, the response is the same whether the email has an account
+func (h *Handler) forgotPassword(ctx *gin.Context) {
+	var form models.ForgotPasswordForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	token, err := h.services.Authorization.RequestPasswordReset(form.Email)
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	if token != "" {
+		// there is no mail delivery yet, the token is only logged at debug level for development
+		slog.Debug("password reset requested", "email", form.Email, "token", token)
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// reset password method, rejected passwords and tokens get 422 with the fields of signup
+func (h *Handler) resetPassword(ctx *gin.Context) {
+	var form models.ResetPasswordForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if invalid := h.services.Authorization.ResetPassword(form.Token, form.Password); len(invalid) > 0 {
+		if err, ok := invalid["error"]; ok {
+			ctx.AbortWithError(500, errors.New(err))
+			return
+		}
+		ctx.AbortWithStatusJSON(422, invalid)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
 
+// update method for the names and the email of the logged in user, rejected fields get 422
+func (h *Handler) updateUser(ctx *gin.Context) {
+	var updates models.UserUpdate
+	if err := ctx.BindJSON(&updates); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if invalid := h.services.Authorization.UpdateUser(ctx.GetString("username"), updates); len(invalid) > 0 {
+		if err, ok := invalid["error"]; ok {
+			ctx.AbortWithError(500, errors.New(err))
+			return
+		}
+		ctx.AbortWithStatusJSON(422, invalid)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// change password method of the logged in user, a wrong current password or a rejected new one gets 422
+func (h *Handler) changePassword(ctx *gin.Context) {
+	var form models.ChangePasswordForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	username := ctx.GetString("username")
+	if invalid := h.services.Authorization.ChangePassword(username, form.CurrentPassword, form.NewPassword); len(invalid) > 0 {
+		if err, ok := invalid["error"]; ok {
+			ctx.AbortWithError(500, errors.New(err))
+			return
+		}
+		ctx.AbortWithStatusJSON(422, invalid)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// logout method for clearing session and csrf cookies, the access token of the Authorization header or
+// the session cookie and the refresh token of the refresh cookie are revoked
+func (h *Handler) logout(ctx *gin.Context) {
+	if access := accessToken(ctx); access != "" {
+		if err := h.services.Authorization.RevokeToken(access); err != nil {
+			ctx.AbortWithError(500, err)
+			return
+		}
+	}
+	if token, err := ctx.Cookie(refreshCookie); err == nil {
+		if err := h.services.Authorization.RevokeRefreshToken(token); err != nil {
+			ctx.AbortWithError(500, err)
+			return
+		}
+	}
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(sessionCookie, "", -1, "/", "", true, true)
+	ctx.SetCookie(refreshCookie, "", -1, refreshCookiePath, "", true, true)
+	ctx.SetCookie(csrfCookie, "", -1, "/", "", true, false)
+	ctx.JSON(200, gin.H{})
+}
+
+// delete account method of the logged in user, its access token is revoked and the cookies are cleared
+// refresh tokens are deleted with the user
+func (h *Handler) deleteUser(ctx *gin.Context) {
+	if err := h.services.Authorization.DeleteUser(ctx.GetString("username")); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	if err := h.services.Authorization.RevokeToken(accessToken(ctx)); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(sessionCookie, "", -1, "/", "", true, true)
+	ctx.SetCookie(refreshCookie, "", -1, refreshCookiePath, "", true, true)
+	ctx.SetCookie(csrfCookie, "", -1, "/", "", true, false)
+	ctx.JSON(200, gin.H{})
+}
+
+// sessions method listing the sessions of the logged in user which can still be refreshed
+func (h *Handler) getSessions(ctx *gin.Context) {
+	sessions, err := h.services.Authorization.GetSessions(ctx.GetString("username"), ctx.GetInt("sessionId"))
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{"sessions": sessions})
+}
+
+// revoke session method, the refresh tokens of the session are invalidated at once
+func (h *Handler) revokeSession(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	err = h.services.Authorization.RevokeSession(ctx.GetString("username"), id)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// revoke other sessions method, only the session of the access token is kept
+// access tokens issued before sessions were added keep none
+func (h *Handler) revokeOtherSessions(ctx *gin.Context) {
+	if err := h.services.Authorization.RevokeOtherSessions(ctx.GetString("username"), ctx.GetInt("sessionId")); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// enable 2fa method, responds with a new TOTP secret and recovery codes of the logged in user
+// codes are asked for at login once the secret is confirmed
+func (h *Handler) enableMFA(ctx *gin.Context) {
+	setup, err := h.services.Authorization.EnableMFA(ctx.GetString("username"))
+	if errors.Is(err, services.ErrMFAEnabled) {
+		ctx.AbortWithError(409, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, setup)
+}
+
+// confirm 2fa method, enables two-factor authentication with a first code of the authenticator app
+func (h *Handler) confirmMFA(ctx *gin.Context) {
+	var form models.MFACodeForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	err := h.services.Authorization.ConfirmMFA(ctx.GetString("username"), form.Code)
+	if errors.Is(err, services.ErrMFAEnabled) || errors.Is(err, services.ErrMFANotSetUp) {
+		ctx.AbortWithError(409, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMFACode) {
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// disable 2fa method, a current code or a recovery code is needed so a stolen access token can not turn it off
+// wrong codes are counted like failed logins of the user
+func (h *Handler) disableMFA(ctx *gin.Context) {
+	var form models.MFACodeForm
+	if err := ctx.BindJSON(&form); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	username := ctx.GetString("username")
+	key := "mfa:" + username
+	if h.loginLimiter != nil {
+		if wait := h.loginLimiter.RetryAfter(key); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			ctx.AbortWithError(429, errors.New("too many wrong codes"))
+			return
+		}
+	}
+	err := h.services.Authorization.DisableMFA(username, form.Code)
+	if errors.Is(err, services.ErrMFANotEnabled) {
+		ctx.AbortWithError(409, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMFACode) {
+		if h.loginLimiter != nil {
+			h.loginLimiter.Fail(key)
+		}
+		ctx.AbortWithError(403, err)
+		return
+	}
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{})
+}
+
+// tokens of the User-Agent header naming the browsers and the operating systems, the first match wins
+// Edge and Opera also send Chrome and Safari, iOS also sends Mac OS X and Android also sends Linux
+var (
+	userAgentBrowsers = [][2]string{{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}}
+	userAgentSystems  = [][2]string{{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"}}
+)
+
+// longest device name stored with a session, in bytes
+const maxDeviceName = 255
+
+// returns a readable device name of a User-Agent header such as "Firefox on Linux"
+// agents of no known browser or system, e.g. command line clients, are named by the header itself
+func deviceName(userAgent string) string {
+	var names []string
+	for _, known := range [][][2]string{userAgentBrowsers, userAgentSystems} {
+		for _, token := range known {
+			if strings.Contains(userAgent, token[0]) {
+				names = append(names, token[1])
+				break
+			}
+		}
+	}
+	if len(names) > 0 {
+		return strings.Join(names, " on ")
+	}
+	if len(userAgent) > maxDeviceName {
+		// a rune cut in half is dropped
+		return strings.ToValidUTF8(userAgent[:maxDeviceName], "")
+	}
+	return userAgent
+}
+
+// returns the access token of the Authorization header or else of the session cookie, empty without one
+func accessToken(ctx *gin.Context) string {
+	if headerParts := strings.Split(ctx.GetHeader("Authorization"), " "); len(headerParts) == 2 && headerParts[0] == "Bearer" {
+		return headerParts[1]
+	}
+	access, _ := ctx.Cookie(sessionCookie)
+	return access
+}
+
+// csrf method for issuing a double-submit csrf token
+// the token is returned both as a cookie and in the body, clients send it back in the X-CSRF-Token header
+func (h *Handler) csrf(ctx *gin.Context) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(csrfCookie, token, int((time.Hour * 24).Seconds()), "/", "", true, false)
+	ctx.JSON(200, gin.H{
+		"csrfToken": token,
+	})
 }
@@ -1,10 +1,17 @@
 package handler
 
 import (
+	"context"
+	"database/sql"
+	"expvar"
 	"io"
 	"os"
+	"slices"
+	"sync/atomic"
+	"time"
 
-	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
 	"github.com/I1Asyl/berliner_backend/pkg/services"
 
 	"github.com/gin-contrib/cors"
@@ -14,42 +21,127 @@ import (
 // Handler struct which contains all services that are needed for the application
 type Handler struct {
 	services *services.Services
+	// connection pool shared with the repository, pinged by the health check
+	db *sql.DB
+	// settings of the http server
+	server config.Server
+	// page sizes used when a request sets no limit
+	pagination config.Pagination
+	// origins allowed by CORS, replaced when the config is reloaded
+	origins *atomic.Pointer[[]string]
+	// limit of failed logins, logins are not limited when nil
+	loginLimiter *LoginLimiter
+	// limit of requests to the authentication routes, requests are not limited when nil
+	requestLimiter RequestLimiter
+	// verifies the id tokens of Google, signing in with Google is off when nil
+	googleVerifier auth.TokenVerifier
+	// where the router logs besides stdout, gin.log in the working directory when nil
+	logWriter io.Writer
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithLoginLimiter limits the failed logins with the limiter
+func WithLoginLimiter(limiter *LoginLimiter) Option {
+	return func(h *Handler) {
+		h.loginLimiter = limiter
+	}
+}
+
+// WithRequestLimiter limits the requests to the authentication routes with the limiter
+func WithRequestLimiter(limiter RequestLimiter) Option {
+	return func(h *Handler) {
+		h.requestLimiter = limiter
+	}
+}
+
+// WithLogWriter logs the requests to the writer instead of gin.log
+func WithLogWriter(w io.Writer) Option {
+	return func(h *Handler) {
+		h.logWriter = w
+	}
+}
+
+// WithGoogleVerifier lets users sign in with the Google id tokens the verifier accepts
+func WithGoogleVerifier(verifier auth.TokenVerifier) Option {
+	return func(h *Handler) {
+		h.googleVerifier = verifier
+	}
 }
 
 // NewHandler creates new Handler instance
-func NewHandler(services *services.Services) *Handler {
-	return &Handler{services: services}
+func NewHandler(services *services.Services, db *sql.DB, server config.Server, pagination config.Pagination, opts ...Option) *Handler {
+	h := &Handler{services: services, db: db, server: server, pagination: pagination, origins: &atomic.Pointer[[]string]{}}
+	h.origins.Store(&server.AllowedOrigins)
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
-// main page handler for user
-func mainPage(ctx *gin.Context) {
-	res, _ := ctx.Get("user")
-	user := res.(models.User)
+// ApplyConfig applies the reloaded CORS origins, login limits and request limits
+func (h *Handler) ApplyConfig(cfg config.Config) {
+	h.origins.Store(&cfg.Server.AllowedOrigins)
+	if h.loginLimiter != nil {
+		h.loginLimiter.ApplyConfig(cfg)
+	}
+	if reloadable, ok := h.requestLimiter.(interface{ ApplyConfig(config.Config) }); ok {
+		reloadable.ApplyConfig(cfg)
+	}
+}
+
+// main page handler for user, the names are not in the token so the user is looked up
+func (h *Handler) mainPage(ctx *gin.Context) {
+	user, err := h.services.Api.GetUserByUsername(ctx.GetString("username"))
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
 
 	ctx.JSON(200, gin.H{
-		"username":  user.Username,
-		"firstName": user.FirstName,
-		"lastName":  user.LastName,
-		"id":        user.Id,
+		"username":   user.Username,
+		"firstName":  user.FirstName,
+		"lastName":   user.LastName,
+		"id":         user.Id,
+		"isVerified": user.IsVerified,
 	})
 
 }
 
+// how long the health check waits for the database
+const healthTimeout = 2 * time.Second
+
+// health check for load balancers, responds 503 when the database can not be reached
+func (h *Handler) health(ctx *gin.Context) {
+	if h.db == nil {
+		ctx.JSON(503, gin.H{"status": "unavailable"})
+		return
+	}
+	pingCtx, cancel := context.WithTimeout(ctx.Request.Context(), healthTimeout)
+	defer cancel()
+	if err := h.db.PingContext(pingCtx); err != nil {
+		ctx.Error(err)
+		ctx.JSON(503, gin.H{"status": "unavailable"})
+		return
+	}
+	ctx.JSON(200, gin.H{"status": "ok"})
+}
+
 // COR settings for router
-func corSettings(router *gin.Engine) {
+func corSettings(router *gin.Engine, allowedOrigins func() []string) {
 	config := cors.DefaultConfig()
 	config.AllowOriginFunc = func(origin string) bool {
-		// TODO: add domain
-		return origin == "http://localhost:5173"
+		return slices.Contains(allowedOrigins(), origin)
 	}
 	// possible methods
-	config.AllowMethods = []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
 
 	// make possible to share credientials
 	config.AllowCredentials = true
 
 	// allowed headers
-	config.AllowHeaders = []string{"Origin", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Authorization", "Content-Type", csrfHeader}
 
 	router.Use(cors.New(config))
 }
@@ -58,42 +150,83 @@ func corSettings(router *gin.Engine) {
 func (h *Handler) InitRouter() *gin.Engine {
 	// setting up logger
 	//***
-	f, _ := os.Create("gin.log")
-	gin.DefaultWriter = io.MultiWriter(f, os.Stdout)
+	if h.logWriter == nil {
+		if f, err := os.Create("gin.log"); err == nil {
+			h.logWriter = f
+		} else {
+			h.logWriter = io.Discard
+		}
+	}
+	gin.DefaultWriter = io.MultiWriter(h.logWriter, os.Stdout)
 	//***
 
 	// creating a new router Engine
 	router := gin.New()
 
 	// setting up COR settings
-	corSettings(router)
+	corSettings(router, func() []string {
+		return *h.origins.Load()
+	})
 
 	// setting up middlewares
 	router.Use(h.Logger())
 	router.Use(gin.Recovery())
+	router.Use(h.NoSniff())
+	router.Use(h.RequireJSON())
+
+	router.GET("/health", h.health)
+	router.GET("/channels/public", h.getPublicChannels)
 
 	// setting up authorization routes
 	auth := router.Group("")
 	{
+		auth.Use(h.RateLimit())
 		auth.POST("/signup", h.signUp)
 		auth.POST("/login", h.login)
+
+		auth.POST("/auth/login", h.login)
+		auth.GET("/auth/csrf", h.csrf)
+		auth.POST("/auth/password-check", h.passwordCheck)
+		auth.POST("/auth/refresh", h.refresh)
+		auth.POST("/auth/2fa/verify", h.verifyMFA)
+		auth.POST("/auth/oauth/google", h.googleSignIn)
+		auth.POST("/auth/logout", h.logout)
+		auth.POST("/auth/forgot", h.forgotPassword)
+		auth.POST("/auth/reset", h.resetPassword)
+		auth.GET("/auth/verify", h.verifyEmail)
 	}
 
 	// setting up private routes
 	private := router.Group("")
 	{
 		private.Use(h.AuthMiddleware())
-		private.GET("", mainPage)
+		private.GET("", h.mainPage)
 
 		private.GET("/channels", h.getChannels)
 		private.POST("/channels", h.createChannel)
 		private.PATCH("/channels", h.updateChannel)
 		private.DELETE("/channels", h.deleteChannel)
+		private.PATCH("/channels/:id/active", h.setChannelActive)
+		private.PATCH("/channels/:id/accepting-members", h.setAcceptingMembers)
+		private.POST("/channels/:id/join-requests", h.requestToJoin)
+		private.DELETE("/channels/:id/join-requests/me", h.cancelJoinRequest)
+		private.POST("/channels/:id/join-requests/:userId/reject", h.rejectJoinRequest)
+		private.GET("/channels/:id/join-requests/count", h.getPendingRequestCount)
+		private.GET("/channels/:id/leader", h.getChannelLeader)
+		private.GET("/channels/:id/relationship", h.getChannelRelationship)
+		private.GET("/channels/:id/post-frequency", h.getChannelPostFrequency)
 
 		// post
 		private.POST("/post", h.createPost)
+		private.POST("/posts", h.createPost)
 		private.GET("/post", h.getPosts)
 		private.DELETE("/post", h.deletePost)
+		private.DELETE("/posts", h.deletePosts)
+		private.GET("/posts/search", h.searchPosts)
+		private.GET("/posts/by-tags", h.getPostsByHashtags)
+		private.GET("/feed", h.getFeed)
+		private.GET("/feed/channels", h.getChannelFeed)
+		private.GET("/feed/people", h.getPeopleFeed)
 
 		private.GET("/myPost", h.getMyChannelPosts)
 
@@ -103,7 +236,51 @@ func (h *Handler) InitRouter() *gin.Engine {
 		private.GET("/newPost", h.getNewPosts)
 
 		private.GET("/following", h.getFollowing)
+		private.GET("/followers", h.getFollowers)
+
+		private.GET("/users/search", h.searchUsers)
+		private.GET("/users/:id", h.getUser)
+		private.GET("/users/:id/top-hashtags", h.getUserTopHashtags)
+		private.GET("/users/me/follower-growth", h.getFollowerGrowth)
+		private.POST("/users/me/following/cleanup", h.cleanupFollowing)
+		private.GET("/users/me/posts/export", h.exportUserPosts)
+		private.GET("/users/me/channel-suggestions", h.getChannelSuggestions)
+		private.GET("/users/me/roles", h.getMyChannelRoles)
+		private.GET("/users/me/requests-inbox", h.getRequestsInbox)
+		private.POST("/users/me/verification", h.resendVerification)
+		private.PUT("/users/me/password", h.changePassword)
+		private.PATCH("/users/me", h.updateUser)
+		private.DELETE("/users/me", h.deleteUser)
+		private.GET("/users/me/sessions", h.getSessions)
+		private.DELETE("/users/me/sessions", h.revokeOtherSessions)
+		private.DELETE("/users/me/sessions/:id", h.revokeSession)
+		private.POST("/users/me/2fa/enable", h.enableMFA)
+		private.POST("/users/me/2fa/confirm", h.confirmMFA)
+		private.POST("/users/me/2fa/disable", h.disableMFA)
+		private.POST("/users/:id/mute", h.muteUser)
+		private.DELETE("/users/:id/mute", h.unmuteUser)
+
+	}
 
+	// setting up admin routes
+	admin := router.Group("/admin")
+	{
+		admin.Use(h.AuthMiddleware())
+		admin.Use(h.RequireAdmin())
+		admin.GET("/stats", h.getInstanceStats)
+		admin.POST("/users", h.createUser)
+		admin.GET("/channels/inactive", h.getInactiveChannels)
+		admin.GET("/vars", gin.WrapH(expvar.Handler()))
+		admin.GET("/api-keys", h.getAPIKeys)
+		admin.POST("/api-keys", h.createAPIKey)
+		admin.DELETE("/api-keys/:id", h.revokeAPIKey)
+	}
+
+	// setting up routes of services authenticated by an api key
+	service := router.Group("/service")
+	{
+		service.GET("/stats", h.RequireAPIKey(services.ScopeStatsRead), h.getInstanceStats)
+		service.GET("/channels/inactive", h.RequireAPIKey(services.ScopeChannelsRead), h.getInactiveChannels)
 	}
 
 	return router
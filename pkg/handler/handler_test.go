@@ -0,0 +1,1135 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
+	"github.com/gin-gonic/gin"
+)
+
+// stub authorization service which accepts a token with the user id and an older one without it
+type stubAuthorization struct {
+	services.Authorization
+}
+
+func (s stubAuthorization) ParseToken(token string) (services.TokenClaims, error) {
+	switch token {
+	case "valid":
+		return services.TokenClaims{UserId: 1, Username: "asyl"}, nil
+	case "legacy":
+		return services.TokenClaims{Username: "asyl"}, nil
+	case "expired":
+		return services.TokenClaims{}, auth.ErrTokenExpired
+	case "tampered":
+		return services.TokenClaims{}, fmt.Errorf("%w: %w", auth.ErrInvalidToken, auth.ErrTokenInvalidSignature)
+	case "garbage":
+		return services.TokenClaims{}, fmt.Errorf("%w: %w", auth.ErrInvalidToken, auth.ErrTokenMalformed)
+	case "revoked":
+		return services.TokenClaims{}, services.ErrTokenRevoked
+	}
+	return services.TokenClaims{}, errors.New("invalid token")
+}
+
+// stub api service which knows a single user
+type stubApi struct {
+	services.Api
+}
+
+func (s stubApi) GetUserByUsername(username string) (models.User, error) {
+	return models.User{Id: 1, Username: username, FirstName: "Asyl"}, nil
+}
+
+// returns a router with the auth middleware in front of a single POST route
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: stubAuthorization{}, Api: stubApi{}}, nil, config.Server{}, config.Pagination{})
+	router := gin.New()
+	router.Use(h.AuthMiddleware())
+	router.POST("/channels", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{})
+	})
+	return router
+}
+
+func TestAuthMiddlewareCSRF(t *testing.T) {
+	testTable := []struct {
+		name     string
+		header   string
+		cookies  []*http.Cookie
+		csrf     string
+		expected int
+	}{
+		{
+			name:     "bearer token without csrf",
+			header:   "Bearer valid",
+			expected: 200,
+		},
+		{
+			name:     "cookie without csrf header",
+			cookies:  []*http.Cookie{{Name: sessionCookie, Value: "valid"}, {Name: csrfCookie, Value: "abc"}},
+			expected: 403,
+		},
+		{
+			name:     "cookie with wrong csrf header",
+			cookies:  []*http.Cookie{{Name: sessionCookie, Value: "valid"}, {Name: csrfCookie, Value: "abc"}},
+			csrf:     "abd",
+			expected: 403,
+		},
+		{
+			name:     "cookie with csrf header",
+			cookies:  []*http.Cookie{{Name: sessionCookie, Value: "valid"}, {Name: csrfCookie, Value: "abc"}},
+			csrf:     "abc",
+			expected: 200,
+		},
+		{
+			name:     "no credentials",
+			expected: 401,
+		},
+	}
+	router := newTestRouter()
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/channels", nil)
+			if testCase.header != "" {
+				req.Header.Set("Authorization", testCase.header)
+			}
+			if testCase.csrf != "" {
+				req.Header.Set(csrfHeader, testCase.csrf)
+			}
+			for _, cookie := range testCase.cookies {
+				req.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareErrors(t *testing.T) {
+	testTable := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{
+			name:     "missing",
+			expected: `{"error":"token_missing"}`,
+		},
+		{
+			name:     "not a bearer token",
+			header:   "Basic valid",
+			expected: `{"error":"token_malformed"}`,
+		},
+		{
+			name:     "malformed",
+			header:   "Bearer garbage",
+			expected: `{"error":"token_malformed"}`,
+		},
+		{
+			name:     "expired",
+			header:   "Bearer expired",
+			expected: `{"error":"token_expired"}`,
+		},
+		{
+			name:     "bad signature",
+			header:   "Bearer tampered",
+			expected: `{"error":"token_invalid_signature"}`,
+		},
+		{
+			name:     "revoked",
+			header:   "Bearer revoked",
+			expected: `{"error":"token_revoked"}`,
+		},
+		{
+			name:     "unknown",
+			header:   "Bearer unknown",
+			expected: `{"error":"token_invalid"}`,
+		},
+	}
+	router := newTestRouter()
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/channels", nil)
+			if testCase.header != "" {
+				req.Header.Set("Authorization", testCase.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != 401 || w.Body.String() != testCase.expected {
+				t.Errorf("Expected 401 %v, got %v %v", testCase.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareUser(t *testing.T) {
+	testTable := []struct {
+		name     string
+		token    string
+		expected models.User
+	}{
+		{
+			name:     "token with user id",
+			token:    "valid",
+			expected: models.User{Id: 1, Username: "asyl"},
+		},
+		{
+			name:     "token without user id is looked up",
+			token:    "legacy",
+			expected: models.User{Id: 1, Username: "asyl", FirstName: "Asyl"},
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: stubAuthorization{}, Api: stubApi{}}, nil, config.Server{}, config.Pagination{})
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var user any
+			var userId int
+			var username string
+			router := gin.New()
+			router.Use(h.AuthMiddleware())
+			router.GET("/", func(ctx *gin.Context) {
+				user, _ = ctx.Get("user")
+				userId = ctx.GetInt("userId")
+				username = ctx.GetString("username")
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+testCase.token)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			if user != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, user)
+			}
+			if userId != testCase.expected.Id || username != testCase.expected.Username {
+				t.Errorf("Expected %v and %v, got %v and %v", testCase.expected.Id, testCase.expected.Username, userId, username)
+			}
+		})
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	testTable := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		expected    int
+	}{
+		{
+			name:        "json",
+			method:      "POST",
+			contentType: "application/json",
+			body:        "{}",
+			expected:    200,
+		},
+		{
+			name:        "json with charset",
+			method:      "POST",
+			contentType: "application/json; charset=utf-8",
+			body:        "{}",
+			expected:    200,
+		},
+		{
+			name:        "wrong content type",
+			method:      "POST",
+			contentType: "text/plain",
+			body:        "{}",
+			expected:    415,
+		},
+		{
+			name:     "missing content type with body",
+			method:   "POST",
+			body:     "{}",
+			expected: 415,
+		},
+		{
+			name:        "multipart to a json route",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=xyz",
+			body:        "--xyz--",
+			expected:    415,
+		},
+		{
+			name:        "multipart to an upload route",
+			method:      "POST",
+			path:        "/upload",
+			contentType: "multipart/form-data; boundary=xyz",
+			body:        "--xyz--",
+			expected:    200,
+		},
+		{
+			name:        "text to an upload route",
+			method:      "POST",
+			path:        "/upload",
+			contentType: "text/plain",
+			body:        "{}",
+			expected:    415,
+		},
+		{
+			name:     "get without body",
+			method:   "GET",
+			expected: 200,
+		},
+		{
+			name:     "delete without body",
+			method:   "DELETE",
+			expected: 200,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{}, nil, config.Server{}, config.Pagination{})
+	router := gin.New()
+	router.Use(h.NoSniff())
+	router.Use(h.RequireJSON("/upload"))
+	router.Any("/", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{})
+	})
+	router.POST("/upload", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{})
+	})
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			path := testCase.path
+			if path == "" {
+				path = "/"
+			}
+			req := httptest.NewRequest(testCase.method, path, strings.NewReader(testCase.body))
+			if testCase.contentType != "" {
+				req.Header.Set("Content-Type", testCase.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+			if w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+				t.Errorf("Expected json response, got %v", w.Header().Get("Content-Type"))
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("Expected nosniff header, got %v", w.Header().Get("X-Content-Type-Options"))
+			}
+		})
+	}
+}
+
+// fake database connector which counts connections
+type fakeConnector struct {
+	connects atomic.Int64
+	err      error
+}
+
+func (c *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.connects.Add(1)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return fakeConn{}, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+// fake database connection which supports nothing but being opened
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestHealth(t *testing.T) {
+	testTable := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{
+			name:     "database reachable",
+			expected: 200,
+		},
+		{
+			name:     "database unreachable",
+			err:      errors.New("connection refused"),
+			expected: 503,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			connector := &fakeConnector{err: testCase.err}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			h := NewHandler(&services.Services{}, db, config.Server{}, config.Pagination{})
+			router := gin.New()
+			router.GET("/health", h.health)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+			if w.Code != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+			if connector.connects.Load() == 0 {
+				t.Errorf("Expected the health check to use the given connection pool")
+			}
+		})
+	}
+}
+
+// stub authorization service which exchanges a single refresh token
+type refreshAuthorization struct {
+	services.Authorization
+}
+
+func (s refreshAuthorization) RefreshTokens(refreshToken string) (services.TokenPair, error) {
+	switch refreshToken {
+	case "valid":
+		return services.TokenPair{AccessToken: "access", RefreshToken: "rotated"}, nil
+	case "revoked":
+		return services.TokenPair{}, services.ErrRefreshTokenRevoked
+	case "expired":
+		return services.TokenPair{}, services.ErrRefreshTokenExpired
+	}
+	return services.TokenPair{}, services.ErrInvalidRefreshToken
+}
+
+func TestRefresh(t *testing.T) {
+	testTable := []struct {
+		name     string
+		body     string
+		cookie   string
+		expected int
+	}{
+		{
+			name:     "body",
+			body:     `{"refreshToken": "valid"}`,
+			expected: 200,
+		},
+		{
+			name:     "cookie",
+			cookie:   "valid",
+			expected: 200,
+		},
+		{
+			name:     "revoked",
+			body:     `{"refreshToken": "revoked"}`,
+			expected: 401,
+		},
+		{
+			name:     "expired",
+			cookie:   "expired",
+			expected: 401,
+		},
+		{
+			name:     "missing",
+			expected: 401,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: refreshAuthorization{}, Clock: services.RealClock{}}, nil, config.Server{}, config.Pagination{})
+	router := gin.New()
+	router.POST("/auth/refresh", h.refresh)
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(testCase.body))
+			req.Header.Set("Content-Type", "application/json")
+			if testCase.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: refreshCookie, Value: testCase.cookie})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+			if w.Code == 200 && !strings.Contains(w.Body.String(), `"refreshToken":"rotated"`) {
+				t.Errorf("Expected the rotated refresh token, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+// stub authorization service which accepts any token until it is revoked
+type revokingAuthorization struct {
+	services.Authorization
+	revoked map[string]bool
+}
+
+func (s revokingAuthorization) ParseToken(token string) (services.TokenClaims, error) {
+	if s.revoked[token] {
+		return services.TokenClaims{}, services.ErrTokenRevoked
+	}
+	return services.TokenClaims{UserId: 1, Username: "asyl"}, nil
+}
+
+func (s revokingAuthorization) RevokeToken(token string) error {
+	s.revoked[token] = true
+	return nil
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: revokingAuthorization{revoked: map[string]bool{}}}, nil, config.Server{}, config.Pagination{})
+	router := gin.New()
+	router.POST("/auth/logout", h.logout)
+	router.GET("/channels", h.AuthMiddleware(), func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{})
+	})
+
+	testTable := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		cookie   string
+		expected int
+	}{
+		{
+			name:     "before logout",
+			method:   "GET",
+			path:     "/channels",
+			token:    "stolen",
+			expected: 200,
+		},
+		{
+			name:     "logout",
+			method:   "POST",
+			path:     "/auth/logout",
+			token:    "stolen",
+			expected: 200,
+		},
+		{
+			name:     "after logout",
+			method:   "GET",
+			path:     "/channels",
+			token:    "stolen",
+			expected: 401,
+		},
+		{
+			name:     "another token",
+			method:   "GET",
+			path:     "/channels",
+			token:    "other",
+			expected: 200,
+		},
+		{
+			name:     "logout with session cookie",
+			method:   "POST",
+			path:     "/auth/logout",
+			cookie:   "session-token",
+			expected: 200,
+		},
+		{
+			name:     "session cookie after logout",
+			method:   "GET",
+			path:     "/channels",
+			cookie:   "session-token",
+			expected: 401,
+		},
+	}
+	// the steps depend on each other, so they run in order
+	for _, testCase := range testTable {
+		req := httptest.NewRequest(testCase.method, testCase.path, nil)
+		if testCase.token != "" {
+			req.Header.Set("Authorization", "Bearer "+testCase.token)
+		}
+		if testCase.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: testCase.cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, w.Code)
+		}
+	}
+}
+
+// stub api service which records the limit each paginated endpoint is called with
+type pagedApi struct {
+	services.Api
+	limits map[string]int
+}
+
+func (s pagedApi) GetChannelFeed(user models.User, limit, offset int) ([]struct {
+	models.Channel
+	models.ChannelPost
+}, error) {
+	s.limits["/feed/channels"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) GetPeopleFeed(user models.User, limit, offset int) ([]struct {
+	models.User
+	models.UserPost
+}, error) {
+	s.limits["/feed/people"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) GetAllPosts(user models.User, limit, offset int) ([]models.Post, error) {
+	s.limits["/feed"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) SearchPosts(viewer models.User, query string, limit, offset int) ([]models.Post, error) {
+	s.limits["/posts/search"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) SuggestChannels(userId int, limit int) ([]models.Channel, error) {
+	s.limits["/users/me/channel-suggestions"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) GetUserTopHashtags(userId int, limit int) ([]models.TagCount, error) {
+	s.limits["/users/1/top-hashtags"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) GetInactiveChannels(inactiveFor time.Duration, limit int) ([]models.Channel, error) {
+	s.limits["/admin/channels/inactive"] = limit
+	return nil, nil
+}
+
+func (s pagedApi) GetAllPublicChannels(limit, offset int) ([]models.Channel, error) {
+	s.limits["/channels/public"] = limit
+	return nil, nil
+}
+
+func TestPaginationDefaults(t *testing.T) {
+	pagination := config.Pagination{Defaults: config.PageDefaults{Feed: 20, Search: 10, Suggestions: 5, Hashtags: 15, InactiveChannels: 50, Channels: 200}}
+	testTable := []struct {
+		name     string
+		path     string
+		expected int
+	}{
+		{
+			name:     "feed",
+			path:     "/feed",
+			expected: 20,
+		},
+		{
+			name:     "channel feed",
+			path:     "/feed/channels",
+			expected: 20,
+		},
+		{
+			name:     "people feed",
+			path:     "/feed/people",
+			expected: 20,
+		},
+		{
+			name:     "search",
+			path:     "/posts/search",
+			expected: 10,
+		},
+		{
+			name:     "suggestions",
+			path:     "/users/me/channel-suggestions",
+			expected: 5,
+		},
+		{
+			name:     "hashtags",
+			path:     "/users/1/top-hashtags",
+			expected: 15,
+		},
+		{
+			name:     "inactive channels",
+			path:     "/admin/channels/inactive",
+			expected: 50,
+		},
+		{
+			name:     "public channels",
+			path:     "/channels/public",
+			expected: 200,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	api := pagedApi{limits: map[string]int{}}
+	h := NewHandler(&services.Services{Api: api}, nil, config.Server{}, pagination)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Set("user", models.User{Id: 1, Username: "asyl"})
+	})
+	router.GET("/feed", h.getFeed)
+	router.GET("/feed/channels", h.getChannelFeed)
+	router.GET("/feed/people", h.getPeopleFeed)
+	router.GET("/posts/search", h.searchPosts)
+	router.GET("/users/me/channel-suggestions", h.getChannelSuggestions)
+	router.GET("/users/:id/top-hashtags", h.getUserTopHashtags)
+	router.GET("/admin/channels/inactive", h.getInactiveChannels)
+	router.GET("/channels/public", h.getPublicChannels)
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", testCase.path, nil))
+			if w.Code != 200 {
+				t.Fatalf("Expected 200, got %v", w.Code)
+			}
+			if limit := api.limits[testCase.path]; limit != testCase.expected {
+				t.Errorf("Expected default limit %v, got %v", testCase.expected, limit)
+			}
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", testCase.path+"?limit=3", nil))
+			if limit := api.limits[testCase.path]; limit != 3 {
+				t.Errorf("Expected the given limit 3, got %v", limit)
+			}
+		})
+	}
+}
+
+// stub authorization service which accepts every username with the password "right"
+type loginAuthorization struct {
+	services.Authorization
+}
+
+func (s loginAuthorization) CheckUserAndPassword(userForm models.AuthorizationForm) (bool, bool, error) {
+	if userForm.Username == "googler" {
+		return false, false, &services.ProviderAccountError{Provider: services.GoogleProvider}
+	}
+	if userForm.Password != "right" {
+		return false, false, errors.New("wrong password")
+	}
+	return true, true, nil
+}
+
+func (s loginAuthorization) GenerateTokenPair(user models.AuthorizationForm, device string) (services.TokenPair, error) {
+	return services.TokenPair{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+// the user "secured" has two-factor authentication, its login waits for a code
+func (s loginAuthorization) StartMFAChallenge(user models.AuthorizationForm, device string) (string, error) {
+	if user.Username == "secured" {
+		return "mfa-token", nil
+	}
+	return "", nil
+}
+
+// completes the login of "mfa-token" with the code 123456
+func (s loginAuthorization) VerifyMFA(token, code string) (services.TokenPair, error) {
+	if token != "mfa-token" {
+		return services.TokenPair{}, services.ErrInvalidMFAToken
+	}
+	if code != "123456" {
+		return services.TokenPair{}, services.ErrInvalidMFACode
+	}
+	return services.TokenPair{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+// signs in every identity but the one of new@mail.com, as if signups were disabled,
+// and the one of squatted@mail.com, as if its account had an unverified email
+func (s loginAuthorization) SignInWithProvider(provider string, identity auth.Identity, device string) (services.TokenPair, error) {
+	if identity.Email == "new@mail.com" {
+		return services.TokenPair{}, services.ErrSignupsDisabled
+	}
+	if identity.Email == "squatted@mail.com" {
+		return services.TokenPair{}, services.ErrUnverifiedAccount
+	}
+	return services.TokenPair{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+// stub id token verifier returning the identity or the error of each token
+type stubVerifier map[string]error
+
+func (s stubVerifier) Verify(ctx context.Context, token string) (auth.Identity, error) {
+	err, ok := s[token]
+	if !ok {
+		return auth.Identity{}, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	return auth.Identity{Subject: token, Email: token + "@mail.com"}, nil
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	clock := services.NewFakeClock(time.Date(2030, time.January, 10, 12, 0, 0, 0, time.UTC))
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(), config.RateLimit{MaxAttempts: 3, Window: 15 * time.Minute}, clock)
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: loginAuthorization{}, Clock: clock}, nil, config.Server{}, config.Pagination{}, WithLoginLimiter(limiter))
+	router := gin.New()
+	router.POST("/auth/login", h.login)
+
+	// the steps run in order, each one advancing the clock first
+	testTable := []struct {
+		name       string
+		advance    time.Duration
+		username   string
+		password   string
+		ip         string
+		expected   int
+		retryAfter string
+	}{
+		{name: "first failure", username: "asyl", password: "wrong", ip: "10.0.0.1", expected: 401},
+		{name: "second failure", advance: time.Minute, username: "asyl", password: "wrong", ip: "10.0.0.1", expected: 401},
+		{name: "third failure", advance: time.Minute, username: "asyl", password: "wrong", ip: "10.0.0.1", expected: 401},
+		{name: "limited", username: "asyl", password: "right", ip: "10.0.0.1", expected: 429, retryAfter: "780"},
+		{name: "other username from the same ip", username: "other", password: "right", ip: "10.0.0.1", expected: 429, retryAfter: "780"},
+		{name: "same username from another ip", username: "asyl", password: "right", ip: "10.0.0.2", expected: 429, retryAfter: "780"},
+		{name: "first failure left the window", advance: 13 * time.Minute, username: "asyl", password: "right", ip: "10.0.0.2", expected: 200},
+		{name: "username reset by the login", username: "asyl", password: "wrong", ip: "10.0.0.2", expected: 401},
+		{name: "username not limited after the reset", username: "asyl", password: "right", ip: "10.0.0.2", expected: 200},
+	}
+	for _, testCase := range testTable {
+		clock.Advance(testCase.advance)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "`+testCase.username+`", "password": "`+testCase.password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = testCase.ip + ":1234"
+		router.ServeHTTP(w, req)
+		if w.Code != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, w.Code)
+		}
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != testCase.retryAfter {
+			t.Errorf("%s: expected Retry-After %q, got %q", testCase.name, testCase.retryAfter, retryAfter)
+		}
+	}
+}
+
+func TestRequestRateLimit(t *testing.T) {
+	clock := services.NewFakeClock(time.Date(2030, time.January, 10, 12, 0, 0, 0, time.UTC))
+	limiter := NewMemoryRequestLimiter(config.RequestLimit{PerMinute: 5}, clock)
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: loginAuthorization{}, Clock: clock}, nil, config.Server{}, config.Pagination{}, WithRequestLimiter(limiter))
+	router := gin.New()
+	auth := router.Group("")
+	auth.Use(h.RateLimit())
+	auth.POST("/auth/login", h.login)
+
+	// the steps run in order, each one advancing the clock first
+	testTable := []struct {
+		name       string
+		advance    time.Duration
+		requests   int
+		ip         string
+		perMinute  int
+		expected   int
+		retryAfter string
+	}{
+		{name: "burst", requests: 5, ip: "10.0.0.1", perMinute: 5, expected: 200},
+		{name: "limited at the threshold", requests: 1, ip: "10.0.0.1", perMinute: 5, expected: 429, retryAfter: "12"},
+		{name: "still limited", advance: 6 * time.Second, requests: 1, ip: "10.0.0.1", perMinute: 5, expected: 429, retryAfter: "6"},
+		{name: "another ip", requests: 5, ip: "10.0.0.2", perMinute: 5, expected: 200},
+		{name: "one token refilled", advance: 6 * time.Second, requests: 1, ip: "10.0.0.1", perMinute: 5, expected: 200},
+		{name: "limited again", requests: 1, ip: "10.0.0.1", perMinute: 5, expected: 429, retryAfter: "12"},
+		{name: "bucket refilled", advance: time.Minute, requests: 5, ip: "10.0.0.1", perMinute: 5, expected: 200},
+		{name: "turned off by a reload", requests: 10, ip: "10.0.0.1", perMinute: 0, expected: 200},
+	}
+	for _, testCase := range testTable {
+		clock.Advance(testCase.advance)
+		h.ApplyConfig(config.Config{Auth: config.Auth{RequestLimit: config.RequestLimit{PerMinute: testCase.perMinute}}})
+		for i := 0; i < testCase.requests; i++ {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "asyl", "password": "right"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = testCase.ip + ":1234"
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Errorf("%s: request %d expected %v, got %v", testCase.name, i+1, testCase.expected, w.Code)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != testCase.retryAfter {
+				t.Errorf("%s: request %d expected Retry-After %q, got %q", testCase.name, i+1, testCase.retryAfter, retryAfter)
+			}
+		}
+	}
+}
+
+// stub authorization service knowing the api key "brl_stats" of the cron service
+type apiKeyAuthorization struct {
+	services.Authorization
+}
+
+func (s apiKeyAuthorization) AuthenticateAPIKey(key string) (models.APIKey, error) {
+	switch key {
+	case "brl_stats":
+		return models.APIKey{Id: 1, Name: "cron", Scopes: []string{services.ScopeStatsRead}}, nil
+	case "brl_broken":
+		return models.APIKey{}, errors.New("connection refused")
+	}
+	return models.APIKey{}, services.ErrInvalidAPIKey
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	t.Parallel()
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: apiKeyAuthorization{}}, nil, config.Server{}, config.Pagination{})
+	router := gin.New()
+	service := func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{"service": ctx.GetString("service")})
+	}
+	router.GET("/service/stats", h.RequireAPIKey(services.ScopeStatsRead), service)
+	router.GET("/service/channels/inactive", h.RequireAPIKey(services.ScopeChannelsRead), service)
+
+	testTable := []struct {
+		name     string
+		path     string
+		header   string
+		expected int
+		response string
+	}{
+		{name: "key with the scope", path: "/service/stats", header: "ApiKey brl_stats", expected: 200, response: `{"service":"cron"}`},
+		{name: "key without the scope", path: "/service/channels/inactive", header: "ApiKey brl_stats", expected: 403},
+		{name: "no header", path: "/service/stats", expected: 401, response: `{"error":"api_key_missing"}`},
+		{name: "bearer token", path: "/service/stats", header: "Bearer brl_stats", expected: 401, response: `{"error":"api_key_malformed"}`},
+		{name: "unknown or revoked key", path: "/service/stats", header: "ApiKey brl_other", expected: 401, response: `{"error":"api_key_invalid"}`},
+		{name: "repository error", path: "/service/stats", header: "ApiKey brl_broken", expected: 500},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", testCase.path, nil)
+			if testCase.header != "" {
+				req.Header.Set("Authorization", testCase.header)
+			}
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+			if testCase.response != "" && w.Body.String() != testCase.response {
+				t.Errorf("Expected %v, got %v", testCase.response, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInitRouterLogWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	h := NewHandler(&services.Services{}, nil, config.Server{}, config.Pagination{}, WithLogWriter(&logs))
+	router := h.InitRouter()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if !strings.Contains(logs.String(), "/health") {
+		t.Errorf("Expected the request to be logged to the writer, got %q", logs.String())
+	}
+	if _, err := os.Stat("gin.log"); !os.IsNotExist(err) {
+		t.Errorf("Expected no gin.log, got error %v", err)
+	}
+}
+
+func TestRouterRejectsMultipart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{}, nil, config.Server{}, config.Pagination{}, WithLogWriter(&bytes.Buffer{}))
+	router := h.InitRouter()
+	for _, path := range []string{"/login", "/post"} {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", path, strings.NewReader("--xyz--"))
+			req.Header.Set("Content-Type", "multipart/form-data; boundary=xyz")
+			router.ServeHTTP(w, req)
+			if w.Code != 415 {
+				t.Errorf("Expected 415, got %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{}, nil, config.Server{AllowedOrigins: []string{"http://localhost:5173"}}, config.Pagination{}, WithLogWriter(&bytes.Buffer{}))
+	router := h.InitRouter()
+
+	testTable := []struct {
+		name     string
+		method   string
+		origin   string
+		expected int
+	}{
+		{name: "put from an allowed origin", method: "PUT", origin: "http://localhost:5173", expected: 204},
+		{name: "patch from an allowed origin", method: "PATCH", origin: "http://localhost:5173", expected: 204},
+		{name: "put from another origin", method: "PUT", origin: "https://evil.example", expected: 403},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("OPTIONS", "/users/me/password", nil)
+			req.Header.Set("Origin", testCase.origin)
+			req.Header.Set("Access-Control-Request-Method", testCase.method)
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+			if testCase.expected == 204 && !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), testCase.method) {
+				t.Errorf("Expected %s to be allowed, got %q", testCase.method, w.Header().Get("Access-Control-Allow-Methods"))
+			}
+		})
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	t.Parallel()
+	testTable := []struct {
+		name      string
+		userAgent string
+		expected  string
+	}{
+		{
+			name:      "firefox on linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			expected:  "Firefox on Linux",
+		},
+		{
+			name:      "edge is not chrome",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+			expected:  "Edge on Windows",
+		},
+		{
+			name:      "iphone is not macos",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			expected:  "Safari on iOS",
+		},
+		{
+			name:      "android is not linux",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+			expected:  "Chrome on Android",
+		},
+		{
+			name:      "unknown agent",
+			userAgent: "curl/8.5.0",
+			expected:  "curl/8.5.0",
+		},
+		{
+			name:      "empty",
+			userAgent: "",
+			expected:  "",
+		},
+		{
+			name:      "long unknown agent",
+			userAgent: strings.Repeat("a", 254) + "é",
+			expected:  strings.Repeat("a", 254),
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			if got := deviceName(testCase.userAgent); got != testCase.expected {
+				t.Errorf("Expected %q, got %q", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestGoogleSignIn(t *testing.T) {
+	t.Parallel()
+	gin.SetMode(gin.TestMode)
+	verifier := stubVerifier{"asyl": nil, "new": nil, "squatted": nil, "unverified": auth.ErrEmailNotVerified}
+	configured := NewHandler(&services.Services{Authorization: loginAuthorization{}}, nil, config.Server{}, config.Pagination{}, WithGoogleVerifier(verifier))
+	unconfigured := NewHandler(&services.Services{Authorization: loginAuthorization{}}, nil, config.Server{}, config.Pagination{})
+
+	testTable := []struct {
+		name     string
+		handler  *Handler
+		body     string
+		expected int
+	}{
+		{name: "signed in", handler: configured, body: `{"idToken": "asyl"}`, expected: 200},
+		{name: "invalid token", handler: configured, body: `{"idToken": "forged"}`, expected: 401},
+		{name: "unverified email", handler: configured, body: `{"idToken": "unverified"}`, expected: 403},
+		{name: "signups disabled", handler: configured, body: `{"idToken": "new"}`, expected: 403},
+		{name: "account with an unverified email", handler: configured, body: `{"idToken": "squatted"}`, expected: 409},
+		{name: "not json", handler: configured, body: `idToken`, expected: 400},
+		{name: "not configured", handler: unconfigured, body: `{"idToken": "asyl"}`, expected: 404},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/auth/oauth/google", testCase.handler.googleSignIn)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/auth/oauth/google", strings.NewReader(testCase.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			if w.Code != testCase.expected {
+				t.Fatalf("Expected %v, got %v", testCase.expected, w.Code)
+			}
+			if w.Code == 200 && !strings.Contains(w.Body.String(), `"token":"access"`) {
+				t.Errorf("Expected the tokens of a login, got %v", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoginProviderAccount(t *testing.T) {
+	t.Parallel()
+	clock := services.NewFakeClock(time.Date(2030, time.January, 10, 12, 0, 0, 0, time.UTC))
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(), config.RateLimit{MaxAttempts: 1, Window: 15 * time.Minute}, clock)
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: loginAuthorization{}, Clock: clock}, nil, config.Server{}, config.Pagination{}, WithLoginLimiter(limiter))
+	router := gin.New()
+	router.POST("/auth/login", h.login)
+
+	// a password login of an account without a password is not counted as a failed login
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "googler", "password": "right"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		if w.Code != 401 || w.Body.String() != `{"error":"password_not_set","provider":"google"}` {
+			t.Errorf("Expected 401 with password_not_set, got %v %v", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMFALogin(t *testing.T) {
+	clock := services.NewFakeClock(time.Date(2030, time.January, 10, 12, 0, 0, 0, time.UTC))
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(), config.RateLimit{MaxAttempts: 3, Window: 15 * time.Minute}, clock)
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(&services.Services{Authorization: loginAuthorization{}, Clock: clock}, nil, config.Server{}, config.Pagination{}, WithLoginLimiter(limiter))
+	router := gin.New()
+	router.POST("/auth/login", h.login)
+	router.POST("/auth/2fa/verify", h.verifyMFA)
+
+	// the steps run in order
+	testTable := []struct {
+		name     string
+		path     string
+		body     string
+		expected int
+		response string
+	}{
+		{name: "login without 2fa", path: "/auth/login", body: `{"username": "asyl", "password": "right"}`, expected: 200, response: `{"isVerified":true,"refreshToken":"refresh","token":"access"}`},
+		{name: "login with 2fa", path: "/auth/login", body: `{"username": "secured", "password": "right"}`, expected: 200, response: `{"mfaRequired":true,"mfaToken":"mfa-token"}`},
+		{name: "wrong password with 2fa", path: "/auth/login", body: `{"username": "secured", "password": "wrong"}`, expected: 401},
+		{name: "unknown token", path: "/auth/2fa/verify", body: `{"mfaToken": "other", "code": "123456"}`, expected: 401},
+		{name: "wrong code", path: "/auth/2fa/verify", body: `{"mfaToken": "mfa-token", "code": "654321"}`, expected: 401},
+		{name: "right code", path: "/auth/2fa/verify", body: `{"mfaToken": "mfa-token", "code": "123456"}`, expected: 200, response: `{"refreshToken":"refresh","token":"access"}`},
+		{name: "another wrong code", path: "/auth/2fa/verify", body: `{"mfaToken": "mfa-token", "code": "654321"}`, expected: 401},
+		// the wrong password and the wrong codes reached the limit of the ip
+		{name: "limited", path: "/auth/2fa/verify", body: `{"mfaToken": "mfa-token", "code": "123456"}`, expected: 429},
+	}
+	for _, testCase := range testTable {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", testCase.path, strings.NewReader(testCase.body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		if w.Code != testCase.expected {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.expected, w.Code)
+		}
+		if testCase.response != "" && w.Body.String() != testCase.response {
+			t.Errorf("%s: expected %s, got %s", testCase.name, testCase.response, w.Body.String())
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
 	"github.com/gin-gonic/gin"
 )
 
@@ -27,34 +36,192 @@ func (h *Handler) Logger() gin.HandlerFunc {
 	return gin.LoggerWithFormatter(h.logFormatter)
 }
 
+// NoSniff stops browsers from guessing the content type of responses
+func (h *Handler) NoSniff() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("X-Content-Type-Options", "nosniff")
+		ctx.Next()
+	}
+}
+
+// RateLimit rejects the requests of a client ip over the request limit with 429
+// errors of the limiter are logged and the request is allowed
+func (h *Handler) RateLimit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if h.requestLimiter == nil {
+			ctx.Next()
+			return
+		}
+		key := "ip:" + ctx.ClientIP()
+		wait, err := h.requestLimiter.Take(key)
+		if err != nil {
+			slog.Warn("could not take a request", "key", key, "error", err)
+		}
+		if wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			ctx.AbortWithError(429, errors.New("too many requests"))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireJSON rejects requests with a body which is not json with 415, requests without a body are let through
+// uploads are the routes, as registered, which may also send multipart/form-data
+func (h *Handler) RequireJSON(uploads ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.ContentLength == 0 && len(ctx.Request.TransferEncoding) == 0 {
+			ctx.Next()
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		if err == nil && mediaType == "multipart/form-data" && slices.Contains(uploads, ctx.FullPath()) {
+			ctx.Next()
+			return
+		}
+		if err != nil || mediaType != "application/json" {
+			ctx.AbortWithStatusJSON(415, gin.H{"error": "content type must be application/json"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
 // AuthMiddleware is a custom auth middleware
 func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		var token string
 		//recieves an Authorization header from the request
 		header := ctx.GetHeader("Authorization")
-		if header == "" {
-			ctx.AbortWithError(401, errors.New("authorization header is empty"))
-			return
-		}
-		//splits the header into parts
-		headerParts := strings.Split(header, " ")
+		if header != "" {
+			//splits the header into parts
+			headerParts := strings.Split(header, " ")
 
-		//checks if the parts are of the correct type
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			ctx.AbortWithError(401, errors.New("authorization header did not provide a token"))
+			//checks if the parts are of the correct type
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				abortUnauthorized(ctx, "token_malformed", errors.New("authorization header did not provide a token"))
+				return
+			}
+			token = headerParts[1]
+		} else if cookie, err := ctx.Cookie(sessionCookie); err == nil && cookie != "" {
+			//state-changing requests authenticated by cookie need a matching csrf token
+			if !safeMethod(ctx.Request.Method) && !validCSRF(ctx) {
+				ctx.AbortWithError(403, errors.New("csrf token is missing or invalid"))
+				return
+			}
+			token = cookie
+		} else {
+			abortUnauthorized(ctx, "token_missing", errors.New("authorization header is empty"))
 			return
 		}
-		username, err := h.services.ParseToken(headerParts[1])
+		claims, err := h.services.ParseToken(token)
 
 		if err != nil {
-			ctx.AbortWithError(401, err)
+			abortUnauthorized(ctx, tokenErrorCode(err), err)
 			return
 		}
+		user := models.User{Id: claims.UserId, Username: claims.Username}
+		//tokens issued before the user id was added to the claims are looked up, drop it once they have expired
+		if claims.UserId == 0 {
+			user, _ = h.services.Api.GetUserByUsername(claims.Username)
+		}
 		//sets the user in the gin Engine context
-		user, _ := h.services.Api.GetUserByUsername(username)
+		ctx.Set("userId", user.Id)
+		ctx.Set("username", user.Username)
 		ctx.Set("user", user)
+		ctx.Set("sessionId", claims.SessionId)
 
 		ctx.Next()
 
 	}
 }
+
+// RequireAPIKey lets through the services with an api key of the scope, given as "Authorization: ApiKey <key>"
+// it sets the key and the name of the service in the gin context instead of a user
+func (h *Handler) RequireAPIKey(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader("Authorization")
+		if header == "" {
+			abortUnauthorized(ctx, "api_key_missing", errors.New("authorization header is empty"))
+			return
+		}
+		headerParts := strings.Split(header, " ")
+		if len(headerParts) != 2 || headerParts[0] != "ApiKey" {
+			abortUnauthorized(ctx, "api_key_malformed", errors.New("authorization header did not provide an api key"))
+			return
+		}
+		key, err := h.services.AuthenticateAPIKey(headerParts[1])
+		if errors.Is(err, services.ErrInvalidAPIKey) {
+			abortUnauthorized(ctx, "api_key_invalid", err)
+			return
+		}
+		if err != nil {
+			ctx.AbortWithError(500, err)
+			return
+		}
+		if !slices.Contains(key.Scopes, scope) {
+			ctx.AbortWithError(403, fmt.Errorf("api key %q does not have the scope %s", key.Name, scope))
+			return
+		}
+		ctx.Set("apiKey", key)
+		ctx.Set("service", key.Name)
+		ctx.Next()
+	}
+}
+
+// abortUnauthorized responds 401 with the reason code of the error, the error itself is only logged
+func abortUnauthorized(ctx *gin.Context, code string, err error) {
+	ctx.Error(err)
+	ctx.AbortWithStatusJSON(401, gin.H{"error": code})
+}
+
+// tokenErrorCode returns the reason code of a token which was rejected
+// clients refresh their tokens on token_expired and log in again on the others
+func tokenErrorCode(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrTokenExpired):
+		return "token_expired"
+	case errors.Is(err, services.ErrTokenRevoked):
+		return "token_revoked"
+	case errors.Is(err, auth.ErrTokenMalformed):
+		return "token_malformed"
+	case errors.Is(err, auth.ErrTokenInvalidSignature):
+		return "token_invalid_signature"
+	case errors.Is(err, auth.ErrClaimsMismatch):
+		return "token_invalid_claims"
+	}
+	return "token_invalid"
+}
+
+// RequireAdmin only lets platform admins through, it must run after AuthMiddleware
+func (h *Handler) RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		res, _ := ctx.Get("user")
+		user := res.(models.User)
+		admin, err := h.services.IsAdmin(user.Username)
+		if err != nil {
+			ctx.AbortWithError(500, err)
+			return
+		}
+		if !admin {
+			ctx.AbortWithError(403, errors.New("admin role is required"))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// safeMethod reports whether the http method does not change state
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// validCSRF checks that the csrf header matches the csrf cookie
+func validCSRF(ctx *gin.Context) bool {
+	header := ctx.GetHeader(csrfHeader)
+	cookie, err := ctx.Cookie(csrfCookie)
+	if err != nil || header == "" || cookie == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) == 1
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/I1Asyl/berliner_backend/pkg/config"
+	"github.com/I1Asyl/berliner_backend/pkg/services"
+)
+
+// AttemptStore keeps the failed login attempts of usernames and client ips
+// the memory store is used for now, a shared store such as redis can implement it later
+type AttemptStore interface {
+	// records a failed attempt of the key at the given time
+	AddFailure(key string, at time.Time) error
+	// returns the failed attempts of the key after since, oldest first
+	Failures(key string, since time.Time) ([]time.Time, error)
+	// forgets the failed attempts of the key
+	Reset(key string) error
+}
+
+// MemoryAttemptStore is an AttemptStore of a single instance
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+// NewMemoryAttemptStore returns an empty MemoryAttemptStore
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{failures: map[string][]time.Time{}}
+}
+
+func (s *MemoryAttemptStore) AddFailure(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[key] = append(s.failures[key], at)
+	return nil
+}
+
+// the attempts before since are dropped, so keys which stop failing do not pile up
+func (s *MemoryAttemptStore) Failures(key string, since time.Time) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for other, times := range s.failures {
+		if !times[len(times)-1].After(since) {
+			delete(s.failures, other)
+		}
+	}
+	times := s.failures[key]
+	for len(times) > 0 && !times[0].After(since) {
+		times = times[1:]
+	}
+	return append([]time.Time(nil), times...), nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+// LoginLimiter limits the failed logins of each key in a sliding window
+type LoginLimiter struct {
+	store AttemptStore
+	clock services.Clock
+	// thresholds of auth.rate_limit, replaced when the config is reloaded
+	limits *atomic.Pointer[config.RateLimit]
+}
+
+// NewLoginLimiter returns a LoginLimiter keeping the attempts in store
+func NewLoginLimiter(store AttemptStore, limits config.RateLimit, clock services.Clock) *LoginLimiter {
+	l := &LoginLimiter{store: store, clock: clock, limits: &atomic.Pointer[config.RateLimit]{}}
+	l.limits.Store(&limits)
+	return l
+}
+
+// ApplyConfig applies the reloaded thresholds
+func (l *LoginLimiter) ApplyConfig(cfg config.Config) {
+	l.limits.Store(&cfg.Auth.RateLimit)
+}
+
+// returns how long to wait before another attempt, zero when every key is below the limit
+// errors of the store are logged and the login is allowed
+func (l *LoginLimiter) RetryAfter(keys ...string) time.Duration {
+	limits := l.limits.Load()
+	if limits.MaxAttempts == 0 {
+		return 0
+	}
+	now := l.clock.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		failures, err := l.store.Failures(key, now.Add(-limits.Window))
+		if err != nil {
+			slog.Warn("could not read failed logins", "key", key, "error", err)
+			continue
+		}
+		if len(failures) < limits.MaxAttempts {
+			continue
+		}
+		// the oldest attempt which keeps the key at the limit has to leave the window
+		oldest := failures[len(failures)-limits.MaxAttempts]
+		wait = max(wait, oldest.Add(limits.Window).Sub(now))
+	}
+	return wait
+}
+
+// records a failed login of every key
+func (l *LoginLimiter) Fail(keys ...string) {
+	now := l.clock.Now()
+	for _, key := range keys {
+		if err := l.store.AddFailure(key, now); err != nil {
+			slog.Warn("could not record failed login", "key", key, "error", err)
+		}
+	}
+}
+
+// forgets the failed logins of the key after a successful login
+func (l *LoginLimiter) Reset(key string) {
+	if err := l.store.Reset(key); err != nil {
+		slog.Warn("could not reset failed logins", "key", key, "error", err)
+	}
+}
+
+// RequestLimiter limits the requests of each key, such as the client ip
+// the memory limiter is used for now, a shared limiter such as redis can implement it later
+type RequestLimiter interface {
+	// takes a request of the key, returns how long to wait before the next one is allowed when it is over the limit
+	Take(key string) (time.Duration, error)
+}
+
+// how often the memory limiter drops the buckets which are full again
+const bucketSweepInterval = time.Minute
+
+// tokens left in a bucket when it was last taken from
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// MemoryRequestLimiter is a token bucket RequestLimiter of a single instance
+// a bucket holds up to burst tokens and is refilled with per_minute tokens a minute
+type MemoryRequestLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	lastSweep time.Time
+	clock     services.Clock
+	// limits of auth.request_limit, replaced when the config is reloaded
+	limits *atomic.Pointer[config.RequestLimit]
+}
+
+// NewMemoryRequestLimiter returns a MemoryRequestLimiter with the limits
+func NewMemoryRequestLimiter(limits config.RequestLimit, clock services.Clock) *MemoryRequestLimiter {
+	l := &MemoryRequestLimiter{buckets: map[string]bucket{}, lastSweep: clock.Now(), clock: clock, limits: &atomic.Pointer[config.RequestLimit]{}}
+	l.limits.Store(&limits)
+	return l
+}
+
+// ApplyConfig applies the reloaded limits, the tokens left in the buckets are kept
+func (l *MemoryRequestLimiter) ApplyConfig(cfg config.Config) {
+	l.limits.Store(&cfg.Auth.RequestLimit)
+}
+
+func (l *MemoryRequestLimiter) Take(key string) (time.Duration, error) {
+	limits := l.limits.Load()
+	if limits.PerMinute == 0 {
+		return 0, nil
+	}
+	capacity := float64(limits.Burst)
+	if limits.Burst == 0 {
+		capacity = float64(limits.PerMinute)
+	}
+	// tokens refilled per second
+	rate := float64(limits.PerMinute) / time.Minute.Seconds()
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		l.sweep(now, capacity, rate)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = bucket{tokens: capacity, at: now}
+	}
+	b.tokens = min(capacity, b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+	if b.tokens < 1 {
+		l.buckets[key] = b
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	l.buckets[key] = b
+	return 0, nil
+}
+
+// drops the buckets which are full by now, a full bucket is the same as no bucket
+func (l *MemoryRequestLimiter) sweep(now time.Time, capacity, rate float64) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*rate >= capacity {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -1,12 +1,15 @@
 package repository
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/I1Asyl/berliner_backend/models"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Database struct {
@@ -17,20 +20,55 @@ type Transaction struct {
 	*sqlx.Tx
 }
 
+// PoolConfig limits the connection pool, zero values keep the database/sql defaults
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// applies the limits which are set to the pool
+func (p PoolConfig) apply(db *sql.DB) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+}
+
 // SetupOrm sets up the database connection
-func NewDatabase(dsn string) Database {
-	db, err := sqlx.Open("postgres", dsn)
+func NewDatabase(dsn string, pool PoolConfig) Database {
+	db, err := OpenDB(dsn, pool)
 	if err != nil {
 		log.Panic(err)
 	}
+	fmt.Println("init")
 
-	err = db.Ping()
+	return NewDatabaseFromDB(db)
+}
+
+// OpenDB opens the connection pool of the database and checks that it can connect
+func OpenDB(dsn string, pool PoolConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	fmt.Println("init")
+	pool.apply(db)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-	return Database{db}
+// NewDatabaseFromDB runs the queries on a connection pool shared with other components
+func NewDatabaseFromDB(db *sql.DB) Database {
+	return Database{sqlx.NewDb(db, "postgres")}
 }
 
 func (db Database) StartTransaction() Transaction {
@@ -54,11 +92,455 @@ func (db Transaction) GetChannelByName(name string) (models.Channel, error) {
 	return channel, err
 }
 
+func (db Database) GetChannelById(id int) (models.Channel, error) {
+	var channel models.Channel
+	err := db.Get(&channel, "SELECT * FROM channel WHERE id = $1", id)
+	return channel, err
+}
+
+func (db Database) SetChannelActive(channelId int, active bool) error {
+	_, err := db.Exec("UPDATE channel SET is_active = $1 WHERE id = $2", active, channelId)
+	return err
+}
+
+func (db Database) SetChannelAcceptingMembers(channelId int, accepting bool) error {
+	_, err := db.Exec("UPDATE channel SET accepting_members = $1 WHERE id = $2", accepting, channelId)
+	return err
+}
+
+func (db Database) AddRequest(request models.Request) error {
+	_, err := db.Exec("INSERT INTO request (channel_id, user_id, is_accepted) VALUES ($1, $2, $3)", request.ChannelId, request.UserId, request.IsAccepted)
+	return err
+}
+
+// false when the user has no pending request to the channel
+func (db Database) DeletePendingRequest(channelId int, userId int) (bool, error) {
+	result, err := db.Exec("DELETE FROM request WHERE channel_id = $1 AND user_id = $2 AND NOT is_accepted AND rejected_at IS NULL", channelId, userId)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (db Database) CountPendingRequests(channelId int) (int, error) {
+	var count int
+	err := db.Get(&count, "SELECT COUNT(*) FROM request WHERE channel_id = $1 AND NOT is_accepted AND rejected_at IS NULL", channelId)
+	return count, err
+}
+
+// pending requests to the channels the user leads with their channel and user, newest first
+func (db Database) GetLeaderPendingRequests(leaderId int, limit int, offset int) ([]models.RequestWithUser, error) {
+	requests := []models.RequestWithUser{}
+	query := `SELECT request.id, request.channel_id, request.user_id, request.is_accepted, channel.name AS channel_name,
+			"user".username, "user".first_name, "user".last_name
+		FROM request
+		JOIN channel ON request.channel_id = channel.id
+		JOIN "user" ON request.user_id = "user".id
+		WHERE channel.leader_id = $1 AND NOT request.is_accepted AND request.rejected_at IS NULL
+		ORDER BY request.id DESC
+		LIMIT $2 OFFSET $3`
+	err := db.Select(&requests, query, leaderId, limit, offset)
+	return requests, err
+}
+
+// marks the pending requests of the user as rejected at the given time, false when there was none
+func (db Database) RejectRequest(channelId int, userId int, at time.Time) (bool, error) {
+	result, err := db.Exec("UPDATE request SET rejected_at = $3 WHERE channel_id = $1 AND user_id = $2 AND NOT is_accepted AND rejected_at IS NULL", channelId, userId, at)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// sql.ErrNoRows is returned when no request of the user to the channel was rejected
+func (db Database) GetLastRejection(channelId int, userId int) (time.Time, error) {
+	var rejectedAt time.Time
+	err := db.Get(&rejectedAt, "SELECT rejected_at FROM request WHERE channel_id = $1 AND user_id = $2 AND rejected_at IS NOT NULL ORDER BY rejected_at DESC LIMIT 1", channelId, userId)
+	return rejectedAt, err
+}
+
+// sql.ErrNoRows is returned when the channel does not exist
+func (db Database) GetChannelRelationship(channelId int, userId int) (models.ChannelRelationship, error) {
+	var relationship models.ChannelRelationship
+	query := `SELECT
+		EXISTS (SELECT 1 FROM membership WHERE channel_id = channel.id AND user_id = $2) AS is_member,
+		EXISTS (SELECT 1 FROM membership WHERE channel_id = channel.id AND user_id = $2 AND is_editor) AS is_editor,
+		COALESCE(channel.leader_id = $2, false) AS is_leader,
+		EXISTS (SELECT 1 FROM request WHERE channel_id = channel.id AND user_id = $2 AND NOT is_accepted AND rejected_at IS NULL) AS has_pending_request
+		FROM channel WHERE channel.id = $1`
+	err := db.Get(&relationship, query, channelId, userId)
+	return relationship, err
+}
+
 func (db Database) GetUserByUserame(username string) (models.User, error) {
 	var user models.User
 	err := db.Get(&user, `SELECT * FROM "user" WHERE username = $1`, username)
 	return user, err
 }
+func (db Database) GetUserById(id int) (models.User, error) {
+	var user models.User
+	err := db.Get(&user, `SELECT * FROM "user" WHERE id = $1`, id)
+	return user, err
+}
+
+func (db Database) AddRefreshToken(token models.RefreshToken) error {
+	_, err := db.Exec("INSERT INTO refresh_token (user_id, token_hash, created_at, expires_at, session_id) VALUES ($1, $2, $3, $4, $5)", token.UserId, token.TokenHash, token.CreatedAt, token.ExpiresAt, token.SessionId)
+	return err
+}
+
+func (db Database) GetRefreshToken(tokenHash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := db.Get(&token, "SELECT * FROM refresh_token WHERE token_hash = $1", tokenHash)
+	return token, err
+}
+
+// revokes the refresh token, false when it was already revoked so a token can be exchanged only once
+func (db Database) RevokeRefreshToken(id int) (bool, error) {
+	result, err := db.Exec("UPDATE refresh_token SET revoked = true WHERE id = $1 AND NOT revoked", id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (db Database) RevokeUserRefreshTokens(userId int) error {
+	_, err := db.Exec("UPDATE refresh_token SET revoked = true WHERE user_id = $1 AND NOT revoked", userId)
+	return err
+}
+
+// returns the id of the new session
+func (db Database) AddSession(session models.Session) (int, error) {
+	var id int
+	err := db.Get(&id, "INSERT INTO session (user_id, device, created_at, last_used_at) VALUES ($1, $2, $3, $4) RETURNING id", session.UserId, session.Device, session.CreatedAt, session.LastUsedAt)
+	return id, err
+}
+
+func (db Database) TouchSession(id int, at time.Time) error {
+	_, err := db.Exec("UPDATE session SET last_used_at = $2 WHERE id = $1", id, at)
+	return err
+}
+
+// returns the sessions of the user with a refresh token which is neither revoked nor expired at now, last used first
+func (db Database) GetActiveSessions(userId int, now time.Time) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := db.Select(&sessions, `SELECT * FROM session WHERE user_id = $1 AND EXISTS (
+		SELECT 1 FROM refresh_token WHERE refresh_token.session_id = session.id AND NOT refresh_token.revoked AND refresh_token.expires_at > $2
+	) ORDER BY last_used_at DESC, id DESC`, userId, now)
+	return sessions, err
+}
+
+// deletes the session of the user with its refresh tokens, false when the user has no such session
+func (db Database) DeleteSession(userId int, id int) (bool, error) {
+	result, err := db.Exec("DELETE FROM session WHERE id = $1 AND user_id = $2", id, userId)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// deletes every session of the user but keepId with their refresh tokens
+// the refresh tokens issued before sessions were added are revoked as well
+func (db Database) DeleteOtherSessions(userId int, keepId int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM session WHERE user_id = $1 AND id <> $2", userId, keepId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE refresh_token SET revoked = true WHERE user_id = $1 AND session_id IS NULL AND NOT revoked", userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// emails are not unique and match ignoring case, the oldest account of the email is returned
+func (db Database) GetUserByEmail(email string) (models.User, error) {
+	var user models.User
+	err := db.Get(&user, `SELECT * FROM "user" WHERE lower(email) = lower($1) ORDER BY id LIMIT 1`, email)
+	return user, err
+}
+
+// an account of an identity provider can sign in with the password from then on
+func (db Database) UpdateUserPassword(userId int, password string) error {
+	_, err := db.Exec(`UPDATE "user" SET password = $1, auth_provider = 'password' WHERE id = $2`, password, userId)
+	return err
+}
+
+// gives the admin role to the users of the usernames unless a user has it already, returns the usernames of the new admins
+func (db Database) SeedAdmins(usernames []string) ([]string, error) {
+	seeded := []string{}
+	err := db.Select(&seeded, `UPDATE "user" SET role = 'admin' WHERE username = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM "user" WHERE role = 'admin') RETURNING username`, pq.Array(usernames))
+	return seeded, err
+}
+
+// sets the password like UpdateUserPassword and revokes the refresh tokens of the user in one transaction
+func (db Database) ChangeUserPassword(userId int, password string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE "user" SET password = $1, auth_provider = 'password' WHERE id = $2`, password, userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE refresh_token SET revoked = true WHERE user_id = $1 AND NOT revoked", userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sets up the two-factor authentication of the user with a new secret, replacing its previous setup and recovery codes
+// it is not enabled until EnableUserMFA
+func (db Database) SetUserMFA(mfa models.UserMFA, recoveryCodeHashes []string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO user_mfa (user_id, secret, enabled, last_step, created_at) VALUES ($1, $2, false, 0, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, enabled = false, last_step = 0, created_at = EXCLUDED.created_at`,
+		mfa.UserId, mfa.Secret, mfa.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_code WHERE user_id = $1", mfa.UserId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO mfa_recovery_code (user_id, code_hash) SELECT $1, unnest($2::text[])", mfa.UserId, pq.Array(recoveryCodeHashes)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db Database) GetUserMFA(userId int) (models.UserMFA, error) {
+	var mfa models.UserMFA
+	err := db.Get(&mfa, "SELECT * FROM user_mfa WHERE user_id = $1", userId)
+	return mfa, err
+}
+
+// enables the two-factor authentication of the user with the step of its first code, false when it is not set up or already enabled
+func (db Database) EnableUserMFA(userId int, step int64) (bool, error) {
+	result, err := db.Exec("UPDATE user_mfa SET enabled = true, last_step = $2 WHERE user_id = $1 AND NOT enabled", userId, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// records the step of a code the user gave, false when a code of it or a later step was already used
+func (db Database) UseMFAStep(userId int, step int64) (bool, error) {
+	result, err := db.Exec("UPDATE user_mfa SET last_step = $2 WHERE user_id = $1 AND last_step < $2", userId, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// deletes the recovery code of the user, false when the user has no such code
+func (db Database) UseRecoveryCode(userId int, codeHash string) (bool, error) {
+	result, err := db.Exec("DELETE FROM mfa_recovery_code WHERE user_id = $1 AND code_hash = $2", userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// deletes the two-factor authentication of the user with its recovery codes and pending logins
+func (db Database) DeleteUserMFA(userId int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"user_mfa", "mfa_recovery_code", "mfa_challenge"} {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE user_id = $1", table), userId); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// adds a pending login of the user, its expired ones are deleted
+func (db Database) AddMFAChallenge(challenge models.MFAChallenge, now time.Time) error {
+	if _, err := db.Exec("DELETE FROM mfa_challenge WHERE user_id = $1 AND expires_at <= $2", challenge.UserId, now); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO mfa_challenge (user_id, token_hash, device, expires_at) VALUES ($1, $2, $3, $4)",
+		challenge.UserId, challenge.TokenHash, challenge.Device, challenge.ExpiresAt)
+	return err
+}
+
+func (db Database) GetMFAChallenge(tokenHash string) (models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	err := db.Get(&challenge, "SELECT * FROM mfa_challenge WHERE token_hash = $1", tokenHash)
+	return challenge, err
+}
+
+// counts a wrong code of the pending login, returns its wrong codes so far
+func (db Database) FailMFAChallenge(id int) (int, error) {
+	var attempts int
+	err := db.Get(&attempts, "UPDATE mfa_challenge SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts", id)
+	return attempts, err
+}
+
+// deletes the pending login, false when it was already deleted so a login is completed only once
+func (db Database) DeleteMFAChallenge(id int) (bool, error) {
+	result, err := db.Exec("DELETE FROM mfa_challenge WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (db Database) AddAPIKey(key models.APIKey) (int, error) {
+	var id int
+	err := db.Get(&id, "INSERT INTO api_key (name, key_hash, scopes, created_at) VALUES ($1, $2, $3, $4) RETURNING id", key.Name, key.KeyHash, key.Scopes, key.CreatedAt)
+	return id, err
+}
+
+// returns every key including the revoked ones, oldest first
+func (db Database) GetAPIKeys() ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	err := db.Select(&keys, "SELECT * FROM api_key ORDER BY id")
+	return keys, err
+}
+
+// returns the key of the hash unless it was revoked
+func (db Database) GetAPIKeyByHash(keyHash string) (models.APIKey, error) {
+	var key models.APIKey
+	err := db.Get(&key, "SELECT * FROM api_key WHERE key_hash = $1 AND revoked_at IS NULL", keyHash)
+	return key, err
+}
+
+// revokes the key, false when there is no such key or it was already revoked
+func (db Database) RevokeAPIKey(id int, at time.Time) (bool, error) {
+	result, err := db.Exec("UPDATE api_key SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL", id, at)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// replaces the password reset of the user, so only the latest reset token can be used
+func (db Database) SetPasswordReset(reset models.PasswordReset) error {
+	_, err := db.Exec(`INSERT INTO password_reset (user_id, token_hash, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at`,
+		reset.UserId, reset.TokenHash, reset.ExpiresAt)
+	return err
+}
+
+// deletes and returns the password reset of the token, so a token can be used only once
+func (db Database) TakePasswordReset(tokenHash string) (models.PasswordReset, error) {
+	var reset models.PasswordReset
+	err := db.Get(&reset, "DELETE FROM password_reset WHERE token_hash = $1 RETURNING *", tokenHash)
+	return reset, err
+}
+
+// replaces the email verification of the user, so only the latest verification token can be used
+func (db Database) SetEmailVerification(verification models.EmailVerification) error {
+	_, err := db.Exec(`INSERT INTO email_verification (user_id, token_hash, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at`,
+		verification.UserId, verification.TokenHash, verification.ExpiresAt)
+	return err
+}
+
+// deletes and returns the email verification of the token, so a token can be used only once
+func (db Database) TakeEmailVerification(tokenHash string) (models.EmailVerification, error) {
+	var verification models.EmailVerification
+	err := db.Get(&verification, "DELETE FROM email_verification WHERE token_hash = $1 RETURNING *", tokenHash)
+	return verification, err
+}
+
+// sql.ErrNoRows is returned when the user has no email verification
+func (db Database) GetEmailVerification(userId int) (models.EmailVerification, error) {
+	var verification models.EmailVerification
+	err := db.Get(&verification, "SELECT * FROM email_verification WHERE user_id = $1", userId)
+	return verification, err
+}
+
+// updates the set fields of the user, a changed email is not verified anymore
+func (db Database) UpdateUser(userId int, update models.UserUpdate) error {
+	_, err := db.Exec(`UPDATE "user" SET
+		first_name = COALESCE($2, first_name),
+		last_name = COALESCE($3, last_name),
+		is_verified = is_verified AND ($4::text IS NULL OR lower($4) = lower(email)),
+		email = COALESCE($4, email)
+		WHERE id = $1`, userId, update.FirstName, update.LastName, update.Email)
+	return err
+}
+
+// deletes the user, the channels they lead go to their longest member editor or are deleted without one
+// memberships have no join time, so the oldest membership row is the longest tenured
+func (db Database) DeleteUser(userId int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	editor := `SELECT membership.user_id FROM membership
+		WHERE membership.channel_id = channel.id AND membership.is_editor AND membership.user_id <> $1
+		ORDER BY membership.id LIMIT 1`
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM channel WHERE leader_id = $1 AND NOT EXISTS (%s)", editor), userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE channel SET leader_id = (%s) WHERE leader_id = $1", editor), userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM "user" WHERE id = $1`, userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db Database) SetUserVerified(userId int) error {
+	_, err := db.Exec(`UPDATE "user" SET is_verified = true WHERE id = $1`, userId)
+	return err
+}
+
+// revoking a token twice keeps the first entry
+func (db Database) AddRevokedToken(token models.RevokedToken) error {
+	_, err := db.Exec("INSERT INTO revoked_token (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", token.Jti, token.ExpiresAt)
+	return err
+}
+
+func (db Database) IsTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := db.Get(&revoked, "SELECT EXISTS (SELECT 1 FROM revoked_token WHERE jti = $1)", jti)
+	return revoked, err
+}
+
+// deletes the revoked tokens which expired by now, they are rejected as expired anyway
+func (db Database) DeleteExpiredRevokedTokens(now time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM revoked_token WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// the user has a zero id when the channel has no leader
+func (db Database) GetChannelLeader(channelId int) (models.User, error) {
+	var user models.User
+	query := `SELECT COALESCE("user".id, 0) AS id, COALESCE("user".username, '') AS username,
+			COALESCE("user".first_name, '') AS first_name, COALESCE("user".last_name, '') AS last_name,
+			COALESCE("user".email, '') AS email
+		FROM channel LEFT JOIN "user" ON "user".id = channel.leader_id
+		WHERE channel.id = $1`
+	err := db.Get(&user, query, channelId)
+	return user, err
+}
+
 func (db Transaction) GetUserByUserame(username string) (models.User, error) {
 	var user models.User
 	err := db.Get(&user, `SELECT * FROM "user" WHERE username = $1`, username)
@@ -80,6 +562,16 @@ func (db Database) AddUser(user models.User) error {
 	_, err := db.Exec(`INSERT INTO "user" (username, first_name, last_name, email, password) VALUES ($1, $2, $3, $4, $5)`, user.Username, user.FirstName, user.LastName, user.Email, user.Password)
 	return err
 }
+
+// adds a user of an identity provider without a password, its email is verified by the provider
+// returns the id of the new user
+func (db Database) AddProviderUser(user models.User) (int, error) {
+	var id int
+	err := db.Get(&id, `INSERT INTO "user" (username, first_name, last_name, email, password, is_verified, auth_provider)
+		VALUES ($1, $2, $3, $4, '', true, $5) RETURNING id`, user.Username, user.FirstName, user.LastName, user.Email, user.AuthProvider)
+	return id, err
+}
+
 func (db Transaction) AddUser(user models.User) error {
 	_, err := db.Exec(`INSERT INTO "user" (username, first_name, last_name, email, password) VALUES ($1, $2, $3, $4, $5)`, user.Username, user.FirstName, user.LastName, user.Email, user.Password)
 	return err
@@ -103,6 +595,14 @@ func (db Transaction) AddChannel(channel models.Channel) error {
 	return err
 }
 
+// adds a post of the user or of the channel with the author id, by the author type of the post
+func (db Database) AddPost(post models.Post, authorId int) error {
+	if post.AuthorType == "channel" {
+		return db.AddChannelPost(models.ChannelPost{ChannelId: authorId, Post: post})
+	}
+	return db.AddUserPost(models.UserPost{UserId: authorId, Post: post})
+}
+
 func (db Database) AddUserPost(post models.UserPost) error {
 	_, err := db.Exec("INSERT INTO user_post (author_type, content, updated_at, created_at, user_id, is_public) VALUES ($1, $2, $3, $4, $5, $6);", post.AuthorType, post.Content, post.UpdatedAt, post.CreatedAt, post.UserId, post.IsPublic)
 	return err
@@ -112,6 +612,12 @@ func (db Database) AddChannelPost(post models.ChannelPost) error {
 	fmt.Println(post.ChannelId)
 	return err
 }
+func (db Database) CountUserPostsBetween(userId int, from time.Time, to time.Time) (int, error) {
+	var count int
+	err := db.Get(&count, "SELECT COUNT(*) FROM user_post WHERE user_id = $1 AND created_at >= $2 AND created_at < $3", userId, from, to)
+	return count, err
+}
+
 func (db Database) DeleteUserPost(post models.UserPost) error {
 	_, err := db.Exec("DELETE FROM user_post WHERE id = $1;", post.Id)
 	return err
@@ -122,22 +628,119 @@ func (db Database) DeleteChannelPost(post models.ChannelPost) error {
 	return err
 }
 
+func (db Transaction) GetUserPostsByIds(ids []int) ([]models.UserPost, error) {
+	var posts []models.UserPost
+	err := db.Select(&posts, "SELECT * FROM user_post WHERE id = ANY($1) FOR UPDATE", pq.Array(ids))
+	return posts, err
+}
+
+func (db Transaction) GetChannelPostsByIds(ids []int) ([]models.ChannelPost, error) {
+	var posts []models.ChannelPost
+	err := db.Select(&posts, "SELECT * FROM channel_post WHERE id = ANY($1) FOR UPDATE", pq.Array(ids))
+	return posts, err
+}
+
+func (db Transaction) GetEditorChannelIds(user models.User) ([]int, error) {
+	var ids []int
+	err := db.Select(&ids, "SELECT channel_id FROM membership WHERE user_id = $1 AND is_editor", user.Id)
+	return ids, err
+}
+
+func (db Transaction) DeleteUserPostsByIds(ids []int) error {
+	_, err := db.Exec("DELETE FROM user_post WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
+
+func (db Transaction) DeleteChannelPostsByIds(ids []int) error {
+	_, err := db.Exec("DELETE FROM channel_post WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
+
 func (db Database) GetUserPosts(user models.User) ([]struct {
 	models.User
 	models.UserPost
 }, error) {
 	//var posts []models.UserPost
 	users := "SELECT following.user_id FROM following WHERE following.follower_id=$1"
+	muted := "SELECT mute.muted_id FROM mute WHERE mute.muter_id=$1"
 	var newTable []struct {
 		models.User
 		models.UserPost
 	}
 
-	err := db.Select(&newTable, fmt.Sprintf(`SELECT user_post.*, "user".username, "user".first_name, "user".last_name FROM user_post LEFT JOIN "user" on user_post.user_id = "user".id WHERE (user_post.user_id in (%v) AND user_post.is_public) OR user_post.user_id = $2 ORDER BY updated_at DESC`, users), user.Id, user.Id)
+	err := db.Select(&newTable, fmt.Sprintf(`SELECT user_post.*, "user".username, "user".first_name, "user".last_name FROM user_post LEFT JOIN "user" on user_post.user_id = "user".id WHERE ((user_post.user_id in (%v) AND user_post.is_public) OR user_post.user_id = $2) AND user_post.user_id NOT in (%v) ORDER BY updated_at DESC`, users, muted), user.Id, user.Id)
 	return newTable, err
 
 }
 
+func (db Database) GetAllUserPosts(userId int) ([]models.Post, error) {
+	posts := []models.Post{}
+	err := db.Select(&posts, "SELECT id, updated_at, created_at, author_type, content, is_public FROM user_post WHERE user_id = $1 ORDER BY created_at, id", userId)
+	return posts, err
+}
+
+// escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// user posts are visible when they are public or written by the viewer, posts of muted users are left out
+// channel posts are visible when they are public in an active channel or the viewer leads the channel
+func (db Database) SearchPosts(viewerId int, query string, limit int, offset int) ([]models.Post, error) {
+	posts := []models.Post{}
+	search := `SELECT id, updated_at, created_at, author_type, content, is_public FROM (
+			SELECT user_post.id, user_post.updated_at, user_post.created_at, user_post.author_type, user_post.content, user_post.is_public
+			FROM user_post
+			WHERE user_post.content ILIKE $2
+				AND (user_post.is_public OR user_post.user_id = $1)
+				AND user_post.user_id NOT IN (SELECT mute.muted_id FROM mute WHERE mute.muter_id = $1)
+			UNION ALL
+			SELECT channel_post.id, channel_post.updated_at, channel_post.created_at, channel_post.author_type, channel_post.content, channel_post.is_public
+			FROM channel_post JOIN channel ON channel.id = channel_post.channel_id
+			WHERE channel_post.content ILIKE $2
+				AND ((channel_post.is_public AND channel.is_active) OR channel.leader_id = $1)
+		) AS posts
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`
+	err := db.Select(&posts, search, viewerId, "%"+likeEscaper.Replace(query)+"%", limit, offset)
+	return posts, err
+}
+
+// users whose username, first name or last name starts with the query ignoring case, ordered by username
+// only the public fields are selected
+func (db Database) SearchUsers(query string, limit int, offset int) ([]models.User, error) {
+	users := []models.User{}
+	search := `SELECT id, username, first_name, last_name FROM "user"
+		WHERE username ILIKE $1 OR first_name ILIKE $1 OR last_name ILIKE $1
+		ORDER BY username
+		LIMIT $2 OFFSET $3`
+	err := db.Select(&users, search, likeEscaper.Replace(query)+"%", limit, offset)
+	return users, err
+}
+
+// posts of users and channels visible to the viewer which have all the tags, or any of them when all is false
+// the tags are lowercase and without the #, newest first
+func (db Database) GetPostsByHashtags(viewerId int, tags []string, all bool, limit int, offset int) ([]models.Post, error) {
+	posts := []models.Post{}
+	match := "&&"
+	if all {
+		match = "@>"
+	}
+	query := fmt.Sprintf(`SELECT id, updated_at, created_at, author_type, content, is_public FROM (
+			SELECT user_post.id, user_post.updated_at, user_post.created_at, user_post.author_type, user_post.content, user_post.is_public
+			FROM user_post
+			WHERE (user_post.is_public OR user_post.user_id = $1)
+				AND user_post.user_id NOT IN (SELECT mute.muted_id FROM mute WHERE mute.muter_id = $1)
+			UNION ALL
+			SELECT channel_post.id, channel_post.updated_at, channel_post.created_at, channel_post.author_type, channel_post.content, channel_post.is_public
+			FROM channel_post JOIN channel ON channel.id = channel_post.channel_id
+			WHERE (channel_post.is_public AND channel.is_active) OR channel.leader_id = $1
+		) AS posts
+		WHERE ARRAY(SELECT lower(m.parts[1]) FROM regexp_matches(posts.content, '#(\w+)', 'g') AS m(parts)) %s $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`, match)
+	err := db.Select(&posts, query, viewerId, pq.Array(tags), limit, offset)
+	return posts, err
+}
+
 func (db Database) GetChannelPosts(user models.User) ([]struct {
 	models.Channel
 	models.ChannelPost
@@ -152,6 +755,70 @@ func (db Database) GetChannelPosts(user models.User) ([]struct {
 	return newTable, err
 
 }
+
+// posts of the channels the user is a member of, newest first
+// posts are visible when they are public in an active channel or the user leads the channel
+func (db Database) GetChannelFeed(userId int, limit int, offset int) ([]struct {
+	models.Channel
+	models.ChannelPost
+}, error) {
+	newTable := []struct {
+		models.Channel
+		models.ChannelPost
+	}{}
+	query := `SELECT channel_post.*, channel.name, channel.leader_id FROM channel_post
+		JOIN channel ON channel_post.channel_id = channel.id
+		WHERE channel_post.channel_id IN (SELECT membership.channel_id FROM membership WHERE membership.user_id = $1)
+			AND ((channel_post.is_public AND channel.is_active) OR channel.leader_id = $1)
+		ORDER BY channel_post.created_at DESC, channel_post.id DESC
+		LIMIT $2 OFFSET $3`
+	err := db.Select(&newTable, query, userId, limit, offset)
+	return newTable, err
+}
+
+// public posts of the users the user follows, newest first
+// posts of muted users are left out
+func (db Database) GetPeopleFeed(userId int, limit int, offset int) ([]struct {
+	models.User
+	models.UserPost
+}, error) {
+	newTable := []struct {
+		models.User
+		models.UserPost
+	}{}
+	query := `SELECT user_post.*, "user".username, "user".first_name, "user".last_name FROM user_post
+		JOIN "user" ON user_post.user_id = "user".id
+		WHERE user_post.user_id IN (SELECT following.user_id FROM following WHERE following.follower_id = $1)
+			AND user_post.user_id NOT IN (SELECT mute.muted_id FROM mute WHERE mute.muter_id = $1)
+			AND user_post.is_public
+		ORDER BY user_post.created_at DESC, user_post.id DESC
+		LIMIT $2 OFFSET $3`
+	err := db.Select(&newTable, query, userId, limit, offset)
+	return newTable, err
+}
+
+// posts of the channel feed and the people feed together, newest first
+// posts of both tables created at the same time are ordered by author type, so pages stay stable
+func (db Database) GetFeed(userId int, limit int, offset int) ([]models.Post, error) {
+	posts := []models.Post{}
+	query := `SELECT id, updated_at, created_at, author_type, content, is_public FROM (
+			SELECT channel_post.id, channel_post.updated_at, channel_post.created_at, channel_post.author_type, channel_post.content, channel_post.is_public
+			FROM channel_post JOIN channel ON channel.id = channel_post.channel_id
+			WHERE channel_post.channel_id IN (SELECT membership.channel_id FROM membership WHERE membership.user_id = $1)
+				AND ((channel_post.is_public AND channel.is_active) OR channel.leader_id = $1)
+			UNION ALL
+			SELECT user_post.id, user_post.updated_at, user_post.created_at, user_post.author_type, user_post.content, user_post.is_public
+			FROM user_post
+			WHERE user_post.user_id IN (SELECT following.user_id FROM following WHERE following.follower_id = $1)
+				AND user_post.user_id NOT IN (SELECT mute.muted_id FROM mute WHERE mute.muter_id = $1)
+				AND user_post.is_public
+		) AS posts
+		ORDER BY created_at DESC, author_type, id DESC
+		LIMIT $2 OFFSET $3`
+	err := db.Select(&posts, query, userId, limit, offset)
+	return posts, err
+}
+
 func (db Database) GetMyChannelPosts(user models.User) ([]struct {
 	models.Channel
 	models.ChannelPost
@@ -172,12 +839,13 @@ func (db Database) GetNewUserPosts(user models.User) ([]struct {
 }, error) {
 	//var posts []models.UserPost
 	users := "SELECT following.user_id FROM following WHERE following.follower_id=$1"
+	muted := "SELECT mute.muted_id FROM mute WHERE mute.muter_id=$1"
 	var newTable []struct {
 		models.User
 		models.UserPost
 	}
 
-	err := db.Select(&newTable, fmt.Sprintf(`SELECT user_post.*, "user".username, "user".first_name, "user".last_name FROM user_post LEFT JOIN "user" on user_post.user_id = "user".id WHERE user_post.user_id NOT in (%v) AND NOT user_post.user_id = $2 AND user_post.is_public = true ORDER BY updated_at DESC`, users), user.Id, user.Id)
+	err := db.Select(&newTable, fmt.Sprintf(`SELECT user_post.*, "user".username, "user".first_name, "user".last_name FROM user_post LEFT JOIN "user" on user_post.user_id = "user".id WHERE user_post.user_id NOT in (%v) AND user_post.user_id NOT in (%v) AND NOT user_post.user_id = $2 AND user_post.is_public = true ORDER BY updated_at DESC`, users, muted), user.Id, user.Id)
 	return newTable, err
 }
 
@@ -192,7 +860,7 @@ func (db Database) GetNewChannelPosts(user models.User) ([]struct {
 		models.ChannelPost
 	}
 
-	err := db.Select(&newTable, fmt.Sprintf("SELECT channel_post.*, channel.name FROM channel_post LEFT JOIN channel on channel_post.channel_id = channel.id WHERE channel_post.channel_id NOT in (%v) AND channel_post.is_public = true ORDER BY updated_at DESC", users), user.Id)
+	err := db.Select(&newTable, fmt.Sprintf("SELECT channel_post.*, channel.name FROM channel_post LEFT JOIN channel on channel_post.channel_id = channel.id WHERE channel_post.channel_id NOT in (%v) AND channel_post.is_public = true AND channel.is_active ORDER BY updated_at DESC", users), user.Id)
 	return newTable, err
 }
 
@@ -207,6 +875,17 @@ func (db Database) FollowUser(follower models.User, user models.User) error {
 	return err
 }
 
+func (db Database) MuteUser(muterId int, mutedId int) error {
+	query := "INSERT INTO mute (muter_id, muted_id) VALUES ($1, $2) ON CONFLICT (muter_id, muted_id) DO NOTHING"
+	_, err := db.Exec(query, muterId, mutedId)
+	return err
+}
+
+func (db Database) UnmuteUser(muterId int, mutedId int) error {
+	_, err := db.Exec("DELETE FROM mute WHERE muter_id = $1 AND muted_id = $2", muterId, mutedId)
+	return err
+}
+
 func (db Database) UnfollowChannel(user models.User, channel models.Channel) error {
 	query := "DELETE FROM membership WHERE channel_id = $1 AND user_id = $2"
 	_, err := db.Exec(query, channel.Id, user.Id)
@@ -220,7 +899,41 @@ func (db Database) UnfollowUser(follower models.User, user models.User) error {
 
 func (db Database) GetFollowing(user models.User) ([]models.User, error) {
 	var users []models.User
-	err := db.Select(&users, "SELECT * FROM following WHERE follower_id = $1", user.Id)
+	query := `SELECT "user".id, "user".username, "user".first_name, "user".last_name, "user".email
+		FROM following JOIN "user" ON "user".id = following.user_id
+		WHERE following.follower_id = $1 AND following.user_id <> $1
+		ORDER BY following.created_at DESC, following.id DESC`
+	err := db.Select(&users, query, user.Id)
+	return users, err
+}
+
+// followed users without posts created since, most recently followed first
+func (db Database) GetInactiveFollowing(userId int, since time.Time) ([]models.User, error) {
+	users := []models.User{}
+	query := `SELECT "user".id, "user".username, "user".first_name, "user".last_name, "user".email
+		FROM following JOIN "user" ON "user".id = following.user_id
+		WHERE following.follower_id = $1 AND following.user_id <> $1
+			AND NOT EXISTS (
+				SELECT 1 FROM user_post
+				WHERE user_post.user_id = following.user_id AND user_post.created_at >= $2
+			)
+		ORDER BY following.created_at DESC, following.id DESC`
+	err := db.Select(&users, query, userId, since)
+	return users, err
+}
+
+func (db Database) UnfollowUsers(followerId int, userIds []int) error {
+	_, err := db.Exec("DELETE FROM following WHERE follower_id = $1 AND user_id = ANY($2)", followerId, pq.Array(userIds))
+	return err
+}
+
+func (db Database) GetFollowers(user models.User) ([]models.User, error) {
+	var users []models.User
+	query := `SELECT "user".id, "user".username, "user".first_name, "user".last_name, "user".email
+		FROM following JOIN "user" ON "user".id = following.follower_id
+		WHERE following.user_id = $1 AND following.follower_id <> $1
+		ORDER BY following.created_at DESC, following.id DESC`
+	err := db.Select(&users, query, user.Id)
 	return users, err
 }
 
@@ -230,10 +943,110 @@ func (db Database) DeleteChannel(channel models.Channel) error {
 }
 
 func (db Database) AddFollowing(following models.Following) error {
-	_, err := db.Exec("INSERT INTO following (follower_id, user_id) VALUES ($1, $2)", following.FollowerId, following.UserId)
+	_, err := db.Exec("INSERT INTO following (follower_id, user_id, created_at) VALUES ($1, $2, $3)", following.FollowerId, following.UserId, following.CreatedAt)
 	return err
 }
 
+func (db Database) GetUserTopHashtags(userId int, limit int) ([]models.TagCount, error) {
+	var tags []models.TagCount
+	query := `SELECT lower(m.parts[1]) AS tag, COUNT(*) AS count
+		FROM user_post, regexp_matches(user_post.content, '#(\w+)', 'g') AS m(parts)
+		WHERE user_post.user_id = $1 AND user_post.is_public
+		GROUP BY lower(m.parts[1])
+		ORDER BY count DESC, tag
+		LIMIT $2`
+	err := db.Select(&tags, query, userId, limit)
+	return tags, err
+}
+
+func (db Database) GetFollowerCounts(userId int, from time.Time, to time.Time, bucket string) ([]models.GrowthPoint, error) {
+	var points []models.GrowthPoint
+	query := `SELECT date_trunc($2, created_at AT TIME ZONE 'UTC') AS start, COUNT(*) AS count
+		FROM following
+		WHERE user_id = $1 AND follower_id <> $1 AND created_at >= $3 AND created_at < $4
+		GROUP BY start
+		ORDER BY start`
+	err := db.Select(&points, query, userId, bucket, from, to)
+	return points, err
+}
+
+func (db Database) GetChannelPostCounts(channelId int, from time.Time, to time.Time, bucket string) ([]models.GrowthPoint, error) {
+	var points []models.GrowthPoint
+	query := `SELECT date_trunc($2, created_at AT TIME ZONE 'UTC') AS start, COUNT(*) AS count
+		FROM channel_post
+		WHERE channel_id = $1 AND created_at >= $3 AND created_at < $4
+		GROUP BY start
+		ORDER BY start`
+	err := db.Select(&points, query, channelId, bucket, from, to)
+	return points, err
+}
+
+func (db Database) GetInstanceStats(activeSince time.Time) (models.InstanceStats, error) {
+	var stats models.InstanceStats
+	query := `SELECT
+		(SELECT COUNT(*) FROM "user") AS users,
+		(SELECT COUNT(*) FROM channel) AS channels,
+		(SELECT COUNT(*) FROM user_post) + (SELECT COUNT(*) FROM channel_post) AS posts,
+		(SELECT COUNT(DISTINCT user_id) FROM user_post WHERE created_at >= $1) AS active_users`
+	err := db.Get(&stats, query, activeSince)
+	return stats, err
+}
+
+// active channels ordered by id
+func (db Database) GetPublicChannels(limit int, offset int) ([]models.Channel, error) {
+	channels := []models.Channel{}
+	err := db.Select(&channels, "SELECT * FROM channel WHERE is_active ORDER BY id LIMIT $1 OFFSET $2", limit, offset)
+	return channels, err
+}
+
+func (db Database) GetInactiveChannels(since time.Time, limit int) ([]models.Channel, error) {
+	channels := []models.Channel{}
+	query := `SELECT channel.* FROM channel
+		WHERE NOT EXISTS (
+			SELECT 1 FROM channel_post
+			WHERE channel_post.channel_id = channel.id AND channel_post.created_at >= $1
+		)
+		ORDER BY channel.id
+		LIMIT $2`
+	err := db.Select(&channels, query, since, limit)
+	return channels, err
+}
+
+// channels the user leads or is a member of, the leader role wins over the membership of the leader
+func (db Database) GetChannelRoles(userId int) ([]models.ChannelRole, error) {
+	roles := []models.ChannelRole{}
+	query := `SELECT channel.*,
+		CASE WHEN channel.leader_id = $1 THEN 'leader' WHEN membership.is_editor THEN 'editor' ELSE 'member' END AS role
+		FROM channel
+		LEFT JOIN membership ON membership.channel_id = channel.id AND membership.user_id = $1
+		WHERE channel.leader_id = $1 OR membership.user_id IS NOT NULL
+		ORDER BY channel.id`
+	err := db.Select(&roles, query, userId)
+	return roles, err
+}
+
+// active channels the user is not a member of, ranked by how many members of the user's channels belong to them
+func (db Database) SuggestChannels(userId int, limit int) ([]models.Channel, error) {
+	channels := []models.Channel{}
+	query := `SELECT channel.* FROM channel
+		JOIN membership ON membership.channel_id = channel.id
+		WHERE membership.user_id IN (
+			SELECT other.user_id FROM membership mine
+			JOIN membership other ON other.channel_id = mine.channel_id
+			WHERE mine.user_id = $1 AND other.user_id <> $1
+		)
+		AND channel.is_active
+		AND NOT EXISTS (
+			SELECT 1 FROM membership own
+			WHERE own.channel_id = channel.id AND own.user_id = $1
+		)
+		GROUP BY channel.id
+		ORDER BY COUNT(DISTINCT membership.user_id) DESC, channel.id
+		LIMIT $2`
+	err := db.Select(&channels, query, userId, limit)
+	return channels, err
+}
+
 func (db Database) UpdateChannel(channel models.Channel) error {
 	if channel.Name != "" {
 		_, err := db.Exec("UPDATE channel SET name = $1 WHERE channel_id = $2", channel.Name, channel.Id)
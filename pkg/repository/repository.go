@@ -1,6 +1,10 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"time"
+
 	"github.com/I1Asyl/berliner_backend/models"
 )
 
@@ -10,13 +14,70 @@ type SqlQueries interface {
 	UnfollowChannel(user models.User, channel models.Channel) error
 	UnfollowUser(follower models.User, user models.User) error
 	GetChannelByName(name string) (models.Channel, error)
+	GetChannelById(id int) (models.Channel, error)
+	GetPublicChannels(limit int, offset int) ([]models.Channel, error)
+	SetChannelActive(channelId int, active bool) error
+	SetChannelAcceptingMembers(channelId int, accepting bool) error
+	AddRequest(request models.Request) error
+	DeletePendingRequest(channelId int, userId int) (bool, error)
+	CountPendingRequests(channelId int) (int, error)
+	GetLeaderPendingRequests(leaderId int, limit int, offset int) ([]models.RequestWithUser, error)
+	RejectRequest(channelId int, userId int, at time.Time) (bool, error)
+	GetLastRejection(channelId int, userId int) (time.Time, error)
 	GetUserByUserame(name string) (models.User, error)
+	GetUserById(id int) (models.User, error)
+	AddProviderUser(user models.User) (int, error)
+	SearchUsers(query string, limit int, offset int) ([]models.User, error)
+	AddRefreshToken(token models.RefreshToken) error
+	GetRefreshToken(tokenHash string) (models.RefreshToken, error)
+	RevokeRefreshToken(id int) (bool, error)
+	RevokeUserRefreshTokens(userId int) error
+	AddSession(session models.Session) (int, error)
+	TouchSession(id int, at time.Time) error
+	GetActiveSessions(userId int, now time.Time) ([]models.Session, error)
+	DeleteSession(userId int, id int) (bool, error)
+	DeleteOtherSessions(userId int, keepId int) error
+	GetUserByEmail(email string) (models.User, error)
+	UpdateUserPassword(userId int, password string) error
+	ChangeUserPassword(userId int, password string) error
+	SeedAdmins(usernames []string) ([]string, error)
+	SetUserMFA(mfa models.UserMFA, recoveryCodeHashes []string) error
+	GetUserMFA(userId int) (models.UserMFA, error)
+	EnableUserMFA(userId int, step int64) (bool, error)
+	UseMFAStep(userId int, step int64) (bool, error)
+	UseRecoveryCode(userId int, codeHash string) (bool, error)
+	DeleteUserMFA(userId int) error
+	AddMFAChallenge(challenge models.MFAChallenge, now time.Time) error
+	GetMFAChallenge(tokenHash string) (models.MFAChallenge, error)
+	FailMFAChallenge(id int) (int, error)
+	DeleteMFAChallenge(id int) (bool, error)
+	AddAPIKey(key models.APIKey) (int, error)
+	GetAPIKeys() ([]models.APIKey, error)
+	GetAPIKeyByHash(keyHash string) (models.APIKey, error)
+	RevokeAPIKey(id int, at time.Time) (bool, error)
+	SetPasswordReset(reset models.PasswordReset) error
+	TakePasswordReset(tokenHash string) (models.PasswordReset, error)
+	SetEmailVerification(verification models.EmailVerification) error
+	TakeEmailVerification(tokenHash string) (models.EmailVerification, error)
+	GetEmailVerification(userId int) (models.EmailVerification, error)
+	UpdateUser(userId int, update models.UserUpdate) error
+	DeleteUser(userId int) error
+	SetUserVerified(userId int) error
+	AddRevokedToken(token models.RevokedToken) error
+	IsTokenRevoked(jti string) (bool, error)
+	DeleteExpiredRevokedTokens(now time.Time) (int64, error)
+	GetChannelLeader(channelId int) (models.User, error)
+	GetChannelRelationship(channelId int, userId int) (models.ChannelRelationship, error)
+	MuteUser(muterId int, mutedId int) error
+	UnmuteUser(muterId int, mutedId int) error
 	GetUserChannels(user models.User) ([]models.Channel, error)
 	AddMembership(models.Membership) error
 	AddUser(models.User) error
 	AddChannel(channel models.Channel) error
 	AddUserPost(post models.UserPost) error
+	AddPost(post models.Post, authorId int) error
 	AddChannelPost(post models.ChannelPost) error
+	CountUserPostsBetween(userId int, from time.Time, to time.Time) (int, error)
 	DeleteUserPost(post models.UserPost) error
 	DeleteChannelPost(post models.ChannelPost) error
 	AddFollowing(following models.Following) error
@@ -42,7 +103,29 @@ type SqlQueries interface {
 		models.ChannelPost
 	}, error)
 	GetFollowing(user models.User) ([]models.User, error)
+	GetInactiveFollowing(userId int, since time.Time) ([]models.User, error)
+	UnfollowUsers(followerId int, userIds []int) error
+	GetFollowers(user models.User) ([]models.User, error)
+	GetAllUserPosts(userId int) ([]models.Post, error)
+	SearchPosts(viewerId int, query string, limit int, offset int) ([]models.Post, error)
+	GetPostsByHashtags(viewerId int, tags []string, all bool, limit int, offset int) ([]models.Post, error)
 	UpdateChannel(channel models.Channel) error
+	GetInstanceStats(activeSince time.Time) (models.InstanceStats, error)
+	GetInactiveChannels(since time.Time, limit int) ([]models.Channel, error)
+	GetChannelRoles(userId int) ([]models.ChannelRole, error)
+	SuggestChannels(userId int, limit int) ([]models.Channel, error)
+	GetChannelFeed(userId int, limit int, offset int) ([]struct {
+		models.Channel
+		models.ChannelPost
+	}, error)
+	GetPeopleFeed(userId int, limit int, offset int) ([]struct {
+		models.User
+		models.UserPost
+	}, error)
+	GetFeed(userId int, limit int, offset int) ([]models.Post, error)
+	GetFollowerCounts(userId int, from time.Time, to time.Time, bucket string) ([]models.GrowthPoint, error)
+	GetChannelPostCounts(channelId int, from time.Time, to time.Time, bucket string) ([]models.GrowthPoint, error)
+	GetUserTopHashtags(userId int, limit int) ([]models.TagCount, error)
 	DeleteChannel(channel models.Channel) error
 }
 
@@ -52,6 +135,16 @@ type Repository struct {
 
 //go:generate mockgen -source=repository.go -destination=mocks/repository.go
 
-func NewRepository(dsn string) *Repository {
-	return &Repository{SqlQueries: NewDatabase(dsn)}
+func NewRepository(dsn string, pool PoolConfig) *Repository {
+	return &Repository{SqlQueries: NewDatabase(dsn, pool)}
+}
+
+// NewRepositoryFromDB creates a repository on a connection pool shared with other components
+func NewRepositoryFromDB(db *sql.DB) *Repository {
+	return &Repository{SqlQueries: NewDatabaseFromDB(db)}
+}
+
+// NewRotatingRepository creates a repository which follows rotations of the database credentials
+func NewRotatingRepository(ctx context.Context, dsn DSNFunc, config RotationConfig, pool PoolConfig) *Repository {
+	return &Repository{SqlQueries: NewRotatingDatabase(ctx, dsn, config, pool)}
 }
@@ -0,0 +1,240 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgres error codes of failed logins
+const (
+	invalidPassword          = "28P01"
+	invalidAuthorizationSpec = "28000"
+)
+
+// defaults of the rotation config
+const (
+	defaultRotationFailureThreshold = 3
+	minRotationRefreshInterval      = 10 * time.Second
+	rotationRefreshTimeout          = 30 * time.Second
+)
+
+// number of credential rotations handled since the process started, served by expvar at /admin/vars
+var credentialRotations = expvar.NewInt("db_credential_rotations")
+
+// DSNFunc returns the current DSN of the database
+// it is called again when the credentials of the database seem to have been rotated
+type DSNFunc func(ctx context.Context) (string, error)
+
+// RotationConfig controls when the DSN of a rotating database is fetched again
+type RotationConfig struct {
+	// consecutive failed logins after which the DSN is fetched again, defaults to 3
+	FailureThreshold int
+	// the DSN is also fetched at this interval, zero disables polling
+	PollInterval time.Duration
+}
+
+// NewRotatingDatabase sets up a database connection whose DSN is fetched again when the credentials rotate
+// new connections use the new DSN while connections already open, and queries running on them, are kept
+func NewRotatingDatabase(ctx context.Context, dsn DSNFunc, config RotationConfig, pool PoolConfig) Database {
+	db, err := OpenRotatingDB(ctx, dsn, config, pool)
+	if err != nil {
+		log.Panic(err)
+	}
+	return NewDatabaseFromDB(db)
+}
+
+// OpenRotatingDB opens a connection pool whose DSN is fetched again when the credentials rotate
+// and checks that it can connect, polling stops when ctx is done
+func OpenRotatingDB(ctx context.Context, dsn DSNFunc, config RotationConfig, pool PoolConfig) (*sql.DB, error) {
+	connector, err := newRotatingConnector(ctx, dsn, config)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	pool.apply(db)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if config.PollInterval > 0 {
+		go connector.poll(ctx, config.PollInterval)
+	}
+	return db, nil
+}
+
+// rotatingConnector opens postgres connections with the current DSN and swaps it when the credentials rotate
+type rotatingConnector struct {
+	dsn       DSNFunc
+	threshold int
+	// creates the connector of a DSN, pq by default
+	open func(dsn string) (driver.Connector, error)
+
+	// serializes fetching the DSN
+	refreshMu   sync.Mutex
+	lastRefresh time.Time
+
+	mu        sync.Mutex
+	current   string
+	connector driver.Connector
+	// incremented on every swap, so a failed connect can tell that the DSN changed in the meantime
+	generation int
+	failures   int
+}
+
+// newRotatingConnector fetches the initial DSN
+func newRotatingConnector(ctx context.Context, dsn DSNFunc, config RotationConfig) (*rotatingConnector, error) {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaultRotationFailureThreshold
+	}
+	c := &rotatingConnector{
+		dsn:       dsn,
+		threshold: config.FailureThreshold,
+		open: func(dsn string) (driver.Connector, error) {
+			return pq.NewConnector(dsn)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rotationRefreshTimeout)
+	defer cancel()
+	current, err := dsn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.swap(current); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connect opens a connection with the current DSN
+// after repeated failed logins the DSN is fetched again and the connection is retried once with the new one
+func (c *rotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, generation := c.get()
+	conn, err := connector.Connect(ctx)
+	if err == nil {
+		c.succeeded()
+		return conn, nil
+	}
+	if !isAuthError(err) || !c.failed() {
+		return nil, err
+	}
+
+	if _, refreshErr := c.refresh(ctx, true); refreshErr != nil {
+		log.Printf("warning: failed to fetch database credentials: %v", refreshErr)
+	}
+	retry, current := c.get()
+	if current == generation {
+		return nil, err
+	}
+	conn, err = retry.Connect(ctx)
+	if err == nil {
+		c.succeeded()
+	}
+	return conn, err
+}
+
+// Driver returns the postgres driver
+func (c *rotatingConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// poll fetches the DSN at the interval until ctx is done
+func (c *rotatingConnector) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		refreshCtx, cancel := context.WithTimeout(ctx, rotationRefreshTimeout)
+		if _, err := c.refresh(refreshCtx, false); err != nil {
+			log.Printf("warning: failed to fetch database credentials: %v", err)
+		}
+		cancel()
+	}
+}
+
+// refresh fetches the DSN and swaps it when it changed, reporting whether it did
+// refreshes caused by failed logins are skipped when the DSN was fetched less than 10 seconds ago
+func (c *rotatingConnector) refresh(ctx context.Context, limited bool) (bool, error) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if limited && time.Since(c.lastRefresh) < minRotationRefreshInterval {
+		return false, nil
+	}
+	c.lastRefresh = time.Now()
+
+	dsn, err := c.dsn(ctx)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	changed := dsn != c.current
+	c.mu.Unlock()
+	if !changed {
+		return false, nil
+	}
+	if err := c.swap(dsn); err != nil {
+		return false, err
+	}
+	credentialRotations.Add(1)
+	log.Printf("database credentials rotated, new connections use the new credentials")
+	return true, nil
+}
+
+// swap replaces the DSN used for new connections
+func (c *rotatingConnector) swap(dsn string) error {
+	connector, err := c.open(dsn)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = dsn
+	c.connector = connector
+	c.generation++
+	c.failures = 0
+	return nil
+}
+
+// returns the connector of the current DSN and its generation
+func (c *rotatingConnector) get() (driver.Connector, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connector, c.generation
+}
+
+// resets the failed login counter
+func (c *rotatingConnector) succeeded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+}
+
+// counts a failed login and reports whether the threshold is reached
+func (c *rotatingConnector) failed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	return c.failures >= c.threshold
+}
+
+// isAuthError reports whether postgres rejected the login
+func isAuthError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == invalidPassword || pqErr.Code == invalidAuthorizationSpec
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"database/sql"
+	"expvar"
+	"sync/atomic"
+)
+
+// connection pool whose statistics are served by expvar at /admin/vars as db_stats
+var statsDB atomic.Pointer[sql.DB]
+
+func init() {
+	expvar.Publish("db_stats", expvar.Func(func() any {
+		db := statsDB.Load()
+		if db == nil {
+			return nil
+		}
+		return db.Stats()
+	}))
+}
+
+// PublishStats makes expvar serve the statistics of the connection pool
+func PublishStats(db *sql.DB) {
+	statsDB.Store(db)
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// assumed role credentials are refreshed this long before they expire
+const assumeRoleExpiryWindow = 5 * time.Minute
+
+// ErrAssumeRole is returned when the role of aws.assume_role_arn can not be assumed
+// errors without it come from reading the secret itself
+var ErrAssumeRole = errors.New("cannot assume role")
+
+// WithAssumeRole makes the AWS clients assume the role before reading secrets, e.g. a role of a central security account
+// the external id is passed to STS when it is not empty
+func WithAssumeRole(roleARN string, externalID string) Option {
+	return func(o *options) {
+		o.assumeRoleARN = roleARN
+		o.externalID = externalID
+	}
+}
+
+// WithSTSAPI makes the AWS clients assume the role with the given STS API instead of creating one
+func WithSTSAPI(api stscreds.AssumeRoleAPIClient) Option {
+	return func(o *options) {
+		o.stsAPI = api
+	}
+}
+
+// assumeRoleCredentials returns cached credentials of the assumed role, refreshed before they expire
+// the role is assumed with the credentials of cfg
+func assumeRoleCredentials(cfg aws.Config, o options) aws.CredentialsProvider {
+	api := o.stsAPI
+	if api == nil {
+		api = sts.NewFromConfig(cfg, func(so *sts.Options) {
+			if o.endpoint != "" {
+				so.BaseEndpoint = aws.String(o.endpoint)
+			}
+			if o.httpClient != nil {
+				so.HTTPClient = o.httpClient
+			}
+		})
+	}
+	provider := stscreds.NewAssumeRoleProvider(api, o.assumeRoleARN, func(ao *stscreds.AssumeRoleOptions) {
+		if o.externalID != "" {
+			ao.ExternalID = aws.String(o.externalID)
+		}
+	})
+	return aws.NewCredentialsCache(assumeRoleProvider{provider: provider, roleARN: o.assumeRoleARN}, func(co *aws.CredentialsCacheOptions) {
+		co.ExpiryWindow = assumeRoleExpiryWindow
+	})
+}
+
+// assumeRoleProvider marks the errors of assuming the role with ErrAssumeRole
+type assumeRoleProvider struct {
+	provider aws.CredentialsProvider
+	roleARN  string
+}
+
+// Retrieve assumes the role
+func (p assumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("%w %s: %w", ErrAssumeRole, p.roleARN, err)
+	}
+	return creds, nil
+}
@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats holds the hit and miss counters of the secrets cache
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// cached secret value
+type cacheEntry struct {
+	value   string
+	fetched time.Time
+}
+
+// in-flight fetch shared by concurrent callers of the same secret
+type fetchCall struct {
+	wg    sync.WaitGroup
+	value string
+	err   error
+}
+
+// secretCache keeps fetched secrets for ttl and makes concurrent fetches of the same secret share one call
+type secretCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	stale   bool
+	now     func() time.Time
+	entries map[string]cacheEntry
+	calls   map[string]*fetchCall
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newSecretCache(ttl time.Duration, stale bool) *secretCache {
+	return &secretCache{
+		ttl:     ttl,
+		stale:   stale,
+		now:     time.Now,
+		entries: make(map[string]cacheEntry),
+		calls:   make(map[string]*fetchCall),
+	}
+}
+
+// get returns the cached secret if it is fresh, otherwise fetches it
+// if the fetch fails and serving stale values is enabled, the expired value is returned with a warning
+func (c *secretCache) get(ctx context.Context, name string, fetch func(context.Context, string) (string, error)) (string, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[name]; ok && c.now().Sub(entry.fetched) < c.ttl {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return entry.value, nil
+	}
+	c.misses.Add(1)
+	if call, ok := c.calls[name]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &fetchCall{}
+	call.wg.Add(1)
+	c.calls[name] = call
+	c.mu.Unlock()
+
+	value, err := fetch(ctx, name)
+
+	if err == nil {
+		c.store(name, value)
+	} else if stale, ok := c.staleValue(name, err); ok {
+		value, err = stale, nil
+	}
+	c.mu.Lock()
+	delete(c.calls, name)
+	c.mu.Unlock()
+
+	call.value, call.err = value, err
+	call.wg.Done()
+	return value, err
+}
+
+// lookup returns the cached secret if it is fresh and counts the hit or miss
+func (c *secretCache) lookup(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[name]; ok && c.now().Sub(entry.fetched) < c.ttl {
+		c.hits.Add(1)
+		return entry.value, true
+	}
+	c.misses.Add(1)
+	return "", false
+}
+
+// store caches a fetched secret
+func (c *secretCache) store(name string, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = cacheEntry{value: value, fetched: c.now()}
+}
+
+// staleValue returns the expired value of the secret with a warning if serving stale values is enabled
+func (c *secretCache) staleValue(name string, err error) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || !c.stale {
+		return "", false
+	}
+	log.Printf("warning: serving stale value of secret %s, refresh failed: %v", name, err)
+	return entry.value, true
+}
+
+// invalidate drops the cached value of the secret
+func (c *secretCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// stats returns the hit and miss counters
+func (c *secretCache) stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DatabaseCredentials is the credential set RDS rotation writes into a secret
+// fields missing from the secret are left empty
+type DatabaseCredentials struct {
+	Username string
+	Password string
+	Host     string
+	Port     int
+	DBName   string
+}
+
+// GetDatabaseCredentials retrieves the database credentials stored in a secret by its name/ARN
+// a plain string secret is read as the password only
+func (c *Client) GetDatabaseCredentials(ctx context.Context, secretName string) (DatabaseCredentials, error) {
+	raw, err := c.getSecretRaw(ctx, secretName)
+	if err != nil {
+		return DatabaseCredentials{}, fmt.Errorf("failed to get secret %s: %w", secretName, err)
+	}
+
+	creds, err := parseDatabaseCredentials(raw)
+	if err != nil {
+		return DatabaseCredentials{}, fmt.Errorf("failed to read secret %s: %w", secretName, err)
+	}
+	return creds, nil
+}
+
+// parseDatabaseCredentials reads the fields of a JSON object secret, the port may be a number or a string
+func parseDatabaseCredentials(raw string) (DatabaseCredentials, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		// not a JSON object, so the whole secret is the password
+		return DatabaseCredentials{Password: raw}, nil
+	}
+
+	values, err := secretFields(raw)
+	if err != nil {
+		return DatabaseCredentials{}, err
+	}
+	creds := DatabaseCredentials{
+		Username: values["username"],
+		Password: values["password"],
+		Host:     values["host"],
+		DBName:   values["dbname"],
+	}
+	if port := values["port"]; port != "" {
+		if creds.Port, err = strconv.Atoi(port); err != nil {
+			return DatabaseCredentials{}, fmt.Errorf("port is not a number: %s", port)
+		}
+	}
+	return creds, nil
+}
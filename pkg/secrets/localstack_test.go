@@ -0,0 +1,101 @@
+//go:build integration
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/ory/dockertest/v3"
+)
+
+// runs both backends against LocalStack, run with go test -tags integration ./pkg/secrets
+func TestLocalStack(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Could not construct pool: %s", err)
+	}
+	resource, err := pool.Run("localstack/localstack", "3", []string{"SERVICES=secretsmanager,ssm"})
+	if err != nil {
+		t.Fatalf("Could not start resource: %s", err)
+	}
+	defer pool.Purge(resource)
+
+	endpoint := fmt.Sprintf("http://localhost:%s", resource.GetPort("4566/tcp"))
+	cfg := aws.Config{
+		Region: "eu-north-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	}
+	secretsManagerAPI := secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	ssmAPI := ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	ctx := context.Background()
+	if err := pool.Retry(func() error {
+		_, err := secretsManagerAPI.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String("berliner/jwt_secret"),
+			SecretString: aws.String(`{"secret":"randomJWTSecret"}`),
+		})
+		return err
+	}); err != nil {
+		t.Fatalf("Could not create secret: %s", err)
+	}
+	if _, err := ssmAPI.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:  aws.String("/berliner/jwt_secret"),
+		Value: aws.String(`{"secret":"randomJWTSecret"}`),
+		Type:  types.ParameterTypeSecureString,
+	}); err != nil {
+		t.Fatalf("Could not create parameter: %s", err)
+	}
+
+	// the public constructors load the AWS config, which reads these credentials
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	secretsManagerClient, err := NewClient("eu-north-1", WithEndpoint(endpoint))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	ssmClient, err := NewSSMClient("eu-north-1", WithEndpoint(endpoint))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+
+	testTable := []struct {
+		name   string
+		client Provider
+		secret string
+	}{
+		{
+			name:   "secrets manager",
+			client: secretsManagerClient,
+			secret: "berliner/jwt_secret",
+		},
+		{
+			name:   "ssm",
+			client: ssmClient,
+			secret: "/berliner/jwt_secret",
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := testCase.client.GetSecretField(ctx, testCase.secret, "secret")
+			if ans != "randomJWTSecret" {
+				t.Errorf("Expected randomJWTSecret, got %v, error: %v", ans, err)
+			}
+			values, err := testCase.client.GetSecretStrings(ctx, []string{testCase.secret})
+			if values[testCase.secret] != `{"secret":"randomJWTSecret"}` {
+				t.Errorf("Expected the raw secret, got %v, error: %v", values, err)
+			}
+		})
+	}
+}
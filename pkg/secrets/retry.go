@@ -0,0 +1,186 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// RetryPolicy controls how failed backend calls are retried
+type RetryPolicy struct {
+	// total number of attempts including the first one, 1 disables retries
+	MaxAttempts int
+	// wait before the first retry, doubled after every retry
+	BaseDelay time.Duration
+	// longest wait between two attempts
+	MaxDelay time.Duration
+	// time limit of a single attempt, zero means only the deadline of the passed context applies
+	AttemptTimeout time.Duration
+}
+
+// DefaultRetryPolicy is used when no retry policy is configured
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	BaseDelay:      200 * time.Millisecond,
+	MaxDelay:       5 * time.Second,
+	AttemptTimeout: 5 * time.Second,
+}
+
+// WithRetryPolicy sets how failed backend calls are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// RetryError is returned when every attempt of a retried call failed
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// error codes of AWS APIs which are worth retrying
+var retryableCodes = map[string]bool{
+	"ThrottlingException":      true,
+	"Throttling":               true,
+	"TooManyRequestsException": true,
+	"RequestLimitExceeded":     true,
+	"InternalServiceError":     true,
+	"InternalFailure":          true,
+	"InternalServerError":      true,
+	"ServiceUnavailable":       true,
+}
+
+// retryable reports whether the error is a throttling, server or timeout error
+// not found and access denied errors are never retried
+func retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && retryableCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		status := statusErr.HTTPStatusCode()
+		return status == http.StatusTooManyRequests || status >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryBackend retries the calls of another backend with exponential backoff and jitter
+type retryBackend struct {
+	backend backend
+	policy  RetryPolicy
+	// returns the actual wait for the backoff delay, full jitter by default
+	jitter func(time.Duration) time.Duration
+	// waits for the delay or until ctx is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// newRetryBackend wraps the backend with the retry policy
+func newRetryBackend(b backend, policy RetryPolicy) *retryBackend {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &retryBackend{
+		backend: b,
+		policy:  policy,
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(d) + 1))
+		},
+		sleep: func(ctx context.Context, d time.Duration) error {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return nil
+			}
+		},
+	}
+}
+
+func (r *retryBackend) fetch(ctx context.Context, name string) (string, error) {
+	var value string
+	err := r.do(ctx, name, func(ctx context.Context) error {
+		var err error
+		value, err = r.backend.fetch(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+func (r *retryBackend) fetchMany(ctx context.Context, names []string) (map[string]string, error) {
+	var values map[string]string
+	err := r.do(ctx, fmt.Sprint(names), func(ctx context.Context) error {
+		var err error
+		values, err = r.backend.fetchMany(ctx, names)
+		return err
+	})
+	return values, err
+}
+
+// Close closes the wrapped backend
+func (r *retryBackend) Close() error {
+	if closer, ok := r.backend.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// do runs call until it succeeds, fails with an error which is not retryable or the attempts run out
+func (r *retryBackend) do(ctx context.Context, name string, call func(ctx context.Context) error) error {
+	delay := r.policy.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := r.attempt(ctx, call)
+		if err == nil {
+			return nil
+		}
+		// the deadline of the passed context is the overall deadline
+		if ctx.Err() != nil || !retryable(err) {
+			if attempt == 1 {
+				return err
+			}
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		if attempt == r.policy.MaxAttempts {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+
+		wait := r.jitter(min(delay, r.policy.MaxDelay))
+		slog.Warn("secrets backend call failed, retrying", "secret", name, "attempt", attempt, "delay", wait, "error", err)
+		if err := r.sleep(ctx, wait); err != nil {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		delay *= 2
+	}
+}
+
+// attempt runs a single call with the attempt timeout
+func (r *retryBackend) attempt(ctx context.Context, call func(ctx context.Context) error) error {
+	if r.policy.AttemptTimeout <= 0 {
+		return call(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.policy.AttemptTimeout)
+	defer cancel()
+	return call(ctx)
+}
@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// fake backend which fails with the scripted errors in order and then succeeds
+type scriptedBackend struct {
+	errs  []error
+	calls int
+}
+
+func (s *scriptedBackend) fetch(ctx context.Context, name string) (string, error) {
+	s.calls++
+	if s.calls <= len(s.errs) {
+		return "", s.errs[s.calls-1]
+	}
+	return "secret", nil
+}
+
+func (s *scriptedBackend) fetchMany(ctx context.Context, names []string) (map[string]string, error) {
+	value, err := s.fetch(ctx, names[0])
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{names[0]: value}, nil
+}
+
+// returns a retry backend which records its waits instead of sleeping
+func newTestRetryBackend(b backend, policy RetryPolicy, waits *[]time.Duration) *retryBackend {
+	r := newRetryBackend(b, policy)
+	r.jitter = func(d time.Duration) time.Duration { return d }
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return ctx.Err()
+	}
+	return r
+}
+
+func TestRetryErrorClasses(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+
+	testTable := []struct {
+		name     string
+		errs     []error
+		calls    int
+		attempts int
+		isError  bool
+	}{
+		{
+			name:  "throttled then success",
+			errs:  []error{throttled, throttled},
+			calls: 3,
+		},
+		{
+			name:  "server error",
+			errs:  []error{&vaultStatusError{path: "secret/data/berliner", status: 503}},
+			calls: 2,
+		},
+		{
+			name:  "timeout",
+			errs:  []error{context.DeadlineExceeded},
+			calls: 2,
+		},
+		{
+			name:    "not found",
+			errs:    []error{&smithy.GenericAPIError{Code: "ResourceNotFoundException"}},
+			calls:   1,
+			isError: true,
+		},
+		{
+			name:    "access denied",
+			errs:    []error{&smithy.GenericAPIError{Code: "AccessDeniedException"}},
+			calls:   1,
+			isError: true,
+		},
+		{
+			name:    "client error",
+			errs:    []error{&vaultStatusError{path: "secret/data/berliner", status: 403}},
+			calls:   1,
+			isError: true,
+		},
+		{
+			name:     "exhausted",
+			errs:     []error{throttled, throttled, throttled},
+			calls:    3,
+			attempts: 3,
+			isError:  true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var waits []time.Duration
+			b := &scriptedBackend{errs: testCase.errs}
+			ans, err := newTestRetryBackend(b, policy, &waits).fetch(context.Background(), "berliner/jwt_secret")
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if !testCase.isError && ans != "secret" {
+				t.Errorf("Expected secret, got %v", ans)
+			}
+			if b.calls != testCase.calls {
+				t.Errorf("Expected %v calls, got %v", testCase.calls, b.calls)
+			}
+			var retryErr *RetryError
+			if testCase.attempts > 0 && (!errors.As(err, &retryErr) || retryErr.Attempts != testCase.attempts) {
+				t.Errorf("Expected error after %v attempts, got %v", testCase.attempts, err)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+	var waits []time.Duration
+	b := &scriptedBackend{errs: []error{throttled, throttled, throttled, throttled, throttled}}
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 350 * time.Millisecond}
+
+	_, err := newTestRetryBackend(b, policy, &waits).fetch(context.Background(), "berliner/jwt_secret")
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 5 {
+		t.Errorf("Expected error after 5 attempts, got %v", err)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond}
+	if !reflect.DeepEqual(waits, expected) {
+		t.Errorf("Expected waits %v, got %v", expected, waits)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+	var waits []time.Duration
+	b := &scriptedBackend{errs: []error{throttled, throttled, throttled}}
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newTestRetryBackend(b, policy, &waits).fetch(ctx, "berliner/jwt_secret"); err == nil {
+		t.Fatalf("Expected error when the context is done")
+	}
+	if b.calls != 1 {
+		t.Errorf("Expected 1 call after the deadline, got %v", b.calls)
+	}
+}
@@ -2,80 +2,309 @@ package secrets
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 )
 
-// Client wraps AWS Secrets Manager client
+// default time a fetched secret is kept in the cache
+const DefaultCacheTTL = 5 * time.Minute
+
+// secret storage backends which can be selected in the config
+const (
+	BackendSecretsManager = "secretsmanager"
+	BackendSSM            = "ssm"
+)
+
+// ErrUnknownBackend is returned when the secrets backend is neither secretsmanager nor ssm
+var ErrUnknownBackend = errors.New("secrets backend must be secretsmanager or ssm")
+
+// Provider reads secrets from a secret storage backend
+type Provider interface {
+	GetSecret(ctx context.Context, secretName string) (string, error)
+	GetSecretString(ctx context.Context, secretName string) (string, error)
+	GetSecretStrings(ctx context.Context, secretNames []string) (map[string]string, error)
+	GetSecretField(ctx context.Context, secretName string, key string) (string, error)
+	GetSecretJSON(ctx context.Context, secretName string) (map[string]string, error)
+	GetDatabaseCredentials(ctx context.Context, secretName string) (DatabaseCredentials, error)
+	Close() error
+}
+
+// backend fetches raw secret values from a secret storage service
+type backend interface {
+	fetch(ctx context.Context, name string) (string, error)
+	fetchMany(ctx context.Context, names []string) (map[string]string, error)
+}
+
+// Client reads secrets from a backend through the cache
 type Client struct {
-	svc    *secretsmanager.Client
-	region string
+	backend backend
+	region  string
+	cache   *secretCache
+}
+
+// options of the Client
+type options struct {
+	cacheTTL   time.Duration
+	serveStale bool
+	retry      RetryPolicy
+
+	// used by the AWS backends
+	endpoint          string
+	httpClient        aws.HTTPClient
+	secretsManagerAPI SecretsManagerAPI
+	ssmAPI            SSMAPI
+	// role assumed before reading secrets, if set
+	assumeRoleARN string
+	externalID    string
+	stsAPI        stscreds.AssumeRoleAPIClient
+}
+
+// Option configures the Client
+type Option func(*options)
+
+// WithCacheTTL sets how long fetched secrets are cached, zero disables caching
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithStaleOnError serves the expired cached value with a warning when refreshing a secret fails
+func WithStaleOnError() Option {
+	return func(o *options) {
+		o.serveStale = true
+	}
+}
+
+// WithEndpoint sends AWS requests to the given url instead of the regional endpoint, e.g. to LocalStack
+func WithEndpoint(url string) Option {
+	return func(o *options) {
+		o.endpoint = url
+	}
 }
 
-type SecretUserPass struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+// WithHTTPClient sets the HTTP client of the AWS SDK
+func WithHTTPClient(client aws.HTTPClient) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithAPI makes NewClient use the given Secrets Manager API instead of creating one, the AWS config is not loaded
+func WithAPI(api SecretsManagerAPI) Option {
+	return func(o *options) {
+		o.secretsManagerAPI = api
+	}
 }
 
-// NewClient creates a new AWS Secrets Manager client
-func NewClient(region string) (*Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+// WithSSMAPI makes NewSSMClient use the given SSM API instead of creating one, the AWS config is not loaded
+func WithSSMAPI(api SSMAPI) Option {
+	return func(o *options) {
+		o.ssmAPI = api
+	}
+}
+
+// NewProvider creates a client for the given backend, an empty backend means Secrets Manager
+func NewProvider(backendName string, region string, opts ...Option) (Provider, error) {
+	switch backendName {
+	case "", BackendSecretsManager:
+		return NewClient(region, opts...)
+	case BackendSSM:
+		return NewSSMClient(region, opts...)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, backendName)
+}
+
+// loadAWSConfig loads the default AWS config for the region, with the credentials of the assumed role if one is set
+// retries of the SDK are disabled, the retry policy of the Client applies instead
+func loadAWSConfig(region string, o options) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region), config.WithRetryMaxAttempts(1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		return cfg, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if o.assumeRoleARN != "" {
+		cfg.Credentials = assumeRoleCredentials(cfg, o)
 	}
+	return cfg, nil
+}
 
+// buildOptions applies the options over the defaults
+func buildOptions(opts []Option) options {
+	o := options{cacheTTL: DefaultCacheTTL, retry: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// newClient creates a Client on top of the given backend, calls to the backend are retried with the retry policy
+func newClient(b backend, opts ...Option) *Client {
+	o := buildOptions(opts)
 	return &Client{
-		svc:    secretsmanager.NewFromConfig(cfg),
-		region: region,
-	}, nil
+		backend: newRetryBackend(b, o.retry),
+		cache:   newSecretCache(o.cacheTTL, o.serveStale),
+	}
+}
+
+// Close stops the background work of the backend, like renewing the Vault token
+func (c *Client) Close() error {
+	if closer, ok := c.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Invalidate drops the cached value of the secret so the next call fetches it again
+func (c *Client) Invalidate(secretName string) {
+	c.cache.invalidate(secretName)
 }
 
+// CacheStats returns the cache hit and miss counters
+func (c *Client) CacheStats() CacheStats {
+	return c.cache.stats()
+}
+
+// ErrFieldNotFound is returned when a JSON secret does not contain the requested field
+var ErrFieldNotFound = errors.New("secret field not found")
+
 // GetSecret retrieves a secret value by its name/ARN
+// A plain string secret is returned verbatim, a JSON secret must contain a "password" field which is returned
 func (c *Client) GetSecret(ctx context.Context, secretName string) (string, error) {
-	var sup SecretUserPass
+	raw, err := c.getSecretRaw(ctx, secretName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", secretName, err)
+	}
+
+	value, err := parseSecret(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", secretName, err)
+	}
+	return value, nil
+}
 
+// GetSecretString retrieves a secret value by its name/ARN exactly as it is stored
+func (c *Client) GetSecretString(ctx context.Context, secretName string) (string, error) {
 	raw, err := c.getSecretRaw(ctx, secretName)
 	if err != nil {
 		return "", fmt.Errorf("failed to get secret %s: %w", secretName, err)
 	}
+	return raw, nil
+}
 
-	if err := json.Unmarshal([]byte(raw), &sup); err != nil {
-		// JSON parsed successfully. Use provided fields.
-		return "", fmt.Errorf("error unmarshaling: %w", err)
+// GetSecretStrings retrieves several secrets by their names/ARNs exactly as they are stored
+// secrets which are not cached are fetched together in as few calls as the backend allows
+func (c *Client) GetSecretStrings(ctx context.Context, secretNames []string) (map[string]string, error) {
+	values := make(map[string]string, len(secretNames))
+	var missing []string
+	for _, name := range secretNames {
+		if value, ok := c.cache.lookup(name); ok {
+			values[name] = value
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
 	}
 
-	return sup.Password, nil
+	fetched, err := c.backend.fetchMany(ctx, missing)
+	if err != nil {
+		for _, name := range missing {
+			value, ok := c.cache.staleValue(name, err)
+			if !ok {
+				return nil, fmt.Errorf("failed to get secrets: %w", err)
+			}
+			values[name] = value
+		}
+		return values, nil
+	}
+	for name, value := range fetched {
+		c.cache.store(name, value)
+		values[name] = value
+	}
+	return values, nil
 }
 
-func (c *Client) getSecretRaw(ctx context.Context, secretName string) (string, error) {
-	in := &secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(secretName),
+// GetSecretField retrieves a single field of a JSON secret by its name/ARN
+func (c *Client) GetSecretField(ctx context.Context, secretName string, key string) (string, error) {
+	raw, err := c.getSecretRaw(ctx, secretName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", secretName, err)
 	}
 
-	out, err := c.svc.GetSecretValue(ctx, in)
+	value, err := secretField(raw, key)
 	if err != nil {
-		return "", fmt.Errorf("GetSecretValue error: %w", err)
+		return "", fmt.Errorf("failed to read secret %s: %w", secretName, err)
 	}
+	return value, nil
+}
 
-	// Prefer SecretString
-	if out.SecretString != nil {
-		return aws.ToString(out.SecretString), nil
+// GetSecretJSON retrieves all fields of a JSON secret by its name/ARN
+// non-string values are returned as their JSON text
+func (c *Client) GetSecretJSON(ctx context.Context, secretName string) (map[string]string, error) {
+	raw, err := c.getSecretRaw(ctx, secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", secretName, err)
 	}
 
-	// Fallback to SecretBinary (base64-encoded)
-	if out.SecretBinary != nil {
-		decoded, err := base64.StdEncoding.DecodeString(string(out.SecretBinary))
-		if err != nil {
-			return "", fmt.Errorf("failed to decode secret binary: %w", err)
+	fields, err := secretFields(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", secretName, err)
+	}
+	return fields, nil
+}
+
+// parseSecret returns a plain string secret verbatim and the "password" field of a JSON object secret
+func parseSecret(raw string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		// not a JSON object, so the whole secret is the value
+		return raw, nil
+	}
+	return secretField(raw, "password")
+}
+
+// secretField returns the field of a JSON object secret, non-string values are returned as their JSON text
+func secretField(raw string, key string) (string, error) {
+	fields, err := secretFields(raw)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrFieldNotFound, key)
+	}
+	return value, nil
+}
+
+// secretFields returns all fields of a JSON object secret, non-string values are returned as their JSON text
+func secretFields(raw string) (map[string]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+
+	values := make(map[string]string, len(fields))
+	for key, value := range fields {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			values[key] = s
+		} else {
+			values[key] = string(value)
 		}
-		return string(decoded), nil
 	}
+	return values, nil
+}
 
-	return "", errors.New("secret contains no SecretString or SecretBinary")
+// getSecretRaw returns the secret from the cache or from the backend
+func (c *Client) getSecretRaw(ctx context.Context, secretName string) (string, error) {
+	return c.cache.get(ctx, secretName, c.backend.fetch)
 }
@@ -0,0 +1,514 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// fake Secrets Manager API which counts calls
+type fakeSecretsManager struct {
+	calls  atomic.Int64
+	delay  time.Duration
+	value  string
+	err    error
+	binary []byte
+	// respond with neither SecretString nor SecretBinary
+	empty bool
+}
+
+func (f *fakeSecretsManager) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls.Add(1)
+	time.Sleep(f.delay)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.empty {
+		return &secretsmanager.GetSecretValueOutput{}, nil
+	}
+	if f.binary != nil {
+		return &secretsmanager.GetSecretValueOutput{SecretBinary: f.binary}, nil
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(f.value)}, nil
+}
+
+func TestParseSecret(t *testing.T) {
+	testTable := []struct {
+		name     string
+		raw      string
+		expected string
+		err      error
+	}{
+		{
+			name:     "plain string",
+			raw:      "randomJWTSecret",
+			expected: "randomJWTSecret",
+		},
+		{
+			name:     "json with password",
+			raw:      `{"username":"postgres","password":"secret"}`,
+			expected: "secret",
+		},
+		{
+			name: "json without password",
+			raw:  `{"username":"postgres"}`,
+			err:  ErrFieldNotFound,
+		},
+		{
+			name:     "invalid json looking string",
+			raw:      "{not json",
+			expected: "{not json",
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := parseSecret(testCase.raw)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, got %v", testCase.err, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestParseDatabaseCredentials(t *testing.T) {
+	testTable := []struct {
+		name     string
+		raw      string
+		expected DatabaseCredentials
+		isError  bool
+	}{
+		{
+			name:     "full secret",
+			raw:      `{"engine":"postgres","username":"berliner","password":"secret","host":"db.example.com","port":5432,"dbname":"berliner"}`,
+			expected: DatabaseCredentials{Username: "berliner", Password: "secret", Host: "db.example.com", Port: 5432, DBName: "berliner"},
+		},
+		{
+			name:     "partial secret",
+			raw:      `{"username":"berliner","password":"secret","port":"6432"}`,
+			expected: DatabaseCredentials{Username: "berliner", Password: "secret", Port: 6432},
+		},
+		{
+			name:     "password only json",
+			raw:      `{"password":"secret"}`,
+			expected: DatabaseCredentials{Password: "secret"},
+		},
+		{
+			name:     "plain string",
+			raw:      "secret",
+			expected: DatabaseCredentials{Password: "secret"},
+		},
+		{
+			name:    "invalid port",
+			raw:     `{"password":"secret","port":"postgres"}`,
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := parseDatabaseCredentials(testCase.raw)
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestSecretField(t *testing.T) {
+	testTable := []struct {
+		name     string
+		raw      string
+		key      string
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "string field",
+			raw:      `{"username":"postgres","password":"secret"}`,
+			key:      "username",
+			expected: "postgres",
+		},
+		{
+			name:     "number field",
+			raw:      `{"port":5432}`,
+			key:      "port",
+			expected: "5432",
+		},
+		{
+			name:    "missing field",
+			raw:     `{"username":"postgres"}`,
+			key:     "password",
+			isError: true,
+		},
+		{
+			name:    "plain string",
+			raw:     "randomJWTSecret",
+			key:     "password",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := secretField(testCase.raw, testCase.key)
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestGetSecretJSON(t *testing.T) {
+	testTable := []struct {
+		name     string
+		value    string
+		expected map[string]string
+		isError  bool
+	}{
+		{
+			name:     "rds secret",
+			value:    `{"username":"postgres","password":"secret","host":"db.local","port":5432}`,
+			expected: map[string]string{"username": "postgres", "password": "secret", "host": "db.local", "port": "5432"},
+		},
+		{
+			name:    "plain string",
+			value:   "randomJWTSecret",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newClient(secretsManagerBackend{api: &fakeSecretsManager{value: testCase.value}})
+			ans, err := client.GetSecretJSON(context.Background(), "berliner/db")
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if !reflect.DeepEqual(ans, testCase.expected) {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	api := &fakeSecretsManager{value: "secret", delay: 50 * time.Millisecond}
+	client := newClient(secretsManagerBackend{api: api})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ans, err := client.GetSecret(context.Background(), "berliner/jwt_secret"); ans != "secret" {
+				t.Errorf("Expected secret, got %v, error: %v", ans, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := api.calls.Load(); calls != 1 {
+		t.Errorf("Expected 1 call, got %v", calls)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2030, time.January, 10, 12, 0, 0, 0, time.UTC)
+	api := &fakeSecretsManager{value: "secret"}
+	client := newClient(secretsManagerBackend{api: api}, WithCacheTTL(time.Minute))
+	client.cache.now = func() time.Time { return now }
+
+	testTable := []struct {
+		name     string
+		advance  time.Duration
+		expected int64
+		stats    CacheStats
+	}{
+		{
+			name:     "first call",
+			expected: 1,
+			stats:    CacheStats{Hits: 0, Misses: 1},
+		},
+		{
+			name:     "cached",
+			advance:  30 * time.Second,
+			expected: 1,
+			stats:    CacheStats{Hits: 1, Misses: 1},
+		},
+		{
+			name:     "expired",
+			advance:  31 * time.Second,
+			expected: 2,
+			stats:    CacheStats{Hits: 1, Misses: 2},
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			now = now.Add(testCase.advance)
+			client.GetSecret(context.Background(), "berliner/jwt_secret")
+			if calls := api.calls.Load(); calls != testCase.expected {
+				t.Errorf("Expected %v calls, got %v", testCase.expected, calls)
+			}
+			if stats := client.CacheStats(); stats != testCase.stats {
+				t.Errorf("Expected %v, got %v", testCase.stats, stats)
+			}
+		})
+	}
+
+	client.Invalidate("berliner/jwt_secret")
+	client.GetSecret(context.Background(), "berliner/jwt_secret")
+	if calls := api.calls.Load(); calls != 3 {
+		t.Errorf("Expected invalidated secret to be fetched again, got %v calls", calls)
+	}
+}
+
+func TestCacheStaleOnError(t *testing.T) {
+	testTable := []struct {
+		name     string
+		opts     []Option
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "serve stale",
+			opts:     []Option{WithCacheTTL(time.Minute), WithStaleOnError()},
+			expected: "secret",
+		},
+		{
+			name:    "fail",
+			opts:    []Option{WithCacheTTL(time.Minute)},
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			now := time.Date(2030, time.January, 10, 12, 0, 0, 0, time.UTC)
+			api := &fakeSecretsManager{value: "secret"}
+			client := newClient(secretsManagerBackend{api: api}, testCase.opts...)
+			client.cache.now = func() time.Time { return now }
+			client.GetSecret(context.Background(), "berliner/jwt_secret")
+
+			now = now.Add(2 * time.Minute)
+			api.err = errors.New("throttled")
+			ans, err := client.GetSecret(context.Background(), "berliner/jwt_secret")
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestNewClientWithAPI(t *testing.T) {
+	testTable := []struct {
+		name     string
+		api      *fakeSecretsManager
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "secret string",
+			api:      &fakeSecretsManager{value: "randomJWTSecret"},
+			expected: "randomJWTSecret",
+		},
+		{
+			name:     "secret binary",
+			api:      &fakeSecretsManager{binary: []byte(base64.StdEncoding.EncodeToString([]byte("randomJWTSecret")))},
+			expected: "randomJWTSecret",
+		},
+		{
+			name:    "invalid base64",
+			api:     &fakeSecretsManager{binary: []byte("not base64!")},
+			isError: true,
+		},
+		{
+			name:    "neither string nor binary",
+			api:     &fakeSecretsManager{empty: true},
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, err := NewClient("eu-north-1", WithAPI(testCase.api))
+			if err != nil {
+				t.Fatalf("Could not create client: %s", err)
+			}
+			ans, err := client.GetSecretString(context.Background(), "berliner/jwt_secret")
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestNewClientWithEndpoint(t *testing.T) {
+	var target string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"Name":"berliner/jwt_secret","SecretString":"randomJWTSecret"}`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	client, err := NewClient("eu-north-1", WithEndpoint(server.URL), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	ans, err := client.GetSecret(context.Background(), "berliner/jwt_secret")
+	if ans != "randomJWTSecret" {
+		t.Errorf("Expected randomJWTSecret, got %v, error: %v", ans, err)
+	}
+	if target != "secretsmanager.GetSecretValue" {
+		t.Errorf("Expected a GetSecretValue request, got %v", target)
+	}
+}
+
+// fake STS API which counts the assumed roles
+type fakeSTS struct {
+	calls      atomic.Int64
+	expiresIn  time.Duration
+	err        error
+	externalID string
+}
+
+func (f *fakeSTS) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	n := f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.externalID = aws.ToString(params.ExternalId)
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String(fmt.Sprintf("ASSUMED%d", n)),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("session"),
+		Expiration:      aws.Time(time.Now().Add(f.expiresIn)),
+	}}, nil
+}
+
+func TestAssumeRole(t *testing.T) {
+	testTable := []struct {
+		name      string
+		expiresIn time.Duration
+		stsErr    error
+		status    int
+		calls     int64
+		keyId     string
+		roleError bool
+	}{
+		{
+			name:      "assumed once",
+			expiresIn: time.Hour,
+			status:    http.StatusOK,
+			calls:     1,
+			keyId:     "ASSUMED1",
+		},
+		{
+			name:      "refreshed near expiry",
+			expiresIn: time.Minute,
+			status:    http.StatusOK,
+			calls:     3,
+			keyId:     "ASSUMED3",
+		},
+		{
+			name:      "cannot assume role",
+			stsErr:    errors.New("AccessDenied"),
+			status:    http.StatusOK,
+			roleError: true,
+		},
+		{
+			name:      "cannot read secret",
+			expiresIn: time.Hour,
+			status:    http.StatusBadRequest,
+			keyId:     "ASSUMED1",
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			var authorization atomic.Value
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization.Store(r.Header.Get("Authorization"))
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				w.WriteHeader(testCase.status)
+				if testCase.status != http.StatusOK {
+					fmt.Fprint(w, `{"__type":"ResourceNotFoundException","message":"not found"}`)
+					return
+				}
+				fmt.Fprint(w, `{"Name":"berliner/jwt_secret","SecretString":"randomJWTSecret"}`)
+			}))
+			defer server.Close()
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+			fake := &fakeSTS{expiresIn: testCase.expiresIn, err: testCase.stsErr}
+			client, err := NewClient("eu-north-1",
+				WithEndpoint(server.URL),
+				WithHTTPClient(server.Client()),
+				WithAssumeRole("arn:aws:iam::123456789012:role/berliner-secrets", "berliner"),
+				WithSTSAPI(fake),
+				WithCacheTTL(0),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+			)
+			if err != nil {
+				t.Fatalf("Could not create client: %s", err)
+			}
+			for i := 0; i < 3; i++ {
+				_, err = client.GetSecret(context.Background(), "berliner/jwt_secret")
+				if testCase.stsErr != nil || testCase.status != http.StatusOK {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Could not get secret: %s", err)
+				}
+			}
+
+			if errors.Is(err, ErrAssumeRole) != testCase.roleError {
+				t.Errorf("Expected role error %v, got %v", testCase.roleError, err)
+			}
+			if testCase.status != http.StatusOK && err == nil {
+				t.Errorf("Expected the secret to be unreadable")
+			}
+			if testCase.calls != 0 && fake.calls.Load() != testCase.calls {
+				t.Errorf("Expected the role to be assumed %v times, got %v", testCase.calls, fake.calls.Load())
+			}
+			if testCase.keyId == "" {
+				return
+			}
+			if fake.externalID != "berliner" {
+				t.Errorf("Expected external id berliner, got %v", fake.externalID)
+			}
+			if header, _ := authorization.Load().(string); !strings.Contains(header, "Credential="+testCase.keyId+"/") {
+				t.Errorf("Expected request signed with %v, got %v", testCase.keyId, header)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretsManagerAPI is the part of the AWS Secrets Manager API used by Client
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// NewClient creates a new AWS Secrets Manager client
+func NewClient(region string, opts ...Option) (*Client, error) {
+	o := buildOptions(opts)
+	api := o.secretsManagerAPI
+	if api == nil {
+		cfg, err := loadAWSConfig(region, o)
+		if err != nil {
+			return nil, err
+		}
+		api = secretsmanager.NewFromConfig(cfg, func(so *secretsmanager.Options) {
+			if o.endpoint != "" {
+				so.BaseEndpoint = aws.String(o.endpoint)
+			}
+			if o.httpClient != nil {
+				so.HTTPClient = o.httpClient
+			}
+		})
+	}
+
+	client := newClient(secretsManagerBackend{api: api}, opts...)
+	client.region = region
+	return client, nil
+}
+
+// secretsManagerBackend reads secrets from AWS Secrets Manager
+type secretsManagerBackend struct {
+	api SecretsManagerAPI
+}
+
+// fetch calls Secrets Manager for the secret
+func (b secretsManagerBackend) fetch(ctx context.Context, secretName string) (string, error) {
+	in := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretName),
+	}
+
+	out, err := b.api.GetSecretValue(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("GetSecretValue error: %w", err)
+	}
+
+	// Prefer SecretString
+	if out.SecretString != nil {
+		return aws.ToString(out.SecretString), nil
+	}
+
+	// Fallback to SecretBinary (base64-encoded)
+	if out.SecretBinary != nil {
+		decoded, err := base64.StdEncoding.DecodeString(string(out.SecretBinary))
+		if err != nil {
+			return "", fmt.Errorf("failed to decode secret binary: %w", err)
+		}
+		return string(decoded), nil
+	}
+
+	return "", errors.New("secret contains no SecretString or SecretBinary")
+}
+
+// fetchMany calls Secrets Manager once per secret
+func (b secretsManagerBackend) fetchMany(ctx context.Context, secretNames []string) (map[string]string, error) {
+	values := make(map[string]string, len(secretNames))
+	for _, name := range secretNames {
+		value, err := b.fetch(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
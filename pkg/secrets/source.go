@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Source reads single secrets by name, it is implemented by every secrets backend
+type Source interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Get returns the secret exactly as it is stored, so the Client is a Source
+func (c *Client) Get(ctx context.Context, name string) (string, error) {
+	return c.GetSecretString(ctx, name)
+}
+
+// EnvSource reads secrets from environment variables and a .env file
+// a <name>_FILE variable names a file holding the secret, as docker and kubernetes mount secrets
+type EnvSource struct {
+	dotenv    map[string]string
+	dotenvErr error
+}
+
+// NewEnvSource returns a source of the environment which falls back to the .env file at dotenvPath
+// a missing .env file is only an error when a secret is not set in the environment
+func NewEnvSource(dotenvPath string) *EnvSource {
+	dotenv, err := godotenv.Read(dotenvPath)
+	return &EnvSource{dotenv: dotenv, dotenvErr: err}
+}
+
+// Get returns the secret from the file named by <name>_FILE, the environment variable or the .env file, in that order
+func (e *EnvSource) Get(ctx context.Context, name string) (string, error) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+		}
+		return secret, nil
+	}
+	if secret := os.Getenv(name); secret != "" {
+		return secret, nil
+	}
+	if e.dotenvErr != nil {
+		return "", fmt.Errorf("failed to load .env file: %w", e.dotenvErr)
+	}
+	if secret := e.dotenv[name]; secret != "" {
+		return secret, nil
+	}
+	return "", fmt.Errorf("%w: %s is not set in .env file", ErrSecretNotFound, name)
+}
+
+// FileSource reads secrets from the files of a directory, e.g. /run/secrets where docker mounts them
+type FileSource struct {
+	dir string
+}
+
+// NewFileSource returns a source of the files in dir
+func NewFileSource(dir string) *FileSource {
+	return &FileSource{dir: dir}
+}
+
+// Get returns the content of the file called name, names can not leave the directory
+func (f *FileSource) Get(ctx context.Context, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == ".." {
+		return "", fmt.Errorf("invalid secret file name: %q", name)
+	}
+	secret, err := readSecretFile(filepath.Join(f.dir, name))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, filepath.Join(f.dir, name))
+	}
+	return secret, err
+}
+
+// readSecretFile returns the content of the file without trailing newlines, empty files are an error
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(content), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
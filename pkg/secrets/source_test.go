@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"jwt_secret": "fileJWTSecret\n", "empty": "\n", ".env": "JWT_SECRET=dotenvJWTSecret\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Could not write secret file: %s", err)
+		}
+	}
+	t.Setenv("DB_PASSWORD", "envSecret")
+	t.Setenv("DB_PASSWORD_FILE", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_SECRET_FILE", "")
+	t.Setenv("MOUNTED_SECRET_FILE", filepath.Join(dir, "jwt_secret"))
+	client, err := NewClient("eu-north-1", WithAPI(&fakeSecretsManager{value: "awsSecret"}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+
+	testTable := []struct {
+		name     string
+		source   Source
+		secret   string
+		expected string
+		err      error
+		isError  bool
+	}{
+		{
+			name:     "aws",
+			source:   client,
+			secret:   "berliner/db",
+			expected: "awsSecret",
+		},
+		{
+			name:     "environment",
+			source:   NewEnvSource(filepath.Join(dir, ".env")),
+			secret:   "DB_PASSWORD",
+			expected: "envSecret",
+		},
+		{
+			name:     "environment file variable",
+			source:   NewEnvSource(filepath.Join(dir, ".env")),
+			secret:   "MOUNTED_SECRET",
+			expected: "fileJWTSecret",
+		},
+		{
+			name:     ".env file",
+			source:   NewEnvSource(filepath.Join(dir, ".env")),
+			secret:   "JWT_SECRET",
+			expected: "dotenvJWTSecret",
+		},
+		{
+			name:   "not in the environment",
+			source: NewEnvSource(filepath.Join(dir, ".env")),
+			secret: "MISSING_SECRET",
+			err:    ErrSecretNotFound,
+		},
+		{
+			name:    "missing .env file",
+			source:  NewEnvSource(filepath.Join(dir, "missing.env")),
+			secret:  "JWT_SECRET",
+			isError: true,
+		},
+		{
+			name:     "file",
+			source:   NewFileSource(dir),
+			secret:   "jwt_secret",
+			expected: "fileJWTSecret",
+		},
+		{
+			name:   "missing file",
+			source: NewFileSource(dir),
+			secret: "db_password",
+			err:    ErrSecretNotFound,
+		},
+		{
+			name:    "empty file",
+			source:  NewFileSource(dir),
+			secret:  "empty",
+			isError: true,
+		},
+		{
+			name:    "file outside the directory",
+			source:  NewFileSource(filepath.Join(dir, "sub")),
+			secret:  "../jwt_secret",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := testCase.source.Get(context.Background(), testCase.secret)
+			if testCase.err != nil && !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, got %v", testCase.err, err)
+			}
+			if (err != nil) != (testCase.isError || testCase.err != nil) {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// maximum number of parameters GetParameters accepts in a single call
+const maxParametersPerCall = 10
+
+// ErrParameterNotFound is returned when SSM does not know a requested parameter
+var ErrParameterNotFound = errors.New("parameter not found")
+
+// SSMAPI is the part of the AWS SSM Parameter Store API used by Client
+type SSMAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	GetParameters(ctx context.Context, params *ssm.GetParametersInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersOutput, error)
+}
+
+// NewSSMClient creates a new client reading SecureString parameters from AWS SSM Parameter Store
+func NewSSMClient(region string, opts ...Option) (*Client, error) {
+	o := buildOptions(opts)
+	api := o.ssmAPI
+	if api == nil {
+		cfg, err := loadAWSConfig(region, o)
+		if err != nil {
+			return nil, err
+		}
+		api = ssm.NewFromConfig(cfg, func(so *ssm.Options) {
+			if o.endpoint != "" {
+				so.BaseEndpoint = aws.String(o.endpoint)
+			}
+			if o.httpClient != nil {
+				so.HTTPClient = o.httpClient
+			}
+		})
+	}
+
+	client := newClient(ssmBackend{api: api}, opts...)
+	client.region = region
+	return client, nil
+}
+
+// ssmBackend reads decrypted parameters from SSM Parameter Store
+type ssmBackend struct {
+	api SSMAPI
+}
+
+// fetch calls GetParameter for the parameter
+func (b ssmBackend) fetch(ctx context.Context, name string) (string, error) {
+	out, err := b.api.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("GetParameter error: %w", err)
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", errors.New("parameter contains no value")
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
+
+// fetchMany calls GetParameters with up to 10 names at a time
+func (b ssmBackend) fetchMany(ctx context.Context, names []string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	for start := 0; start < len(names); start += maxParametersPerCall {
+		batch := names[start:min(start+maxParametersPerCall, len(names))]
+		out, err := b.api.GetParameters(ctx, &ssm.GetParametersInput{
+			Names:          batch,
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("GetParameters error: %w", err)
+		}
+		if len(out.InvalidParameters) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrParameterNotFound, strings.Join(out.InvalidParameters, ", "))
+		}
+
+		requested := make(map[string]bool, len(batch))
+		for _, name := range batch {
+			requested[name] = true
+		}
+		for _, parameter := range out.Parameters {
+			// parameters requested by ARN are keyed by the ARN they were requested with
+			name := aws.ToString(parameter.Name)
+			if arn := aws.ToString(parameter.ARN); !requested[name] && requested[arn] {
+				name = arn
+			}
+			values[name] = aws.ToString(parameter.Value)
+		}
+	}
+	return values, nil
+}
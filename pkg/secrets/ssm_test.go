@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fake SSM API with fixed parameters which counts calls
+type fakeSSM struct {
+	parameters map[string]string
+	calls      atomic.Int64
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	f.calls.Add(1)
+	if !aws.ToBool(params.WithDecryption) {
+		return nil, errors.New("parameter requested without decryption")
+	}
+	value, ok := f.parameters[aws.ToString(params.Name)]
+	if !ok {
+		return nil, &types.ParameterNotFound{}
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Name: params.Name, Value: aws.String(value)}}, nil
+}
+
+func (f *fakeSSM) GetParameters(ctx context.Context, params *ssm.GetParametersInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersOutput, error) {
+	f.calls.Add(1)
+	if !aws.ToBool(params.WithDecryption) {
+		return nil, errors.New("parameters requested without decryption")
+	}
+	if len(params.Names) > maxParametersPerCall {
+		return nil, errors.New("too many parameters")
+	}
+	out := &ssm.GetParametersOutput{}
+	for _, name := range params.Names {
+		if value, ok := f.parameters[name]; ok {
+			out.Parameters = append(out.Parameters, types.Parameter{Name: aws.String(name), Value: aws.String(value)})
+		} else {
+			out.InvalidParameters = append(out.InvalidParameters, name)
+		}
+	}
+	return out, nil
+}
+
+func TestSSMGetSecret(t *testing.T) {
+	api := &fakeSSM{parameters: map[string]string{
+		"/berliner/jwt_secret":  "randomJWTSecret",
+		"/berliner/db_password": `{"username":"postgres","password":"secret"}`,
+	}}
+	client := newClient(ssmBackend{api: api})
+
+	testTable := []struct {
+		name     string
+		get      func() (string, error)
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "plain string",
+			get:      func() (string, error) { return client.GetSecret(context.Background(), "/berliner/jwt_secret") },
+			expected: "randomJWTSecret",
+		},
+		{
+			name: "json field",
+			get: func() (string, error) {
+				return client.GetSecretField(context.Background(), "/berliner/db_password", "username")
+			},
+			expected: "postgres",
+		},
+		{
+			name:    "missing",
+			get:     func() (string, error) { return client.GetSecret(context.Background(), "/berliner/missing") },
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := testCase.get()
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestSSMGetSecretStrings(t *testing.T) {
+	parameters := make(map[string]string)
+	var names []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		parameters["/berliner/"+name] = name
+		names = append(names, "/berliner/"+name)
+	}
+	api := &fakeSSM{parameters: parameters}
+	client := newClient(ssmBackend{api: api})
+
+	ans, err := client.GetSecretStrings(context.Background(), names)
+	if err != nil {
+		t.Fatalf("Could not get parameters: %s", err)
+	}
+	if !reflect.DeepEqual(ans, parameters) {
+		t.Errorf("Expected %v, got %v", parameters, ans)
+	}
+	if calls := api.calls.Load(); calls != 2 {
+		t.Errorf("Expected 12 parameters in 2 calls, got %v calls", calls)
+	}
+
+	// cached parameters are not requested again
+	if _, err := client.GetSecret(context.Background(), "/berliner/a"); err != nil {
+		t.Fatalf("Could not get parameter: %s", err)
+	}
+	if calls := api.calls.Load(); calls != 2 {
+		t.Errorf("Expected cached parameter, got %v calls", calls)
+	}
+
+	if _, err := client.GetSecretStrings(context.Background(), []string{"/berliner/a", "/berliner/missing"}); !errors.Is(err, ErrParameterNotFound) {
+		t.Errorf("Expected %v, got %v", ErrParameterNotFound, err)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	if _, err := NewProvider("vault", "eu-north-1"); !errors.Is(err, ErrUnknownBackend) {
+		t.Errorf("Expected %v, got %v", ErrUnknownBackend, err)
+	}
+}
@@ -0,0 +1,371 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault auth methods which can be selected in the config
+const (
+	VaultAuthToken      = "token"
+	VaultAuthKubernetes = "kubernetes"
+	VaultAuthAppRole    = "approle"
+)
+
+// defaults of the Vault config
+const (
+	defaultVaultMount          = "secret"
+	defaultKubernetesMount     = "kubernetes"
+	defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultAppRoleMount        = "approle"
+	defaultVaultRequestTimeout = 10 * time.Second
+	minVaultRenewInterval      = 5 * time.Second
+	vaultRenewRetryInterval    = 30 * time.Second
+)
+
+// ErrUnknownVaultAuth is returned when the Vault auth method is not token, kubernetes or approle
+var ErrUnknownVaultAuth = errors.New("vault auth method must be token, kubernetes or approle")
+
+// ErrVaultTokenExpired is returned when Vault denies a read because the token itself is no longer valid,
+// rather than because its policies do not allow the read
+var ErrVaultTokenExpired = errors.New("vault token is expired or revoked")
+
+// ErrSecretNotFound is returned when Vault, the environment or a secrets directory has no secret of the requested name
+var ErrSecretNotFound = errors.New("secret not found")
+
+// vaultStatusError is returned when Vault responds with an error status
+type vaultStatusError struct {
+	path    string
+	status  int
+	message string
+}
+
+func (e *vaultStatusError) Error() string {
+	return fmt.Sprintf("vault %s: status %d: %s", e.path, e.status, e.message)
+}
+
+// HTTPStatusCode lets the retry policy tell server errors from client errors
+func (e *vaultStatusError) HTTPStatusCode() int {
+	return e.status
+}
+
+// VaultConfig holds the address and auth settings of a Vault server
+type VaultConfig struct {
+	Address string
+	// KV v2 mount, defaults to secret
+	Mount string
+	// token, kubernetes or approle
+	AuthMethod string
+	// used by token auth
+	Token string
+	// used by kubernetes auth
+	KubernetesRole      string
+	KubernetesMount     string
+	KubernetesTokenPath string
+	// used by approle auth
+	AppRoleRoleId   string
+	AppRoleSecretId string
+	AppRoleMount    string
+	// defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+}
+
+// NewVaultClient logs in to Vault and creates a client reading KV v2 secrets
+// the token is renewed in the background until Close is called
+func NewVaultClient(ctx context.Context, cfg VaultConfig, opts ...Option) (*Client, error) {
+	b, lease, err := newVaultBackend(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.startRenewal(lease)
+	return newClient(b, opts...), nil
+}
+
+// vaultBackend reads secrets from the KV v2 engine of Vault
+type vaultBackend struct {
+	cfg  VaultConfig
+	http *http.Client
+
+	mu    sync.RWMutex
+	token string
+
+	// shortest wait between two renewals
+	minRenew time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// vault API response, only the used fields are decoded
+type vaultResponse struct {
+	Data json.RawMessage `json:"data"`
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// newVaultBackend applies the config defaults, logs in and returns the lease duration of the token
+func newVaultBackend(ctx context.Context, cfg VaultConfig) (*vaultBackend, time.Duration, error) {
+	if cfg.Address == "" {
+		return nil, 0, errors.New("vault address is not set")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = defaultVaultMount
+	}
+	if cfg.AuthMethod == "" {
+		cfg.AuthMethod = VaultAuthToken
+	}
+	if cfg.KubernetesMount == "" {
+		cfg.KubernetesMount = defaultKubernetesMount
+	}
+	if cfg.KubernetesTokenPath == "" {
+		cfg.KubernetesTokenPath = defaultKubernetesTokenPath
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = defaultAppRoleMount
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultVaultRequestTimeout}
+	}
+
+	b := &vaultBackend{
+		cfg:      cfg,
+		http:     httpClient,
+		minRenew: minVaultRenewInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	lease, err := b.login(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, lease, nil
+}
+
+// login gets a token with the configured auth method and returns its lease duration, zero if it never expires
+func (b *vaultBackend) login(ctx context.Context) (time.Duration, error) {
+	switch b.cfg.AuthMethod {
+	case VaultAuthToken:
+		if b.cfg.Token == "" {
+			return 0, errors.New("vault token is not set")
+		}
+		b.setToken(b.cfg.Token)
+		var data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		}
+		resp, err := b.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+		if err != nil {
+			return 0, err
+		}
+		if err := json.Unmarshal(resp.Data, &data); err != nil {
+			return 0, fmt.Errorf("vault auth/token/lookup-self: %w", err)
+		}
+		if !data.Renewable {
+			return 0, nil
+		}
+		return time.Duration(data.TTL) * time.Second, nil
+	case VaultAuthKubernetes:
+		jwt, err := os.ReadFile(b.cfg.KubernetesTokenPath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read kubernetes service account token: %w", err)
+		}
+		return b.loginWith(ctx, "auth/"+b.cfg.KubernetesMount+"/login", map[string]string{
+			"role": b.cfg.KubernetesRole,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		})
+	case VaultAuthAppRole:
+		if b.cfg.AppRoleRoleId == "" || b.cfg.AppRoleSecretId == "" {
+			return 0, errors.New("vault approle role id or secret id is not set")
+		}
+		return b.loginWith(ctx, "auth/"+b.cfg.AppRoleMount+"/login", map[string]string{
+			"role_id":   b.cfg.AppRoleRoleId,
+			"secret_id": b.cfg.AppRoleSecretId,
+		})
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownVaultAuth, b.cfg.AuthMethod)
+}
+
+// loginWith posts the credentials to the login path of an auth method, keeps the token it returns
+// and returns its lease duration, zero if it is not renewable
+func (b *vaultBackend) loginWith(ctx context.Context, path string, credentials map[string]string) (time.Duration, error) {
+	resp, err := b.do(ctx, http.MethodPost, path, credentials)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return 0, fmt.Errorf("vault %s: response has no token", path)
+	}
+	b.setToken(resp.Auth.ClientToken)
+	if !resp.Auth.Renewable {
+		return 0, nil
+	}
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// renew extends the lease of the current token and returns the new lease duration
+func (b *vaultBackend) renew(ctx context.Context) (time.Duration, error) {
+	resp, err := b.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]string{})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Auth == nil {
+		return 0, errors.New("vault auth/token/renew-self: response has no lease")
+	}
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// startRenewal renews the token at two thirds of its lease until Close is called
+// when renewing fails the backend logs in again, which gets a new token with kubernetes and approle auth
+func (b *vaultBackend) startRenewal(lease time.Duration) {
+	go func() {
+		defer close(b.done)
+		ctx := context.Background()
+		for lease > 0 {
+			interval := max(lease*2/3, b.minRenew)
+			select {
+			case <-b.stop:
+				return
+			case <-time.After(interval):
+			}
+
+			var err error
+			if lease, err = b.renew(ctx); err == nil {
+				continue
+			}
+			log.Printf("warning: vault token renewal failed: %v", err)
+			if lease, err = b.login(ctx); err != nil {
+				log.Printf("warning: vault login failed: %v", err)
+				lease = vaultRenewRetryInterval
+			}
+		}
+	}()
+}
+
+// Close stops the token renewal
+func (b *vaultBackend) Close() error {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+	return nil
+}
+
+// fetch reads the secret at <mount>/data/<name> and returns its data map as a JSON object
+func (b *vaultBackend) fetch(ctx context.Context, name string) (string, error) {
+	path := b.cfg.Mount + "/data/" + strings.TrimPrefix(name, "/")
+	resp, err := b.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", b.checkToken(ctx, err)
+	}
+	var data struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil || len(data.Data) == 0 || string(data.Data) == "null" {
+		return "", fmt.Errorf("vault %s: %w", path, ErrSecretNotFound)
+	}
+	return string(data.Data), nil
+}
+
+// checkToken tells a read denied because the token is expired or revoked from one its policies do not allow,
+// Vault answers both with 403 but only an invalid token can not look itself up
+func (b *vaultBackend) checkToken(ctx context.Context, err error) error {
+	var denied *vaultStatusError
+	if !errors.As(err, &denied) || denied.status != http.StatusForbidden {
+		return err
+	}
+	var lookupErr *vaultStatusError
+	if _, lookup := b.do(ctx, http.MethodGet, "auth/token/lookup-self", nil); errors.As(lookup, &lookupErr) && lookupErr.status == http.StatusForbidden {
+		return fmt.Errorf("%w: %w", ErrVaultTokenExpired, err)
+	}
+	return err
+}
+
+// fetchMany reads the secrets one by one, Vault has no batch read
+func (b *vaultBackend) fetchMany(ctx context.Context, names []string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := b.fetch(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// do sends a request to the Vault API, errors name the request path but never the token
+func (b *vaultBackend) do(ctx context.Context, method string, path string, body interface{}) (vaultResponse, error) {
+	var resp vaultResponse
+	u, err := url.JoinPath(b.cfg.Address, "v1", path)
+	if err != nil {
+		return resp, fmt.Errorf("vault %s: %w", path, err)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return resp, fmt.Errorf("vault %s: %w", path, err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return resp, fmt.Errorf("vault %s: %w", path, err)
+	}
+	if token := b.getToken(); token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := b.http.Do(req)
+	if err != nil {
+		// the url error repeats the address and path, never headers
+		return resp, fmt.Errorf("vault %s: %w", path, err)
+	}
+	defer res.Body.Close()
+
+	decodeErr := json.NewDecoder(res.Body).Decode(&resp)
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return resp, fmt.Errorf("vault %s: %w", path, ErrSecretNotFound)
+	case res.StatusCode >= 300:
+		return resp, &vaultStatusError{path: path, status: res.StatusCode, message: strings.Join(resp.Errors, "; ")}
+	case decodeErr != nil && !errors.Is(decodeErr, io.EOF):
+		return resp, fmt.Errorf("vault %s: %w", path, decodeErr)
+	}
+	return resp, nil
+}
+
+// returns the current Vault token
+func (b *vaultBackend) getToken() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.token
+}
+
+// replaces the Vault token after logging in
+func (b *vaultBackend) setToken(token string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.token = token
+}
@@ -0,0 +1,56 @@
+//go:build integration
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ory/dockertest/v3"
+)
+
+// runs the Vault backend against the Vault dev server, run with go test -tags integration ./pkg/secrets
+func TestVaultDevServer(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Could not construct pool: %s", err)
+	}
+	resource, err := pool.Run("hashicorp/vault", "1.15", []string{"VAULT_DEV_ROOT_TOKEN_ID=root"})
+	if err != nil {
+		t.Fatalf("Could not start resource: %s", err)
+	}
+	defer pool.Purge(resource)
+
+	address := fmt.Sprintf("http://localhost:%s", resource.GetPort("8200/tcp"))
+	if err := pool.Retry(func() error {
+		req, _ := http.NewRequest(http.MethodPost, address+"/v1/secret/data/berliner/jwt", bytes.NewBufferString(`{"data":{"secret":"randomJWTSecret"}}`))
+		req.Header.Set("X-Vault-Token", "root")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("status %d", res.StatusCode)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Could not write secret: %s", err)
+	}
+
+	client, err := NewVaultClient(context.Background(), VaultConfig{Address: address, Token: "root"})
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	defer client.Close()
+
+	if ans, err := client.GetSecretField(context.Background(), "berliner/jwt", "secret"); ans != "randomJWTSecret" {
+		t.Errorf("Expected randomJWTSecret, got %v, error: %v", ans, err)
+	}
+	if _, err := client.GetSecret(context.Background(), "berliner/missing"); err == nil {
+		t.Errorf("Expected error for a missing secret")
+	}
+}
@@ -0,0 +1,285 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fake Vault server with a single token, a kubernetes and an approle login and KV v2 secrets under the secret mount
+// a revoked token is denied everything, a token without policies can only look itself up
+type fakeVault struct {
+	token    string
+	secrets  map[string]map[string]interface{}
+	renewals atomic.Int64
+	revoked  atomic.Bool
+	// denies the secret reads of a valid token
+	noPolicy bool
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/kubernetes/login" {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role"] != "berliner" || body["jwt"] != "service-account-jwt" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": f.token, "lease_duration": 1, "renewable": true}})
+		return
+	}
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role_id"] != "berliner-role" || body["secret_id"] != "berliner-secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": f.token, "lease_duration": 0, "renewable": false}})
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != f.token || f.revoked.Load() || (f.noPolicy && r.URL.Path != "/v1/auth/token/lookup-self") {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/auth/token/lookup-self":
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"ttl": 0, "renewable": false}})
+	case r.URL.Path == "/v1/auth/token/renew-self":
+		f.renewals.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": f.token, "lease_duration": 1, "renewable": true}})
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		data, ok := f.secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 1}}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultTokenAuth(t *testing.T) {
+	vault := &fakeVault{token: "vault-root-token", secrets: map[string]map[string]interface{}{
+		"berliner/db":  {"username": "postgres", "password": "secret", "port": 5432},
+		"berliner/jwt": {"secret": "randomJWTSecret"},
+	}}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	client, err := NewVaultClient(context.Background(), VaultConfig{Address: server.URL, Token: vault.token})
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	defer client.Close()
+
+	fields, err := client.GetSecretJSON(context.Background(), "berliner/db")
+	expected := map[string]string{"username": "postgres", "password": "secret", "port": "5432"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v, error: %v", expected, fields, err)
+	}
+
+	testTable := []struct {
+		name     string
+		secret   string
+		field    string
+		expected string
+		err      error
+	}{
+		{
+			name:     "named field",
+			secret:   "berliner/jwt",
+			field:    "secret",
+			expected: "randomJWTSecret",
+		},
+		{
+			name:   "missing field",
+			secret: "berliner/jwt",
+			field:  "password",
+			err:    ErrFieldNotFound,
+		},
+		{
+			name:   "missing secret",
+			secret: "berliner/missing",
+			field:  "password",
+			err:    ErrSecretNotFound,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			ans, err := client.GetSecretField(context.Background(), testCase.secret, testCase.field)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Expected error %v, got %v", testCase.err, err)
+			}
+			if ans != testCase.expected {
+				t.Errorf("Expected %v, got %v", testCase.expected, ans)
+			}
+		})
+	}
+}
+
+func TestVaultErrorsHideToken(t *testing.T) {
+	vault := &fakeVault{token: "vault-root-token"}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	_, err := NewVaultClient(context.Background(), VaultConfig{Address: server.URL, Token: "wrong-secret-token"})
+	if err == nil {
+		t.Fatalf("Expected error for a wrong token")
+	}
+	if !strings.Contains(err.Error(), "auth/token/lookup-self") {
+		t.Errorf("Expected error to name the request path, got %v", err)
+	}
+	if strings.Contains(err.Error(), "wrong-secret-token") {
+		t.Errorf("Expected error not to contain the token, got %v", err)
+	}
+}
+
+func TestVaultKubernetesAuth(t *testing.T) {
+	vault := &fakeVault{token: "kubernetes-token", secrets: map[string]map[string]interface{}{
+		"berliner/jwt": {"secret": "randomJWTSecret"},
+	}}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("service-account-jwt\n"), 0600); err != nil {
+		t.Fatalf("Could not write token: %s", err)
+	}
+
+	b, lease, err := newVaultBackend(context.Background(), VaultConfig{
+		Address:             server.URL,
+		AuthMethod:          VaultAuthKubernetes,
+		KubernetesRole:      "berliner",
+		KubernetesTokenPath: tokenPath,
+	})
+	if err != nil {
+		t.Fatalf("Could not log in: %s", err)
+	}
+	if lease != time.Second {
+		t.Errorf("Expected lease of 1s, got %v", lease)
+	}
+	b.minRenew = 10 * time.Millisecond
+	b.startRenewal(lease)
+	client := newClient(b)
+
+	if ans, err := client.GetSecretField(context.Background(), "berliner/jwt", "secret"); ans != "randomJWTSecret" {
+		t.Errorf("Expected randomJWTSecret, got %v, error: %v", ans, err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for vault.renewals.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.Close()
+	if vault.renewals.Load() == 0 {
+		t.Errorf("Expected the token to be renewed")
+	}
+}
+
+func TestVaultAppRoleAuth(t *testing.T) {
+	vault := &fakeVault{token: "approle-token", secrets: map[string]map[string]interface{}{
+		"berliner/jwt": {"secret": "randomJWTSecret"},
+	}}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	testTable := []struct {
+		name     string
+		secretId string
+		isError  bool
+	}{
+		{
+			name:     "valid credentials",
+			secretId: "berliner-secret",
+		},
+		{
+			name:     "wrong secret id",
+			secretId: "wrong-secret",
+			isError:  true,
+		},
+		{
+			name:    "no secret id",
+			isError: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, err := NewVaultClient(context.Background(), VaultConfig{
+				Address:         server.URL,
+				AuthMethod:      VaultAuthAppRole,
+				AppRoleRoleId:   "berliner-role",
+				AppRoleSecretId: testCase.secretId,
+			})
+			if (err != nil) != testCase.isError {
+				t.Fatalf("Expected error %v, got %v", testCase.isError, err)
+			}
+			if err != nil {
+				return
+			}
+			defer client.Close()
+			var source Source = client
+			ans, err := source.Get(context.Background(), "berliner/jwt")
+			if ans != `{"secret":"randomJWTSecret"}` {
+				t.Errorf("Expected the secret, got %v, error: %v", ans, err)
+			}
+		})
+	}
+}
+
+func TestVaultExpiredToken(t *testing.T) {
+	testTable := []struct {
+		name     string
+		revoke   bool
+		noPolicy bool
+		expired  bool
+	}{
+		{
+			name:    "revoked token",
+			revoke:  true,
+			expired: true,
+		},
+		{
+			name:     "token without policy",
+			noPolicy: true,
+		},
+	}
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			vault := &fakeVault{token: "vault-root-token", secrets: map[string]map[string]interface{}{
+				"berliner/jwt": {"secret": "randomJWTSecret"},
+			}, noPolicy: testCase.noPolicy}
+			server := httptest.NewServer(vault)
+			defer server.Close()
+
+			client, err := NewVaultClient(context.Background(), VaultConfig{Address: server.URL, Token: vault.token})
+			if err != nil {
+				t.Fatalf("Could not create client: %s", err)
+			}
+			defer client.Close()
+			vault.revoked.Store(testCase.revoke)
+
+			_, err = client.GetSecretField(context.Background(), "berliner/jwt", "secret")
+			if err == nil {
+				t.Fatalf("Expected the read to be denied")
+			}
+			if errors.Is(err, ErrVaultTokenExpired) != testCase.expired {
+				t.Errorf("Expected expired token error %v, got %v", testCase.expired, err)
+			}
+		})
+	}
+}
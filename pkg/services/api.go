@@ -1,33 +1,331 @@
 package services
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
 	"github.com/I1Asyl/berliner_backend/pkg/repository"
 )
 
+// default and maximum number of hashtags returned for a user
+const (
+	defaultTopHashtags = 10
+	maxTopHashtags     = 50
+)
+
+// default and maximum number of posts returned by a search
+const (
+	defaultSearchPosts = 20
+	maxSearchPosts     = 100
+)
+
+// default and maximum number of users returned by a search
+const (
+	defaultSearchUsers = 20
+	maxSearchUsers     = 50
+)
+
+// default and maximum number of posts in a page of a feed
+const (
+	defaultFeedPosts = 20
+	maxFeedPosts     = 100
+)
+
+// default and maximum number of suggested channels
+const (
+	defaultSuggestedChannels = 10
+	maxSuggestedChannels     = 50
+)
+
+// default and maximum number of inactive channels returned at once
+const (
+	defaultInactiveChannels = 50
+	maxInactiveChannels     = 200
+)
+
+// default and maximum number of channels in a page of the public channels
+const (
+	defaultPublicChannels = 100
+	maxPublicChannels     = 500
+)
+
+// default and maximum number of join requests in a page of the requests inbox
+const (
+	defaultInboxRequests = 20
+	maxInboxRequests     = 100
+)
+
+// buckets of follower growth and the maximum number of buckets in a single range
+const (
+	BucketDay        = "day"
+	BucketWeek       = "week"
+	maxGrowthBuckets = 366
+)
+
+// maximum number of posts which can be deleted in a single request
+const maxBulkDeletePosts = 100
+
+// results of deleting a single post in a bulk deletion
+const (
+	PostDeleted   = "deleted"
+	PostForbidden = "forbidden"
+	PostNotFound  = "not_found"
+)
+
+// returned when the user has already created the maximum number of posts for the day
+var ErrDailyPostLimitReached = errors.New("daily post limit reached")
+
+// returned when the channel does not exist
+var ErrChannelNotFound = errors.New("channel not found")
+
+// returned when an action on a channel is reserved for its leader
+var ErrNotChannelLeader = errors.New("only the channel leader can do this")
+
+// returned when an action on a channel is reserved for its leader and editors
+var ErrNotChannelEditor = errors.New("only the channel leader and editors can do this")
+
+// returned when a user requests to join a channel which is not accepting members
+var ErrChannelClosed = errors.New("channel is not accepting new members")
+
+// returned when the user has no pending request to join the channel
+var ErrRequestNotFound = errors.New("join request not found")
+
+// returned when a user requests to join a channel again before the cooldown after a rejection ended
+type RequestCooldownError struct {
+	RetryAt time.Time
+}
+
+func (e *RequestCooldownError) Error() string {
+	return fmt.Sprintf("join request was rejected, try again after %s", e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// returned when a bulk deletion has no posts or too many posts
+var ErrBulkDeleteLimit = fmt.Errorf("between 1 and %d posts can be deleted at once", maxBulkDeletePosts)
+
+// returned when the bucket of follower growth or post frequency is neither day nor week
+var ErrInvalidBucket = errors.New("bucket must be day or week")
+
+// returned when the range of follower growth or post frequency is empty or has too many buckets
+var ErrInvalidGrowthRange = fmt.Errorf("from must be before to and the range can have at most %d buckets", maxGrowthBuckets)
+
+// returned when the user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// returned when a user tries to mute themselves
+var ErrMuteSelf = errors.New("you can not mute yourself")
+
+// returned when the inactivity period of channels is not positive
+var ErrInvalidInactivity = errors.New("inactivity period must be positive")
+
+// returned when the author type is neither user nor channel
+var ErrInvalidAuthorType = errors.New("author type must be user or channel")
+
 // api service struct
 type ApiService struct {
 	//database connection
 	repo repository.Repository
+	// current time source
+	clock Clock
+	// limits of posts, replaced when the config is reloaded
+	posts *atomic.Pointer[config.Posts]
+	// how channel descriptions are returned
+	channels config.Channels
 }
 
 // NewApiService returns a new ApiService instance
-func NewApiService(repo repository.Repository) *ApiService {
-	return &ApiService{repo: repo}
+func NewApiService(repo repository.Repository, clock Clock, posts config.Posts, channels config.Channels) *ApiService {
+	a := &ApiService{repo: repo, clock: clock, posts: &atomic.Pointer[config.Posts]{}, channels: channels}
+	a.posts.Store(&posts)
+	return a
+}
+
+// ApplyConfig applies the reloaded limits of posts
+func (a ApiService) ApplyConfig(cfg config.Config) {
+	a.posts.Store(&cfg.Posts)
 }
 
 // gets Channel model by its name in the transaction
 
+// returns the stored description of a channel as it is sent to clients,
+// rendered from markdown when markdown descriptions are enabled and escaped otherwise
+func (a ApiService) renderDescription(raw string) string {
+	if a.channels.MarkdownDescriptions {
+		return RenderDescription(raw)
+	}
+	return textEscaper.Replace(raw)
+}
+
+// renders the descriptions of the channels in place
+func (a ApiService) renderDescriptions(channels []models.Channel) []models.Channel {
+	for i := range channels {
+		channels[i].Description = a.renderDescription(channels[i].Description)
+	}
+	return channels
+}
+
+// renders the descriptions of the channels of the posts in place
+func (a ApiService) renderPostDescriptions(posts []struct {
+	models.Channel
+	models.ChannelPost
+}) []struct {
+	models.Channel
+	models.ChannelPost
+} {
+	for i := range posts {
+		posts[i].Description = a.renderDescription(posts[i].Description)
+	}
+	return posts
+}
+
 // gets Channel model by its name from the database
 func (a ApiService) GetChannelByName(name string) (models.Channel, error) {
 	var channel models.Channel
 	channel, err := a.repo.SqlQueries.GetChannelByName(name)
+	channel.Description = a.renderDescription(channel.Description)
 
 	return channel, err
 }
 
+// gets Channel model by its id from the database
+func (a ApiService) GetChannelById(id int) (models.Channel, error) {
+	channel, err := a.repo.SqlQueries.GetChannelById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return channel, ErrChannelNotFound
+	}
+	channel.Description = a.renderDescription(channel.Description)
+	return channel, err
+}
+
+// get the leader of the channel, false when the channel has no leader because the leader was deleted
+func (a ApiService) GetChannelLeader(channelId int) (models.User, bool, error) {
+	leader, err := a.repo.SqlQueries.GetChannelLeader(channelId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return leader, false, ErrChannelNotFound
+	}
+	if err != nil {
+		return leader, false, err
+	}
+	leader.Password = ""
+	return leader, leader.Id != 0, nil
+}
+
+// activate or deactivate a channel, inactive channels are hidden from discovery and can not be posted to
+func (a ApiService) SetChannelActive(channelId int, active bool, actor models.User) error {
+	channel, err := a.GetChannelById(channelId)
+	if err != nil {
+		return err
+	}
+	if channel.LeaderId != actor.Id {
+		return ErrNotChannelLeader
+	}
+	return a.repo.SqlQueries.SetChannelActive(channelId, active)
+}
+
+// get whether the user is a member, an editor or the leader of the channel and has a pending join request
+func (a ApiService) GetChannelRelationship(channelId int, user models.User) (models.ChannelRelationship, error) {
+	relationship, err := a.repo.SqlQueries.GetChannelRelationship(channelId, user.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return relationship, ErrChannelNotFound
+	}
+	return relationship, err
+}
+
+// open or close a channel to join requests
+func (a ApiService) SetAcceptingMembers(channelId int, accepting bool, actor models.User) error {
+	channel, err := a.GetChannelById(channelId)
+	if err != nil {
+		return err
+	}
+	if channel.LeaderId != actor.Id {
+		return ErrNotChannelLeader
+	}
+	return a.repo.SqlQueries.SetChannelAcceptingMembers(channelId, accepting)
+}
+
+// count the join requests of a channel which are not accepted yet, only for its leader and editors
+func (a ApiService) GetPendingRequestCount(channelId int, actor models.User) (int, error) {
+	relationship, err := a.GetChannelRelationship(channelId, actor)
+	if err != nil {
+		return 0, err
+	}
+	if !relationship.IsLeader && !relationship.IsEditor {
+		return 0, ErrNotChannelEditor
+	}
+	return a.repo.SqlQueries.CountPendingRequests(channelId)
+}
+
+// get a page of the pending requests to every channel the user leads, newest first
+func (a ApiService) GetAllPendingRequestsForLeader(userId int, limit, offset int) ([]models.RequestWithUser, error) {
+	if limit <= 0 {
+		limit = defaultInboxRequests
+	}
+	if limit > maxInboxRequests {
+		limit = maxInboxRequests
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.GetLeaderPendingRequests(userId, limit, offset)
+}
+
+// request to join a channel, the request waits for the leader to accept it
+func (a ApiService) RequestToJoin(channelId int, user models.User) error {
+	channel, err := a.GetChannelById(channelId)
+	if err != nil {
+		return err
+	}
+	if !channel.AcceptingMembers {
+		return ErrChannelClosed
+	}
+	rejectedAt, err := a.repo.SqlQueries.GetLastRejection(channelId, user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if retryAt := rejectedAt.Add(a.channels.RejoinCooldown); err == nil && a.clock.Now().Before(retryAt) {
+		return &RequestCooldownError{RetryAt: retryAt}
+	}
+	return a.repo.SqlQueries.AddRequest(models.Request{ChannelId: channelId, UserId: user.Id})
+}
+
+// reject the pending request of a user to join a channel, only for its leader and editors
+// the user can request again once channels.rejoin_cooldown has passed
+func (a ApiService) RejectJoinRequest(channelId int, userId int, actor models.User) error {
+	relationship, err := a.GetChannelRelationship(channelId, actor)
+	if err != nil {
+		return err
+	}
+	if !relationship.IsLeader && !relationship.IsEditor {
+		return ErrNotChannelEditor
+	}
+	rejected, err := a.repo.SqlQueries.RejectRequest(channelId, userId, a.clock.Now())
+	if err != nil {
+		return err
+	}
+	if !rejected {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
+// withdraw the pending request of the user to join a channel
+func (a ApiService) CancelJoinRequest(channelId int, user models.User) error {
+	deleted, err := a.repo.SqlQueries.DeletePendingRequest(channelId, user.Id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
 // gets User model by username from the database
 func (a ApiService) GetUserByUsername(username string) (models.User, error) {
 	var user models.User
@@ -36,13 +334,22 @@ func (a ApiService) GetUserByUsername(username string) (models.User, error) {
 	return user, err
 }
 
+// gets User model by id from the database, ErrUserNotFound with an empty user when there is none
+func (a ApiService) GetUserById(id int) (models.User, error) {
+	user, err := a.repo.SqlQueries.GetUserById(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
 // get all channels of the user from the database
 func (a ApiService) GetChannels(user models.User) ([]models.Channel, error) {
 
 	var channels []models.Channel
 	channels, err := a.repo.SqlQueries.GetUserChannels(user)
 
-	return channels, err
+	return a.renderDescriptions(channels), err
 }
 
 // create a new channel in the database for the given user
@@ -70,24 +377,52 @@ func (a ApiService) CreateChannel(channel models.Channel, user models.User) map[
 	return invalid
 }
 
+// check how many posts the user can still create today
+func (a ApiService) CheckDailyPostQuota(userId int) (int, error) {
+	now := a.clock.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	count, err := a.repo.SqlQueries.CountUserPostsBetween(userId, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, err
+	}
+	remaining := a.posts.Load().DailyLimit - count
+	if remaining <= 0 {
+		return 0, ErrDailyPostLimitReached
+	}
+	return remaining, nil
+}
+
 // create a new post in the database for the given user or channel
 func (a ApiService) CreatePost(post models.Post, authorId int) map[string]string {
-	post.CreatedAt = time.Now()
-	post.UpdatedAt = time.Now()
+	post.Content = SanitizeContent(post.Content)
+	post.CreatedAt = models.NewTimestamp(a.clock.Now())
+	post.UpdatedAt = post.CreatedAt
 	invalid := post.IsValid()
 	if len(invalid) == 0 {
 		if post.AuthorType == "user" {
-			post := models.UserPost{UserId: authorId, Post: post}
-			err := a.repo.SqlQueries.AddUserPost(post)
-			if err != nil {
+			if _, err := a.CheckDailyPostQuota(authorId); errors.Is(err, ErrDailyPostLimitReached) {
+				invalid["quota"] = err.Error()
+				return invalid
+			} else if err != nil {
+				invalid["error"] = err.Error()
+				return invalid
+			}
+			if err := a.repo.SqlQueries.AddPost(post, authorId); err != nil {
 				invalid["error"] = err.Error()
 			}
 
 		} else {
-			post := models.ChannelPost{ChannelId: authorId, Post: post}
-			err := a.repo.SqlQueries.AddChannelPost(post)
+			channel, err := a.GetChannelById(authorId)
 			if err != nil {
 				invalid["error"] = err.Error()
+				return invalid
+			}
+			if !channel.IsActive {
+				invalid["channel"] = "Channel is not active"
+				return invalid
+			}
+			if err := a.repo.SqlQueries.AddPost(post, authorId); err != nil {
+				invalid["error"] = err.Error()
 			}
 		}
 
@@ -95,6 +430,25 @@ func (a ApiService) CreatePost(post models.Post, authorId int) map[string]string
 	return invalid
 }
 
+// create a post of the actor, or of the channel when the post is of a channel
+// only the leader and the editors of the channel can post in it, others get a "common" key
+func (a ApiService) CreatePostAs(post models.Post, channelId int, actor models.User) map[string]string {
+	if post.AuthorType != "channel" {
+		return a.CreatePost(post, actor.Id)
+	}
+	relationship, err := a.GetChannelRelationship(channelId, actor)
+	if errors.Is(err, ErrChannelNotFound) {
+		return map[string]string{"channel": "Channel not found"}
+	}
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	if !relationship.IsLeader && !relationship.IsEditor {
+		return map[string]string{"common": "not authorized"}
+	}
+	return a.CreatePost(post, channelId)
+}
+
 func (a ApiService) DeletePost(post models.Post) error {
 	var err error
 
@@ -108,12 +462,129 @@ func (a ApiService) DeletePost(post models.Post) error {
 	return err
 }
 
+// delete many posts of the same author type in a single transaction
+// every post is checked separately, users can delete their own posts, editors can delete their channel's posts
+// and admins can delete every post
+func (a ApiService) DeletePosts(user models.User, authorType string, ids []int) (map[int]string, error) {
+	if len(ids) == 0 || len(ids) > maxBulkDeletePosts {
+		return nil, ErrBulkDeleteLimit
+	}
+	if authorType != "user" && authorType != "channel" {
+		return nil, ErrInvalidAuthorType
+	}
+	admin, err := isAdmin(a.repo, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int]string, len(ids))
+	for _, id := range ids {
+		result[id] = PostNotFound
+	}
+
+	tx := a.repo.SqlQueries.StartTransaction()
+	var allowed []int
+	if authorType == "user" {
+		posts, err := tx.GetUserPostsByIds(ids)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for _, post := range posts {
+			if admin || post.UserId == user.Id {
+				allowed = append(allowed, post.Id)
+			} else {
+				result[post.Id] = PostForbidden
+			}
+		}
+	} else {
+		posts, err := tx.GetChannelPostsByIds(ids)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		channelIds, err := tx.GetEditorChannelIds(user)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		editor := make(map[int]bool, len(channelIds))
+		for _, id := range channelIds {
+			editor[id] = true
+		}
+		for _, post := range posts {
+			if admin || editor[post.ChannelId] {
+				allowed = append(allowed, post.Id)
+			} else {
+				result[post.Id] = PostForbidden
+			}
+		}
+	}
+
+	if len(allowed) > 0 {
+		var err error
+		if authorType == "user" {
+			err = tx.DeleteUserPostsByIds(allowed)
+		} else {
+			err = tx.DeleteChannelPostsByIds(allowed)
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, id := range allowed {
+		result[id] = PostDeleted
+	}
+	return result, nil
+}
+
 func (a ApiService) GetPostsFromChannels(user models.User) ([]struct {
 	models.Channel
 	models.ChannelPost
 }, error) {
 	posts, err := a.repo.SqlQueries.GetChannelPosts(user)
-	return posts, err
+	return a.renderPostDescriptions(posts), err
+}
+
+// get a page of the posts of the channels the user is a member of, without posts of users
+func (a ApiService) GetChannelFeed(user models.User, limit, offset int) ([]struct {
+	models.Channel
+	models.ChannelPost
+}, error) {
+	if limit <= 0 {
+		limit = defaultFeedPosts
+	}
+	if limit > maxFeedPosts {
+		limit = maxFeedPosts
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	posts, err := a.repo.SqlQueries.GetChannelFeed(user.Id, limit, offset)
+	return a.renderPostDescriptions(posts), err
+}
+
+// get a page of the public posts of the users the user follows, without posts of channels
+func (a ApiService) GetPeopleFeed(user models.User, limit, offset int) ([]struct {
+	models.User
+	models.UserPost
+}, error) {
+	if limit <= 0 {
+		limit = defaultFeedPosts
+	}
+	if limit > maxFeedPosts {
+		limit = maxFeedPosts
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.GetPeopleFeed(user.Id, limit, offset)
 }
 
 func (a ApiService) GetPostsFromMyChannels(user models.User) ([]struct {
@@ -121,7 +592,7 @@ func (a ApiService) GetPostsFromMyChannels(user models.User) ([]struct {
 	models.ChannelPost
 }, error) {
 	posts, err := a.repo.SqlQueries.GetMyChannelPosts(user)
-	return posts, err
+	return a.renderPostDescriptions(posts), err
 }
 
 func (a ApiService) GetNewPostsFromChannels(user models.User) ([]struct {
@@ -129,7 +600,7 @@ func (a ApiService) GetNewPostsFromChannels(user models.User) ([]struct {
 	models.ChannelPost
 }, error) {
 	posts, err := a.repo.SqlQueries.GetNewChannelPosts(user)
-	return posts, err
+	return a.renderPostDescriptions(posts), err
 }
 
 func (a ApiService) FollowChannel(user models.User, name string) error {
@@ -145,7 +616,8 @@ func (a ApiService) FollowUser(follower models.User, userName string) error {
 	if err != nil {
 		return err
 	}
-	return a.repo.FollowUser(follower, user)
+	following := models.Following{UserId: user.Id, FollowerId: follower.Id, CreatedAt: models.NewTimestamp(a.clock.Now())}
+	return a.repo.SqlQueries.AddFollowing(following)
 }
 
 func (a ApiService) UnfollowChannel(user models.User, name string) error {
@@ -166,6 +638,49 @@ func (a ApiService) UnfollowUser(follower models.User, userName string) error {
 	return a.repo.UnfollowUser(follower, user)
 }
 
+// get the followed users who have not posted in the last inactiveFor, most recently followed first
+func (a ApiService) GetInactiveFollowing(userId int, inactiveFor time.Duration) ([]models.User, error) {
+	if inactiveFor <= 0 {
+		return nil, ErrInvalidInactivity
+	}
+	return a.repo.SqlQueries.GetInactiveFollowing(userId, a.clock.Now().Add(-inactiveFor))
+}
+
+// unfollow every followed user who has not posted in the last inactiveFor and return them
+func (a ApiService) UnfollowInactive(user models.User, inactiveFor time.Duration) ([]models.User, error) {
+	inactive, err := a.GetInactiveFollowing(user.Id, inactiveFor)
+	if err != nil || len(inactive) == 0 {
+		return inactive, err
+	}
+	ids := make([]int, len(inactive))
+	for i, followed := range inactive {
+		ids[i] = followed.Id
+	}
+	if err := a.repo.SqlQueries.UnfollowUsers(user.Id, ids); err != nil {
+		return nil, err
+	}
+	return inactive, nil
+}
+
+// hide the posts of the user with mutedId from the muter's feed
+// unlike blocking, the muted user can still follow and see the muter
+func (a ApiService) MuteUser(muter models.User, mutedId int) error {
+	if muter.Id == mutedId {
+		return ErrMuteSelf
+	}
+	if _, err := a.repo.SqlQueries.GetUserById(mutedId); errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	return a.repo.SqlQueries.MuteUser(muter.Id, mutedId)
+}
+
+// show the posts of the user with mutedId in the muter's feed again
+func (a ApiService) UnmuteUser(muter models.User, mutedId int) error {
+	return a.repo.SqlQueries.UnmuteUser(muter.Id, mutedId)
+}
+
 // get all user's following's posts from the database
 func (a ApiService) GetPostsFromUsers(user models.User) ([]struct {
 	models.User
@@ -183,20 +698,116 @@ func (a ApiService) GetNewPostsFromUsers(user models.User) ([]struct {
 	return posts, err
 }
 
-// get all posts available for the given user from the database
-// func (a ApiService) GetAllPosts(user models.User) ([]models.Post, error) {
-// 	channelPosts, _ := a.GetPostsFromChannels(user)
-// 	userPosts, _ := a.GetPostsFromUsers(user)
-// 	posts := channelPosts
-// 	posts = append(posts, userPosts...)
-// 	return posts, nil
-// }
+// get a page of the posts of the channel feed and the people feed together, newest first
+// the posts are merged by the database, as merging two pages here would skip posts on the next pages
+func (a ApiService) GetAllPosts(user models.User, limit, offset int) ([]models.Post, error) {
+	if limit <= 0 {
+		limit = defaultFeedPosts
+	}
+	if limit > maxFeedPosts {
+		limit = maxFeedPosts
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.GetFeed(user.Id, limit, offset)
+}
 
+// get the users the user follows, most recently followed first
 func (a ApiService) GetFollowing(user models.User) ([]models.User, error) {
 	users, err := a.repo.SqlQueries.GetFollowing(user)
 	return users, err
 }
 
+// get the followers of the user, most recent followers first
+func (a ApiService) GetFollowers(user models.User) ([]models.User, error) {
+	users, err := a.repo.SqlQueries.GetFollowers(user)
+	return users, err
+}
+
+// search posts of users and channels visible to the viewer whose content contains the query, newest first
+// the match ignores case, an empty query returns no posts
+func (a ApiService) SearchPosts(viewer models.User, query string, limit, offset int) ([]models.Post, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []models.Post{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchPosts
+	}
+	if limit > maxSearchPosts {
+		limit = maxSearchPosts
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.SearchPosts(viewer.Id, query, limit, offset)
+}
+
+// get a page of the channels anyone can discover, ordered by id so pages stay stable while channels are added
+// deactivated channels are hidden from discovery and not listed
+func (a ApiService) GetAllPublicChannels(limit, offset int) ([]models.Channel, error) {
+	if limit <= 0 {
+		limit = defaultPublicChannels
+	}
+	if limit > maxPublicChannels {
+		limit = maxPublicChannels
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.GetPublicChannels(limit, offset)
+}
+
+// search users by the start of their username, first name or last name ignoring case, ordered by username
+// the users have only their public fields set
+func (a ApiService) SearchUsers(query string, limit, offset int) ([]models.User, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []models.User{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchUsers
+	}
+	if limit > maxSearchUsers {
+		limit = maxSearchUsers
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.SearchUsers(query, limit, offset)
+}
+
+// get posts of users and channels visible to the viewer by their hashtags, newest first
+// mode "and" requires every tag and any other mode any of them, tags match ignoring case and a leading #
+func (a ApiService) GetPostsByHashtags(viewer models.User, tags []string, mode string, limit, offset int) ([]models.Post, error) {
+	wanted := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag != "" && !slices.Contains(wanted, tag) {
+			wanted = append(wanted, tag)
+		}
+	}
+	if len(wanted) == 0 {
+		return []models.Post{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchPosts
+	}
+	if limit > maxSearchPosts {
+		limit = maxSearchPosts
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return a.repo.SqlQueries.GetPostsByHashtags(viewer.Id, wanted, mode == "and", limit, offset)
+}
+
+// get every post of the user, public and private, oldest first
+func (a ApiService) ExportUserPosts(userId int) ([]models.Post, error) {
+	return a.repo.SqlQueries.GetAllUserPosts(userId)
+}
+
 func (a ApiService) DeleteChannel(channel models.Channel) error {
 	err := a.repo.SqlQueries.DeleteChannel(channel)
 	return err
@@ -206,3 +817,143 @@ func (a ApiService) UpdateChannel(channel models.Channel) error {
 	err := a.repo.SqlQueries.UpdateChannel(channel)
 	return err
 }
+
+// get user, channel and post totals of the whole instance
+// users who wrote a post in the last 7 days are counted as active
+func (a ApiService) GetInstanceStats() (models.InstanceStats, error) {
+	activeSince := a.clock.Now().AddDate(0, 0, -7)
+	return a.repo.SqlQueries.GetInstanceStats(activeSince)
+}
+
+// suggest active channels joined by members of the user's channels, the most shared channels first
+func (a ApiService) SuggestChannels(userId int, limit int) ([]models.Channel, error) {
+	if limit <= 0 {
+		limit = defaultSuggestedChannels
+	}
+	if limit > maxSuggestedChannels {
+		limit = maxSuggestedChannels
+	}
+	channels, err := a.repo.SqlQueries.SuggestChannels(userId, limit)
+	return a.renderDescriptions(channels), err
+}
+
+// get the channels the user leads or is a member of with the role of the user in each
+func (a ApiService) GetMyChannelRoles(userId int) ([]models.ChannelRole, error) {
+	roles, err := a.repo.SqlQueries.GetChannelRoles(userId)
+	for i := range roles {
+		roles[i].Description = a.renderDescription(roles[i].Description)
+	}
+	return roles, err
+}
+
+// get channels which have no posts in the last inactiveFor, oldest channels first
+func (a ApiService) GetInactiveChannels(inactiveFor time.Duration, limit int) ([]models.Channel, error) {
+	if inactiveFor <= 0 {
+		return nil, ErrInvalidInactivity
+	}
+	if limit <= 0 {
+		limit = defaultInactiveChannels
+	}
+	if limit > maxInactiveChannels {
+		limit = maxInactiveChannels
+	}
+	channels, err := a.repo.SqlQueries.GetInactiveChannels(a.clock.Now().Add(-inactiveFor), limit)
+	return a.renderDescriptions(channels), err
+}
+
+// returns the start of the day or the week (starting on monday) containing t in UTC
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == BucketWeek {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return start
+}
+
+// returns the start of the bucket after the given one
+func nextBucket(start time.Time, bucket string) time.Time {
+	if bucket == BucketWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// get the number of new followers of the user in every day or week of the range [from, to)
+// buckets without new followers are included with a zero count
+func (a ApiService) GetFollowerGrowth(userId int, from, to time.Time, bucket string) ([]models.GrowthPoint, error) {
+	points, err := growthBuckets(from, to, bucket)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := a.repo.SqlQueries.GetFollowerCounts(userId, from, to, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return fillBuckets(points, counts), nil
+}
+
+// get the number of posts of the channel in every day or week of the range [from, to), only the leader of the channel can see it
+// buckets without posts are included with a zero count
+func (a ApiService) GetChannelPostFrequency(channelId int, from, to time.Time, bucket string, actor models.User) ([]models.GrowthPoint, error) {
+	channel, err := a.GetChannelById(channelId)
+	if err != nil {
+		return nil, err
+	}
+	if channel.LeaderId != actor.Id {
+		return nil, ErrNotChannelLeader
+	}
+	points, err := growthBuckets(from, to, bucket)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := a.repo.SqlQueries.GetChannelPostCounts(channelId, from, to, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return fillBuckets(points, counts), nil
+}
+
+// returns the empty buckets between from and to
+func growthBuckets(from, to time.Time, bucket string) ([]models.GrowthPoint, error) {
+	if bucket != BucketDay && bucket != BucketWeek {
+		return nil, ErrInvalidBucket
+	}
+	if !from.Before(to) {
+		return nil, ErrInvalidGrowthRange
+	}
+
+	var points []models.GrowthPoint
+	for start := bucketStart(from, bucket); start.Before(to); start = nextBucket(start, bucket) {
+		if len(points) == maxGrowthBuckets {
+			return nil, ErrInvalidGrowthRange
+		}
+		points = append(points, models.GrowthPoint{Start: models.NewTimestamp(start)})
+	}
+	return points, nil
+}
+
+// copies the counts of the buckets which have any into the points
+func fillBuckets(points, counts []models.GrowthPoint) []models.GrowthPoint {
+	index := make(map[int64]int, len(points))
+	for i, point := range points {
+		index[point.Start.Unix()] = i
+	}
+	for _, count := range counts {
+		if i, ok := index[count.Start.Unix()]; ok {
+			points[i].Count = count.Count
+		}
+	}
+	return points
+}
+
+// get the hashtags the user used most in their public posts, most used first
+func (a ApiService) GetUserTopHashtags(userId int, limit int) ([]models.TagCount, error) {
+	if limit <= 0 {
+		limit = defaultTopHashtags
+	}
+	if limit > maxTopHashtags {
+		limit = maxTopHashtags
+	}
+	return a.repo.SqlQueries.GetUserTopHashtags(userId, limit)
+}
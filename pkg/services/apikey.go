@@ -0,0 +1,91 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/I1Asyl/berliner_backend/models"
+)
+
+// scopes of api keys, each one lets a key call the routes of its name
+const (
+	ScopeStatsRead    = "stats:read"
+	ScopeChannelsRead = "channels:read"
+)
+
+// scopes an api key can be created with
+var APIKeyScopes = []string{ScopeStatsRead, ScopeChannelsRead}
+
+// returned when an api key is created without a name or with a name longer than the column
+var ErrAPIKeyName = errors.New("api key name must be 1 to 255 characters")
+
+// returned when an api key is created without scopes or with a scope which is not in APIKeyScopes
+var ErrAPIKeyScope = errors.New("api key scopes must be one or more of " + strings.Join(APIKeyScopes, ", "))
+
+// returned when an api key was never created or was revoked
+var ErrInvalidAPIKey = errors.New("invalid or revoked api key")
+
+// returned when an api key to revoke does not exist or was already revoked
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// keys start with it so they can be told apart from tokens, such as by secret scanners
+const apiKeyPrefix = "brl_"
+
+// create an api key with the name and scopes, returns the key which is not stored and can not be shown again
+func (a AuthService) CreateAPIKey(form models.APIKeyForm) (string, models.APIKey, error) {
+	name := strings.TrimSpace(form.Name)
+	if name == "" || len(name) > 255 {
+		return "", models.APIKey{}, ErrAPIKeyName
+	}
+	if len(form.Scopes) == 0 {
+		return "", models.APIKey{}, ErrAPIKeyScope
+	}
+	scopes := []string{}
+	for _, scope := range form.Scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return "", models.APIKey{}, fmt.Errorf("%w: %q", ErrAPIKeyScope, scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+	key := apiKeyPrefix + token
+	apiKey := models.APIKey{Name: name, KeyHash: hashToken(key), Scopes: scopes, CreatedAt: models.NewTimestamp(a.clock.Now())}
+	if apiKey.Id, err = a.repo.SqlQueries.AddAPIKey(apiKey); err != nil {
+		return "", models.APIKey{}, err
+	}
+	return key, apiKey, nil
+}
+
+// returns every api key including the revoked ones, without the keys themselves
+func (a AuthService) GetAPIKeys() ([]models.APIKey, error) {
+	return a.repo.SqlQueries.GetAPIKeys()
+}
+
+// revoke the api key, it can not be used from then on
+func (a AuthService) RevokeAPIKey(id int) error {
+	revoked, err := a.repo.SqlQueries.RevokeAPIKey(id, a.clock.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// returns the api key of a request, ErrInvalidAPIKey when it was never created or was revoked
+func (a AuthService) AuthenticateAPIKey(key string) (models.APIKey, error) {
+	apiKey, err := a.repo.SqlQueries.GetAPIKeyByHash(hashToken(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	return apiKey, err
+}
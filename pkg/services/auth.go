@@ -1,89 +1,470 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
 	"github.com/I1Asyl/berliner_backend/pkg/repository"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// claims for jwt token
-type UserClaims struct {
-	Username string
-	jwt.RegisteredClaims
-}
-
 // auth service struct
 type AuthService struct {
 	//database connection
 	repo repository.Repository
+	// current time source
+	clock Clock
+	// issues and verifies the login tokens
+	tokens auth.TokenManager
+	// how long a refresh token can be exchanged
+	refreshTTL time.Duration
+	// usernames given the admin role at startup while there is no admin
+	admin config.Admin
+	// registration settings, replaced when the config is reloaded
+	auth *atomic.Pointer[config.Auth]
+	// encrypts the TOTP secrets of two-factor authentication
+	secrets *auth.SecretBox
+}
+
+// returned when a user signs up while auth.signups_enabled is false
+var ErrSignupsDisabled = errors.New("signups are disabled")
+
+// returned when an identity provider signs in to an account whose email was never verified
+var ErrUnverifiedAccount = errors.New("an account with the email exists but its email is not verified")
+
+// returned when a refresh token was never issued
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// returned when a refresh token is past its expiry
+var ErrRefreshTokenExpired = errors.New("refresh token is expired")
+
+// returned when a refresh token was already exchanged or revoked
+var ErrRefreshTokenRevoked = errors.New("refresh token is revoked")
+
+// returned when an email verification token was never issued, was already used or was replaced by a newer one
+var ErrInvalidVerificationToken = errors.New("invalid verification token")
+
+// returned when an email verification token is past its expiry
+var ErrVerificationTokenExpired = errors.New("verification token is expired")
+
+// returned when a verification token is requested again before verificationResendCooldown passed
+var ErrVerificationCooldown = errors.New("a verification token was sent recently, try again later")
+
+// returned when an access token was revoked before it expired, e.g. by logging out
+var ErrTokenRevoked = errors.New("token is revoked")
+
+// returned when a user has no session of the id
+var ErrSessionNotFound = errors.New("session not found")
+
+// how an account signs in, with its password or with an identity provider
+const (
+	PasswordProvider = "password"
+	GoogleProvider   = "google"
+)
+
+// returned when an account of an identity provider signs in with a password
+type ProviderAccountError struct {
+	Provider string
+}
+
+func (e *ProviderAccountError) Error() string {
+	return fmt.Sprintf("the account has no password, sign in with %s", e.Provider)
+}
+
+// an account of an identity provider gets a username from its email, tried with a number suffix until a free one is found
+const maxUsernameAttempts = 100
+
+// size of the random part of a refresh token
+const refreshTokenBytes = 32
+
+// size of the random id of an access token
+const tokenIdBytes = 16
+
+// how long a password reset token can be used
+const passwordResetTTL = 30 * time.Minute
+
+// how long an email verification token can be used
+const verificationTTL = 24 * time.Hour
+
+// how long a user waits before another email verification token is generated
+const verificationResendCooldown = time.Minute
+
+// message of an unknown, used or expired password reset token
+const invalidResetToken = "Invalid or expired reset token"
+
+// TokenClaims identify the holder of an access token
+// UserId is 0 in the tokens issued before it was added to them, SessionId in the tokens of no session
+type TokenClaims struct {
+	UserId    int
+	Username  string
+	SessionId int
+}
+
+// TokenPair is a short lived access token and the refresh token it can be renewed with
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
 }
 
 // NewAuthService returns a new AuthService instance
-func NewAuthService(repo repository.Repository) *AuthService {
-	return &AuthService{repo: repo}
+func NewAuthService(repo repository.Repository, clock Clock, tokens auth.TokenManager, refreshTTL time.Duration, admin config.Admin, registration config.Auth, secrets *auth.SecretBox) *AuthService {
+	a := &AuthService{repo: repo, clock: clock, tokens: tokens, refreshTTL: refreshTTL, admin: admin, auth: &atomic.Pointer[config.Auth]{}, secrets: secrets}
+	a.auth.Store(&registration)
+	return a
+}
+
+// ApplyConfig applies the reloaded registration settings
+func (a AuthService) ApplyConfig(cfg config.Config) {
+	a.auth.Store(&cfg.Auth)
 }
 
-// check if user exists and password is correct
-func (a AuthService) CheckUserAndPassword(userForm models.AuthorizationForm) (bool, error) {
-	user, err := a.repo.SqlQueries.GetUserByUserame(userForm.Username)
+// check if user exists and password is correct, the username of the form can also be an email
+// the second result tells whether the email of the user is verified
+func (a AuthService) CheckUserAndPassword(userForm models.AuthorizationForm) (bool, bool, error) {
+	user, err := a.findUser(userForm.Username)
 	if err != nil {
-		return false, err
+		return false, false, err
+	}
+	if user.AuthProvider != PasswordProvider {
+		return false, false, &ProviderAccountError{Provider: user.AuthProvider}
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userForm.Password))
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
-	return true, nil
+	return true, user.IsVerified, nil
 }
 
-// parse jwt token
-func (a AuthService) ParseToken(token string) (string, error) {
-	claims := UserClaims{}
-	ans, err := jwt.ParseWithClaims(token, &claims, func(tok *jwt.Token) (interface{}, error) {
-		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
-			return "", errors.New("Error")
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-	if claims, ok := ans.Claims.(*UserClaims); ok && ans.Valid {
-		return claims.Username, nil
-	} else {
+// finds the user signing in with a username or an email, the username wins when a legacy username is also an email
+func (a AuthService) findUser(identifier string) (models.User, error) {
+	user, err := a.repo.SqlQueries.GetUserByUserame(identifier)
+	if errors.Is(err, sql.ErrNoRows) && strings.Contains(identifier, "@") {
+		return a.repo.SqlQueries.GetUserByEmail(identifier)
+	}
+	return user, err
+}
+
+// generate an email verification token of the user, replacing the previous one
+func (a AuthService) GenerateVerificationToken(username string) (string, error) {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return "", err
+	}
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
 		return "", err
 	}
+	verification := models.EmailVerification{
+		UserId:    user.Id,
+		TokenHash: hashToken(token),
+		ExpiresAt: models.NewTimestamp(a.clock.Now().Add(verificationTTL)),
+	}
+	if err := a.repo.SqlQueries.SetEmailVerification(verification); err != nil {
+		return "", err
+	}
+	return token, nil
+}
 
+// generate a new email verification token of the user, at most once per verificationResendCooldown
+// the issue time of the previous token is derived from its expiry
+func (a AuthService) ResendVerification(username string) (string, error) {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return "", err
+	}
+	previous, err := a.repo.SqlQueries.GetEmailVerification(user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if err == nil && a.clock.Now().Before(previous.ExpiresAt.Add(verificationResendCooldown-verificationTTL)) {
+		return "", ErrVerificationCooldown
+	}
+	return a.GenerateVerificationToken(username)
 }
 
-// generate jwt token
-func (a AuthService) GenerateToken(user models.AuthorizationForm, issueTime time.Time, expireTime time.Time) (string, error) {
-	claims := UserClaims{
-		user.Username,
+// mark the email of the user the verification token was issued to as verified, the token can not be used again
+func (a AuthService) VerifyEmail(token string) error {
+	verification, err := a.repo.SqlQueries.TakeEmailVerification(hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	if !a.clock.Now().Before(verification.ExpiresAt.Time) {
+		return ErrVerificationTokenExpired
+	}
+	return a.repo.SqlQueries.SetUserVerified(verification.UserId)
+}
+
+// parse token, returns the user it was issued to, revoked tokens are rejected
+func (a AuthService) ParseToken(token string) (TokenClaims, error) {
+	claims, err := a.tokens.Verify(token)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	// tokens issued before they had an id can not be revoked
+	if claims.Id != "" {
+		revoked, err := a.repo.SqlQueries.IsTokenRevoked(claims.Id)
+		if err != nil {
+			return TokenClaims{}, err
+		}
+		if revoked {
+			return TokenClaims{}, ErrTokenRevoked
+		}
+	}
+	return TokenClaims{UserId: claims.UserId, Username: claims.Username, SessionId: claims.SessionId}, nil
+}
+
+// revoke an access token until it expires, tokens which do not verify or have no id are ignored
+// as they can not be used or revoked anyway
+func (a AuthService) RevokeToken(token string) error {
+	claims, err := a.tokens.Verify(token)
+	if err != nil || claims.Id == "" {
+		return nil
+	}
+	return a.repo.SqlQueries.AddRevokedToken(models.RevokedToken{Jti: claims.Id, ExpiresAt: models.NewTimestamp(claims.ExpiresAt)})
+}
 
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expireTime),
-			IssuedAt:  jwt.NewNumericDate(issueTime),
-			Issuer:    "test",
-			Subject:   "somebody",
-		},
+// delete the revoked tokens which have expired, returns how many were deleted
+func (a AuthService) PruneRevokedTokens() (int64, error) {
+	return a.repo.SqlQueries.DeleteExpiredRevokedTokens(a.clock.Now())
+}
+
+// generate token of the user, returns the token and when it expires
+func (a AuthService) GenerateToken(user models.AuthorizationForm) (string, time.Time, error) {
+	stored, err := a.findUser(user.Username)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return a.issueAccessToken(stored, 0)
+}
+
+// issue an access token with the id and the username of the user, sessionId is 0 for a token of no session
+func (a AuthService) issueAccessToken(user models.User, sessionId int) (string, time.Time, error) {
+	id, err := randomToken(tokenIdBytes)
+	if err != nil {
+		return "", time.Time{}, err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	if os.Getenv("JWT_SECRET") == "" {
-		log.Fatal("JWT_SECRET is not set, set your jwt password in .env file")
+	claims := auth.Claims{Id: id, UserId: user.Id, Username: user.Username, SessionId: sessionId, IssuedAt: a.clock.Now()}
+	token, err := a.tokens.Issue(claims)
+	if err != nil {
+		return "", time.Time{}, err
 	}
-	signedKey := []byte(os.Getenv("JWT_SECRET"))
-	signed, err := token.SignedString(signedKey)
-	return signed, err
+	// the expiry is filled in by the token manager, so it is read back from the token
+	verified, err := a.tokens.Verify(token)
+	return token, verified.ExpiresAt, err
+}
 
+// generate an access token and a refresh token of the user in a new session of the device
+func (a AuthService) GenerateTokenPair(user models.AuthorizationForm, device string) (TokenPair, error) {
+	stored, err := a.findUser(user.Username)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	sessionId, err := a.startSession(stored, device)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return a.issueTokenPair(stored, sessionId)
 }
 
-// add user to the database
+// sign in with an identity the provider verified, returns the same tokens as a password login
+// the account with the email is signed in when its email is verified, or a new verified account without a password
+// is created when signups are enabled
+func (a AuthService) SignInWithProvider(provider string, identity auth.Identity, device string) (TokenPair, error) {
+	user, err := a.repo.SqlQueries.GetUserByEmail(identity.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if !a.auth.Load().SignupsEnabled {
+			return TokenPair{}, ErrSignupsDisabled
+		}
+		user = models.User{FirstName: identity.GivenName, LastName: identity.FamilyName, Email: identity.Email, AuthProvider: provider}
+		if user.Username, err = a.freeUsername(identity.Email); err != nil {
+			return TokenPair{}, err
+		}
+		if user.Id, err = a.repo.SqlQueries.AddProviderUser(user); err != nil {
+			return TokenPair{}, err
+		}
+	case err != nil:
+		return TokenPair{}, err
+	case !user.IsVerified:
+		// anyone can sign up with an email they do not own, linking would let them keep signing in with their password
+		return TokenPair{}, ErrUnverifiedAccount
+	}
+	sessionId, err := a.startSession(user, device)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return a.issueTokenPair(user, sessionId)
+}
+
+// returns an unused username made of the allowed characters of the local part of the email
+func (a AuthService) freeUsername(email string) (string, error) {
+	local, _, _ := strings.Cut(email, "@")
+	base := strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, local)
+	// room is left for the suffix, usernames are 4 to 25 characters
+	if len(base) > 20 {
+		base = base[:20]
+	}
+	if len(base) < 4 {
+		base += "_user"
+	}
+	for i := 1; i <= maxUsernameAttempts; i++ {
+		username := base
+		if i > 1 {
+			username += strconv.Itoa(i)
+		}
+		_, err := a.repo.SqlQueries.GetUserByUserame(username)
+		if errors.Is(err, sql.ErrNoRows) {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free username for %s", email)
+}
+
+// adds a session of the user and returns its id
+func (a AuthService) startSession(user models.User, device string) (int, error) {
+	now := models.NewTimestamp(a.clock.Now())
+	return a.repo.SqlQueries.AddSession(models.Session{UserId: user.Id, Device: device, CreatedAt: now, LastUsedAt: now})
+}
+
+// exchange a refresh token for a new pair, the refresh token can not be used again
+// reusing an exchanged refresh token revokes every refresh token of its user, as the token was probably stolen
+func (a AuthService) RefreshTokens(refreshToken string) (TokenPair, error) {
+	stored, err := a.repo.SqlQueries.GetRefreshToken(hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if stored.Revoked {
+		if err := a.repo.SqlQueries.RevokeUserRefreshTokens(stored.UserId); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrRefreshTokenRevoked
+	}
+	if !a.clock.Now().Before(stored.ExpiresAt.Time) {
+		return TokenPair{}, ErrRefreshTokenExpired
+	}
+	// a concurrent exchange of the same token revokes it first
+	revoked, err := a.repo.SqlQueries.RevokeRefreshToken(stored.Id)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !revoked {
+		return TokenPair{}, ErrRefreshTokenRevoked
+	}
+	user, err := a.repo.SqlQueries.GetUserById(stored.UserId)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	// the tokens issued before sessions were added start one of an unknown device
+	if !stored.SessionId.Valid {
+		sessionId, err := a.startSession(user, "")
+		if err != nil {
+			return TokenPair{}, err
+		}
+		return a.issueTokenPair(user, sessionId)
+	}
+	sessionId := int(stored.SessionId.Int64)
+	if err := a.repo.SqlQueries.TouchSession(sessionId, a.clock.Now()); err != nil {
+		return TokenPair{}, err
+	}
+	return a.issueTokenPair(user, sessionId)
+}
+
+// revoke a refresh token, unknown tokens are ignored
+func (a AuthService) RevokeRefreshToken(refreshToken string) error {
+	stored, err := a.repo.SqlQueries.GetRefreshToken(hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = a.repo.SqlQueries.RevokeRefreshToken(stored.Id)
+	return err
+}
+
+// issues an access token and stores a new refresh token of the user in the session
+func (a AuthService) issueTokenPair(user models.User, sessionId int) (TokenPair, error) {
+	access, accessExpiresAt, err := a.issueAccessToken(user, sessionId)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := a.clock.Now()
+	stored := models.RefreshToken{
+		UserId:    user.Id,
+		TokenHash: hashToken(refresh),
+		CreatedAt: models.NewTimestamp(now),
+		ExpiresAt: models.NewTimestamp(now.Add(a.refreshTTL)),
+		SessionId: models.NullInt64{NullInt64: sql.NullInt64{Int64: int64(sessionId), Valid: true}},
+	}
+	if err := a.repo.SqlQueries.AddRefreshToken(stored); err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: stored.ExpiresAt.Time,
+	}, nil
+}
+
+// refresh tokens are stored hashed, so a leaked database does not leak usable tokens
+// returns size random bytes encoded as url safe base64
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// refresh, password reset and verification tokens are stored as their sha-256 hash
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// add user to the database, rejected under the "signups" key when signups are disabled
 func (a AuthService) AddUser(user models.User) map[string]string {
+	if !a.auth.Load().SignupsEnabled {
+		return map[string]string{"signups": ErrSignupsDisabled.Error()}
+	}
+	return a.CreateUser(user)
+}
+
+// add user to the database even when signups are disabled, used by admins
+func (a AuthService) CreateUser(user models.User) map[string]string {
 	invalid := user.IsValid()
 	if len(invalid) == 0 {
 		user.Password = a.HashPassword(user.Password)
@@ -91,7 +472,7 @@ func (a AuthService) AddUser(user models.User) map[string]string {
 			invalid["error"] = err.Error()
 		} else {
 			a.repo.SqlQueries.GetUserByUserame(user.Username)
-			following := models.Following{UserId: user.Id, FollowerId: user.Id}
+			following := models.Following{UserId: user.Id, FollowerId: user.Id, CreatedAt: models.NewTimestamp(a.clock.Now())}
 			a.repo.SqlQueries.AddFollowing(following)
 		}
 	}
@@ -105,6 +486,164 @@ func (a AuthService) GetUserFromUsername(username string) (models.User, error) {
 	return user, err
 }
 
+// generate a password reset token of the user with the email, replacing the previous one
+// an unknown email gets an empty token and no error, so callers can not tell which emails have accounts
+func (a AuthService) RequestPasswordReset(email string) (string, error) {
+	user, err := a.repo.SqlQueries.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	reset := models.PasswordReset{
+		UserId:    user.Id,
+		TokenHash: hashToken(token),
+		ExpiresAt: models.NewTimestamp(a.clock.Now().Add(passwordResetTTL)),
+	}
+	if err := a.repo.SqlQueries.SetPasswordReset(reset); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// set the password of the user the reset token was issued to, the token can not be used again
+// the keys of the returned map are those of AddUser, "token" when the token is invalid and "error" on failures
+func (a AuthService) ResetPassword(token, newPassword string) map[string]string {
+	invalid := make(map[string]string)
+	// the password is checked first, so a token is not used up by a password which is rejected
+	if len(models.PasswordPolicy(newPassword)) != 0 {
+		invalid["password"] = "Invalid password"
+		return invalid
+	}
+	reset, err := a.repo.SqlQueries.TakePasswordReset(hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !a.clock.Now().Before(reset.ExpiresAt.Time)) {
+		invalid["token"] = invalidResetToken
+		return invalid
+	}
+	if err != nil {
+		invalid["error"] = err.Error()
+		return invalid
+	}
+	if err := a.repo.SqlQueries.UpdateUserPassword(reset.UserId, a.HashPassword(newPassword)); err != nil {
+		invalid["error"] = err.Error()
+		return invalid
+	}
+	// sessions of whoever knew the old password end when their access tokens expire
+	if err := a.repo.SqlQueries.RevokeUserRefreshTokens(reset.UserId); err != nil {
+		invalid["error"] = err.Error()
+	}
+	return invalid
+}
+
+// change the password of the user after checking the current one, the other sessions end like after a reset
+func (a AuthService) ChangePassword(username, currentPassword, newPassword string) map[string]string {
+	invalid := make(map[string]string)
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		invalid["error"] = err.Error()
+		return invalid
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		invalid["password"] = "Current password is incorrect"
+		return invalid
+	}
+	if len(models.PasswordPolicy(newPassword)) != 0 {
+		invalid["newPassword"] = "Invalid password"
+		return invalid
+	}
+	// the password is only changed together with the revocation, so a failure leaves the old password
+	if err := a.repo.SqlQueries.ChangeUserPassword(user.Id, a.HashPassword(newPassword)); err != nil {
+		invalid["error"] = err.Error()
+	}
+	return invalid
+}
+
+// update the names and the email of the user, an email of another account is rejected
+// a changed email has to be verified again
+func (a AuthService) UpdateUser(username string, updates models.UserUpdate) map[string]string {
+	invalid := updates.IsValid()
+	if len(invalid) != 0 {
+		return invalid
+	}
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		invalid["error"] = err.Error()
+		return invalid
+	}
+	if updates.Email != nil && !strings.EqualFold(*updates.Email, user.Email) {
+		other, err := a.repo.SqlQueries.GetUserByEmail(*updates.Email)
+		if err == nil && other.Id != user.Id {
+			invalid["email"] = "Email is already used"
+			return invalid
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			invalid["error"] = err.Error()
+			return invalid
+		}
+	}
+	if err := a.repo.SqlQueries.UpdateUser(user.Id, updates); err != nil {
+		invalid["error"] = err.Error()
+	}
+	return invalid
+}
+
+// delete the account of the user with everything it owns
+// a channel the user leads is handed to its longest tenured editor, or deleted when it has no other editor
+func (a AuthService) DeleteUser(username string) error {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return err
+	}
+	return a.repo.SqlQueries.DeleteUser(user.Id)
+}
+
+// returns the sessions of the user which can still be refreshed, currentId is marked as the current one
+func (a AuthService) GetSessions(username string, currentId int) ([]models.Session, error) {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := a.repo.SqlQueries.GetActiveSessions(user.Id, a.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == currentId
+	}
+	return sessions, nil
+}
+
+// revoke a session of the user, its refresh tokens can not be exchanged anymore
+// the access tokens already issued to it stay valid until they expire
+func (a AuthService) RevokeSession(username string, id int) error {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return err
+	}
+	deleted, err := a.repo.SqlQueries.DeleteSession(user.Id, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// revoke every session of the user except currentId, 0 revokes all of them
+func (a AuthService) RevokeOtherSessions(username string, currentId int) error {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return err
+	}
+	return a.repo.SqlQueries.DeleteOtherSessions(user.Id, currentId)
+}
+
 // hash password
 func (a AuthService) HashPassword(password string) string {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 11)
@@ -113,3 +652,34 @@ func (a AuthService) HashPassword(password string) string {
 	}
 	return string(hashed)
 }
+
+// returns every rule of the password policy the password does not meet, signups reject the same passwords
+func (a AuthService) CheckPasswordPolicy(password string) []models.ValidationError {
+	return models.PasswordPolicy(password)
+}
+
+// check if the user is a platform admin, unknown usernames are not
+func (a AuthService) IsAdmin(username string) (bool, error) {
+	return isAdmin(a.repo, username)
+}
+
+// gives the admin role to the registered users listed in admin.usernames when there is no admin yet
+// returns the usernames of the new admins, called once at startup
+func (a AuthService) SeedAdmins() ([]string, error) {
+	if len(a.admin.Usernames) == 0 {
+		return nil, nil
+	}
+	return a.repo.SqlQueries.SeedAdmins(a.admin.Usernames)
+}
+
+// the role is read from the database on every check, so a demoted or deleted admin loses access right away
+func isAdmin(repo repository.Repository, username string) (bool, error) {
+	user, err := repo.SqlQueries.GetUserByUserame(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.Role == models.RoleAdmin, nil
+}
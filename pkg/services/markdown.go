@@ -0,0 +1,121 @@
+package services
+
+import (
+	"html"
+	"strings"
+)
+
+// RenderDescription renders a markdown channel description as sanitized html
+// paragraphs, line breaks, "- " lists, **bold**, *italic*, `code` and [links](https://...) are supported,
+// everything else is kept as escaped text and links without an http, https or mailto scheme keep only their text
+func RenderDescription(raw string) string {
+	var out strings.Builder
+	// the kind of block which is open, "p", "ul" or none
+	open := ""
+	closeBlock := func() {
+		if open != "" {
+			out.WriteString("</" + open + ">")
+			open = ""
+		}
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			closeBlock()
+			continue
+		}
+		if item, ok := listItem(line); ok {
+			if open != "ul" {
+				closeBlock()
+				out.WriteString("<ul>")
+				open = "ul"
+			}
+			out.WriteString("<li>")
+			renderInline(&out, item)
+			out.WriteString("</li>")
+			continue
+		}
+		if open == "p" {
+			out.WriteString("<br>")
+		} else {
+			closeBlock()
+			out.WriteString("<p>")
+			open = "p"
+		}
+		renderInline(&out, line)
+	}
+	closeBlock()
+	return SanitizeContent(out.String())
+}
+
+// returns the text of a list item line starting with "- " or "* "
+func listItem(line string) (string, bool) {
+	for _, marker := range []string{"- ", "* "} {
+		if item, ok := strings.CutPrefix(line, marker); ok {
+			return strings.TrimSpace(item), true
+		}
+	}
+	return "", false
+}
+
+// writes the inline markup of the text as html, the text between markup is escaped
+func renderInline(out *strings.Builder, text string) {
+	for len(text) > 0 {
+		if rest, ok := renderSpan(out, text); ok {
+			text = rest
+			continue
+		}
+		if text[0] == '\\' && len(text) > 1 && strings.ContainsRune("\\`*[]()", rune(text[1])) {
+			out.WriteString(textEscaper.Replace(text[1:2]))
+			text = text[2:]
+			continue
+		}
+		out.WriteString(textEscaper.Replace(text[:1]))
+		text = text[1:]
+	}
+}
+
+// writes the span of inline markup the text starts with and returns the text after it,
+// false when the text does not start with a complete span
+func renderSpan(out *strings.Builder, text string) (string, bool) {
+	switch {
+	case strings.HasPrefix(text, "`"):
+		if end := strings.Index(text[1:], "`"); end > 0 {
+			out.WriteString("<code>" + textEscaper.Replace(text[1:end+1]) + "</code>")
+			return text[end+2:], true
+		}
+	case strings.HasPrefix(text, "**"):
+		if end := strings.Index(text[2:], "**"); end > 0 {
+			out.WriteString("<strong>")
+			renderInline(out, text[2:end+2])
+			out.WriteString("</strong>")
+			return text[end+4:], true
+		}
+	case strings.HasPrefix(text, "*"):
+		// "* " is a literal asterisk, as in "2 * 3"
+		if end := strings.Index(text[1:], "*"); end > 0 && text[1] != ' ' {
+			out.WriteString("<em>")
+			renderInline(out, text[1:end+1])
+			out.WriteString("</em>")
+			return text[end+2:], true
+		}
+	case strings.HasPrefix(text, "["):
+		label, rest, ok := strings.Cut(text[1:], "](")
+		if !ok || strings.Contains(label, "]") {
+			return text, false
+		}
+		link, rest, ok := strings.Cut(rest, ")")
+		if !ok {
+			return text, false
+		}
+		if safeLink(link) {
+			out.WriteString(`<a href="` + html.EscapeString(strings.TrimSpace(link)) + `">`)
+			renderInline(out, label)
+			out.WriteString("</a>")
+		} else {
+			renderInline(out, label)
+		}
+		return rest, true
+	}
+	return text, false
+}
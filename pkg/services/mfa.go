@@ -0,0 +1,260 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+)
+
+// returned when two-factor authentication is set up while it is enabled
+var ErrMFAEnabled = errors.New("two-factor authentication is already enabled")
+
+// returned when two-factor authentication is confirmed before it was set up
+var ErrMFANotSetUp = errors.New("two-factor authentication is not set up")
+
+// returned when two-factor authentication is disabled while it is not enabled
+var ErrMFANotEnabled = errors.New("two-factor authentication is not enabled")
+
+// returned when a code is wrong, was already used or is of a step too far from now
+var ErrInvalidMFACode = errors.New("invalid two-factor authentication code")
+
+// returned when the token of a pending login was never issued, expired, was completed or got too many wrong codes
+var ErrInvalidMFAToken = errors.New("invalid or expired two-factor authentication token")
+
+// issuer authenticator apps show next to the account
+const totpIssuer = "Berliner"
+
+// how long a password login waits for the code
+const mfaChallengeTTL = 5 * time.Minute
+
+// wrong codes a pending login accepts before the password has to be given again
+const maxMFAAttempts = 5
+
+// recovery codes generated with a secret, each can be used once instead of a code
+const recoveryCodeCount = 10
+
+// size of a recovery code, encoded as 8 base32 characters
+const recoveryCodeBytes = 5
+
+// secret and recovery codes of a new two-factor authentication setup, only shown once
+type MFASetup struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// set up two-factor authentication of the user with a new secret and recovery codes
+// it is enabled by ConfirmMFA, so a setup which was never completed can be replaced
+func (a AuthService) EnableMFA(username string) (MFASetup, error) {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return MFASetup{}, err
+	}
+	current, err := a.repo.SqlQueries.GetUserMFA(user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return MFASetup{}, err
+	}
+	if err == nil && current.Enabled {
+		return MFASetup{}, ErrMFAEnabled
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return MFASetup{}, err
+	}
+	sealed, err := a.secrets.Seal(secret)
+	if err != nil {
+		return MFASetup{}, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = recoveryCode(); err != nil {
+			return MFASetup{}, err
+		}
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	mfa := models.UserMFA{UserId: user.Id, Secret: sealed, CreatedAt: models.NewTimestamp(a.clock.Now())}
+	if err := a.repo.SqlQueries.SetUserMFA(mfa, hashes); err != nil {
+		return MFASetup{}, err
+	}
+	return MFASetup{Secret: secret, URI: auth.TOTPURI(totpIssuer, user.Username, secret), RecoveryCodes: codes}, nil
+}
+
+// enable the two-factor authentication set up by EnableMFA with a first code of the authenticator app
+func (a AuthService) ConfirmMFA(username, code string) error {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return err
+	}
+	mfa, err := a.repo.SqlQueries.GetUserMFA(user.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMFANotSetUp
+	}
+	if err != nil {
+		return err
+	}
+	if mfa.Enabled {
+		return ErrMFAEnabled
+	}
+	secret, err := a.secrets.Open(mfa.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := auth.ValidateTOTP(secret, code, a.clock.Now(), mfa.LastStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	enabled, err := a.repo.SqlQueries.EnableUserMFA(user.Id, step)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrMFAEnabled
+	}
+	return nil
+}
+
+// disable the two-factor authentication of the user with a current code or a recovery code
+func (a AuthService) DisableMFA(username, code string) error {
+	user, err := a.repo.SqlQueries.GetUserByUserame(username)
+	if err != nil {
+		return err
+	}
+	mfa, err := a.repo.SqlQueries.GetUserMFA(user.Id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !mfa.Enabled) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.checkMFACode(mfa, code); err != nil {
+		return err
+	}
+	return a.repo.SqlQueries.DeleteUserMFA(user.Id)
+}
+
+// start the second step of a password login of the device, returns the token the code is given with
+// the token is empty when the user has no two-factor authentication, the tokens are issued right away then
+func (a AuthService) StartMFAChallenge(user models.AuthorizationForm, device string) (string, error) {
+	stored, err := a.findUser(user.Username)
+	if err != nil {
+		return "", err
+	}
+	mfa, err := a.repo.SqlQueries.GetUserMFA(stored.Id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !mfa.Enabled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	token, err := randomToken(refreshTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	now := a.clock.Now()
+	challenge := models.MFAChallenge{
+		UserId:    stored.Id,
+		TokenHash: hashToken(token),
+		Device:    device,
+		ExpiresAt: models.NewTimestamp(now.Add(mfaChallengeTTL)),
+	}
+	if err := a.repo.SqlQueries.AddMFAChallenge(challenge, now); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// complete a password login with a code of the authenticator app or a recovery code, returns the tokens of the login
+// the token can not be used again once the login is completed or after maxMFAAttempts wrong codes
+func (a AuthService) VerifyMFA(token, code string) (TokenPair, error) {
+	challenge, err := a.repo.SqlQueries.GetMFAChallenge(hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !a.clock.Now().Before(challenge.ExpiresAt.Time)) {
+		return TokenPair{}, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	mfa, err := a.repo.SqlQueries.GetUserMFA(challenge.UserId)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	err = a.checkMFACode(mfa, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		attempts, failErr := a.repo.SqlQueries.FailMFAChallenge(challenge.Id)
+		if failErr != nil {
+			return TokenPair{}, failErr
+		}
+		if attempts >= maxMFAAttempts {
+			if _, err := a.repo.SqlQueries.DeleteMFAChallenge(challenge.Id); err != nil {
+				return TokenPair{}, err
+			}
+		}
+		return TokenPair{}, err
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	// a concurrent completion of the same login deletes it first
+	deleted, err := a.repo.SqlQueries.DeleteMFAChallenge(challenge.Id)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !deleted {
+		return TokenPair{}, ErrInvalidMFAToken
+	}
+	user, err := a.repo.SqlQueries.GetUserById(challenge.UserId)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	sessionId, err := a.startSession(user, challenge.Device)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return a.issueTokenPair(user, sessionId)
+}
+
+// checks a code of the authenticator app or else a recovery code of the user, both can be used only once
+// a secret sealed before the jwt secret was rotated can not be opened, only the recovery codes are accepted then
+func (a AuthService) checkMFACode(mfa models.UserMFA, code string) error {
+	if secret, err := a.secrets.Open(mfa.Secret); err == nil {
+		if step, ok := auth.ValidateTOTP(secret, code, a.clock.Now(), mfa.LastStep); ok {
+			used, err := a.repo.SqlQueries.UseMFAStep(mfa.UserId, step)
+			if err != nil {
+				return err
+			}
+			if !used {
+				return ErrInvalidMFACode
+			}
+			return nil
+		}
+	}
+	used, err := a.repo.SqlQueries.UseRecoveryCode(mfa.UserId, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// returns a random recovery code such as "ABCD-EFGH"
+func recoveryCode() (string, error) {
+	buf := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(buf)
+	return code[:4] + "-" + code[4:], nil
+}
+
+// recovery codes are accepted in any case and with or without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
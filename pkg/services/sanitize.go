@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// tags which are kept in post content, all other tags are removed and their text is kept
+var allowedTags = map[string]bool{
+	"a": true, "b": true, "i": true, "em": true, "strong": true, "u": true, "s": true,
+	"p": true, "br": true, "ul": true, "ol": true, "li": true,
+	"blockquote": true, "code": true, "pre": true,
+}
+
+// tags which are removed together with everything inside them
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"textarea": true, "title": true, "noscript": true, "noembed": true, "noframes": true,
+	"xmp": true, "plaintext": true, "template": true, "svg": true, "math": true,
+}
+
+// link schemes which are kept in href attributes
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// escapes plain text for html, quotes are left alone so text reads the same as it was written
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// SanitizeContent removes markup which is not on a strict allowlist from post content to prevent stored XSS
+// text is kept as it was written, entities and lone < > & included, so content is stored the same way with or without tags
+// links keep only http, https and mailto hrefs and get rel="nofollow noopener"
+func SanitizeContent(content string) string {
+	// without a < there is no tag, comment or doctype
+	if !strings.Contains(content, "<") {
+		return content
+	}
+
+	var out bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(content))
+	// depth of dropped tags the tokenizer is currently inside
+	dropped := 0
+	for {
+		tt := z.Next()
+		// the reader never fails, so the only error is the end of the content
+		if tt == html.ErrorToken {
+			return out.String()
+		}
+		// a text token never holds the start of a tag, so its raw text is safe to keep
+		// it is copied before Token, which unescapes the text in place
+		raw := string(z.Raw())
+		token := z.Token()
+
+		switch tt {
+		case html.TextToken:
+			if dropped == 0 {
+				out.WriteString(raw)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedTags[token.Data] {
+				if tt == html.StartTagToken {
+					dropped++
+				}
+				continue
+			}
+			if dropped == 0 && allowedTags[token.Data] {
+				writeStartTag(&out, token)
+			}
+		case html.EndTagToken:
+			if droppedTags[token.Data] {
+				if dropped > 0 {
+					dropped--
+				}
+				continue
+			}
+			if dropped == 0 && allowedTags[token.Data] && token.Data != "br" {
+				out.WriteString("</" + token.Data + ">")
+			}
+		}
+		// comments and doctypes are dropped
+	}
+}
+
+// writes an allowed start tag, only the href of links is kept
+func writeStartTag(out *bytes.Buffer, token html.Token) {
+	out.WriteString("<" + token.Data)
+	if token.Data == "a" {
+		for _, attr := range token.Attr {
+			if attr.Key == "href" && safeLink(attr.Val) {
+				out.WriteString(` href="` + html.EscapeString(attr.Val) + `"`)
+				break
+			}
+		}
+		out.WriteString(` rel="nofollow noopener"`)
+	}
+	out.WriteString(">")
+}
+
+// checks that the link is absolute and uses an allowed scheme
+func safeLink(link string) bool {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return false
+	}
+	return allowedSchemes[strings.ToLower(u.Scheme)]
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/I1Asyl/berliner_backend/models"
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
 	"github.com/I1Asyl/berliner_backend/pkg/repository"
 )
 
@@ -12,10 +14,40 @@ import (
 // all authorization services
 type Authorization interface {
 	AddUser(user models.User) map[string]string
+	CreateUser(user models.User) map[string]string
 	HashPassword(password string) string
-	GenerateToken(user models.AuthorizationForm, issueTime time.Time, expireTime time.Time) (string, error)
-	ParseToken(token string) (string, error)
-	CheckUserAndPassword(userForm models.AuthorizationForm) (bool, error)
+	CheckPasswordPolicy(password string) []models.ValidationError
+	GenerateToken(user models.AuthorizationForm) (string, time.Time, error)
+	GenerateTokenPair(user models.AuthorizationForm, device string) (TokenPair, error)
+	SignInWithProvider(provider string, identity auth.Identity, device string) (TokenPair, error)
+	RefreshTokens(refreshToken string) (TokenPair, error)
+	RevokeRefreshToken(refreshToken string) error
+	RequestPasswordReset(email string) (string, error)
+	ResetPassword(token, newPassword string) map[string]string
+	ChangePassword(username, currentPassword, newPassword string) map[string]string
+	UpdateUser(username string, updates models.UserUpdate) map[string]string
+	DeleteUser(username string) error
+	GetSessions(username string, currentId int) ([]models.Session, error)
+	RevokeSession(username string, id int) error
+	RevokeOtherSessions(username string, currentId int) error
+	EnableMFA(username string) (MFASetup, error)
+	ConfirmMFA(username, code string) error
+	DisableMFA(username, code string) error
+	StartMFAChallenge(user models.AuthorizationForm, device string) (string, error)
+	VerifyMFA(token, code string) (TokenPair, error)
+	CreateAPIKey(form models.APIKeyForm) (string, models.APIKey, error)
+	GetAPIKeys() ([]models.APIKey, error)
+	RevokeAPIKey(id int) error
+	AuthenticateAPIKey(key string) (models.APIKey, error)
+	ParseToken(token string) (TokenClaims, error)
+	RevokeToken(token string) error
+	PruneRevokedTokens() (int64, error)
+	CheckUserAndPassword(userForm models.AuthorizationForm) (bool, bool, error)
+	GenerateVerificationToken(username string) (string, error)
+	ResendVerification(username string) (string, error)
+	VerifyEmail(token string) error
+	IsAdmin(username string) (bool, error)
+	SeedAdmins() ([]string, error)
 }
 
 // all api services
@@ -27,10 +59,34 @@ type Api interface {
 	DeleteChannel(channel models.Channel) error
 	UpdateChannel(channel models.Channel) error
 	GetFollowing(user models.User) ([]models.User, error)
+	GetInactiveFollowing(userId int, inactiveFor time.Duration) ([]models.User, error)
+	UnfollowInactive(user models.User, inactiveFor time.Duration) ([]models.User, error)
+	GetFollowers(user models.User) ([]models.User, error)
+	ExportUserPosts(userId int) ([]models.Post, error)
+	SearchPosts(viewer models.User, query string, limit, offset int) ([]models.Post, error)
+	SearchUsers(query string, limit, offset int) ([]models.User, error)
+	GetPostsByHashtags(viewer models.User, tags []string, mode string, limit, offset int) ([]models.Post, error)
+	GetChannelLeader(channelId int) (models.User, bool, error)
+	MuteUser(muter models.User, mutedId int) error
+	UnmuteUser(muter models.User, mutedId int) error
 	GetUserByUsername(username string) (models.User, error)
+	GetUserById(id int) (models.User, error)
 	GetChannelByName(name string) (models.Channel, error)
+	GetChannelById(id int) (models.Channel, error)
+	GetAllPublicChannels(limit, offset int) ([]models.Channel, error)
+	SetChannelActive(channelId int, active bool, actor models.User) error
+	SetAcceptingMembers(channelId int, accepting bool, actor models.User) error
+	RequestToJoin(channelId int, user models.User) error
+	CancelJoinRequest(channelId int, user models.User) error
+	RejectJoinRequest(channelId int, userId int, actor models.User) error
+	GetChannelRelationship(channelId int, user models.User) (models.ChannelRelationship, error)
+	GetPendingRequestCount(channelId int, actor models.User) (int, error)
+	GetAllPendingRequestsForLeader(userId int, limit, offset int) ([]models.RequestWithUser, error)
 	CreatePost(post models.Post, autthorId int) map[string]string
+	CreatePostAs(post models.Post, channelId int, actor models.User) map[string]string
+	CheckDailyPostQuota(userId int) (int, error)
 	DeletePost(post models.Post) error
+	DeletePosts(user models.User, authorType string, ids []int) (map[int]string, error)
 	GetPostsFromMyChannels(user models.User) ([]struct {
 		models.Channel
 		models.ChannelPost
@@ -39,6 +95,14 @@ type Api interface {
 		models.Channel
 		models.ChannelPost
 	}, error)
+	GetChannelFeed(user models.User, limit, offset int) ([]struct {
+		models.Channel
+		models.ChannelPost
+	}, error)
+	GetPeopleFeed(user models.User, limit, offset int) ([]struct {
+		models.User
+		models.UserPost
+	}, error)
 	GetPostsFromUsers(user models.User) ([]struct {
 		models.User
 		models.UserPost
@@ -51,9 +115,16 @@ type Api interface {
 		models.Channel
 		models.ChannelPost
 	}, error)
-	//GetAllPosts(user models.User) ([]models.Post, error)
+	GetAllPosts(user models.User, limit, offset int) ([]models.Post, error)
 	GetChannels(user models.User) ([]models.Channel, error)
 	CreateChannel(channel models.Channel, user models.User) map[string]string
+	GetInstanceStats() (models.InstanceStats, error)
+	GetInactiveChannels(inactiveFor time.Duration, limit int) ([]models.Channel, error)
+	SuggestChannels(userId int, limit int) ([]models.Channel, error)
+	GetMyChannelRoles(userId int) ([]models.ChannelRole, error)
+	GetUserTopHashtags(userId int, limit int) ([]models.TagCount, error)
+	GetFollowerGrowth(userId int, from, to time.Time, bucket string) ([]models.GrowthPoint, error)
+	GetChannelPostFrequency(channelId int, from, to time.Time, bucket string, actor models.User) ([]models.GrowthPoint, error)
 }
 
 // func clearAllData() {
@@ -64,9 +135,21 @@ type Api interface {
 type Services struct {
 	Authorization
 	Api
+	Clock
+}
+
+// applies the hot reloadable config to the services which read it
+func (s *Services) ApplyConfig(cfg config.Config) {
+	for _, service := range []interface{}{s.Authorization, s.Api} {
+		if reloadable, ok := service.(interface{ ApplyConfig(config.Config) }); ok {
+			reloadable.ApplyConfig(cfg)
+		}
+	}
 }
 
 // returns new Services with all needed authorization and api services
-func NewService(repo *repository.Repository) *Services {
-	return &Services{Authorization: NewAuthService(*repo), Api: NewApiService(*repo)}
+// the TOTP secrets are encrypted with a key derived from the jwt secret
+func NewService(repo *repository.Repository, clock Clock, tokens auth.TokenManager, cfg config.Config) *Services {
+	secrets := auth.NewSecretBox(cfg.JWT.Secret, "totp secrets")
+	return &Services{Authorization: NewAuthService(*repo, clock, tokens, cfg.Tokens.RefreshTTL, cfg.Admin, cfg.Auth, secrets), Api: NewApiService(*repo, clock, cfg.Posts, cfg.Channels), Clock: clock}
 }
@@ -4,6 +4,11 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
 	"github.com/I1Asyl/berliner_backend/pkg/handler"
 	"github.com/I1Asyl/berliner_backend/pkg/repository"
 	"github.com/I1Asyl/berliner_backend/pkg/services"
@@ -13,22 +18,80 @@ import (
 
 // Config holds the application configuration
 type Config struct {
+	App config.Config
 	DSN string
+	// when set, the DSN is fetched from it and again when the database credentials rotate
+	DSNSource repository.DSNFunc
+	Rotation  repository.RotationConfig
+	// when set, the services and the handler follow the hot reloaded config
+	Watcher *config.Watcher
+}
+
+// ProvideDB opens the connection pool shared by the repository, the health check and the pool metrics
+// the cleanup function closes it
+func ProvideDB(cfg Config) (*sql.DB, func(), error) {
+	pool := repository.PoolConfig{
+		MaxOpenConns:    cfg.App.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.App.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.App.DB.ConnMaxLifetime,
+	}
+	var db *sql.DB
+	var err error
+	if cfg.DSNSource != nil {
+		db, err = repository.OpenRotatingDB(context.Background(), cfg.DSNSource, cfg.Rotation, pool)
+	} else {
+		db, err = repository.OpenDB(cfg.DSN, pool)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	repository.PublishStats(db)
+	return db, func() {
+		db.Close()
+	}, nil
+}
+
+// ProvideRepository creates a new repository instance on the shared connection pool
+func ProvideRepository(db *sql.DB) *repository.Repository {
+	return repository.NewRepositoryFromDB(db)
+}
+
+// ProvideClock returns the clock used by the services
+func ProvideClock() services.Clock {
+	return services.RealClock{}
 }
 
-// ProvideRepository creates a new repository instance
-func ProvideRepository(config Config) *repository.Repository {
-	return repository.NewRepository(config.DSN)
+// ProvideTokenManager returns the token manager of the configured format
+func ProvideTokenManager(cfg Config, clock services.Clock) (auth.TokenManager, error) {
+	return auth.NewTokenManager(cfg.App.Tokens, cfg.App.JWT, clock)
 }
 
-// ProvideServices creates a new services instance
-func ProvideServices(repo *repository.Repository) *services.Services {
-	return services.NewService(repo)
+// ProvideServices creates a new services instance, seeds the first admins and starts pruning the revoked tokens which expired
+// the cleanup function stops the pruning
+func ProvideServices(repo *repository.Repository, clock services.Clock, tokens auth.TokenManager, cfg Config) (*services.Services, func()) {
+	services := services.NewService(repo, clock, tokens, cfg.App)
+	if cfg.Watcher != nil {
+		cfg.Watcher.Subscribe(services.ApplyConfig)
+	}
+	seedAdmins(services)
+	ctx, cancel := context.WithCancel(context.Background())
+	go pruneRevokedTokens(ctx, services, revokedTokensPruneInterval)
+	return services, cancel
 }
 
 // ProvideHandler creates a new handler instance
-func ProvideHandler(services *services.Services) *handler.Handler {
-	return handler.NewHandler(services)
+func ProvideHandler(services *services.Services, db *sql.DB, cfg Config) *handler.Handler {
+	limiter := handler.NewLoginLimiter(handler.NewMemoryAttemptStore(), cfg.App.Auth.RateLimit, services.Clock)
+	requestLimiter := handler.NewMemoryRequestLimiter(cfg.App.Auth.RequestLimit, services.Clock)
+	opts := []handler.Option{handler.WithLoginLimiter(limiter), handler.WithRequestLimiter(requestLimiter)}
+	if clientId := cfg.App.OAuth.Google.ClientId; clientId != "" {
+		opts = append(opts, handler.WithGoogleVerifier(auth.NewGoogleVerifier(clientId, auth.GoogleJWKSURL, services.Clock)))
+	}
+	handler := handler.NewHandler(services, db, cfg.App.Server, cfg.App.Pagination, opts...)
+	if cfg.Watcher != nil {
+		cfg.Watcher.Subscribe(handler.ApplyConfig)
+	}
+	return handler
 }
 
 // ProvideRouter creates a new Gin router
@@ -37,12 +100,15 @@ func ProvideRouter(handler *handler.Handler) *gin.Engine {
 }
 
 // InitializeApp wires up all dependencies and returns the router
-func InitializeApp(config Config) (*gin.Engine, error) {
+func InitializeApp(cfg Config) (*gin.Engine, func(), error) {
 	wire.Build(
+		ProvideDB,
 		ProvideRepository,
+		ProvideClock,
+		ProvideTokenManager,
 		ProvideServices,
 		ProvideHandler,
 		ProvideRouter,
 	)
-	return nil, nil
+	return nil, nil, nil
 }
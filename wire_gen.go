@@ -7,6 +7,11 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+
+	"github.com/I1Asyl/berliner_backend/pkg/auth"
+	"github.com/I1Asyl/berliner_backend/pkg/config"
 	"github.com/I1Asyl/berliner_backend/pkg/handler"
 	"github.com/I1Asyl/berliner_backend/pkg/repository"
 	"github.com/I1Asyl/berliner_backend/pkg/services"
@@ -16,34 +21,105 @@ import (
 // Injectors from wire.go:
 
 // InitializeApp wires up all dependencies and returns the router
-func InitializeApp(config Config) (*gin.Engine, error) {
-	repository := ProvideRepository(config)
-	services := ProvideServices(repository)
-	handler := ProvideHandler(services)
+func InitializeApp(cfg Config) (*gin.Engine, func(), error) {
+	db, cleanup, err := ProvideDB(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	repository := ProvideRepository(db)
+	clock := ProvideClock()
+	tokenManager, err := ProvideTokenManager(cfg, clock)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	services, cleanup2 := ProvideServices(repository, clock, tokenManager, cfg)
+	handler := ProvideHandler(services, db, cfg)
 	engine := ProvideRouter(handler)
-	return engine, nil
+	return engine, func() {
+		cleanup2()
+		cleanup()
+	}, nil
 }
 
 // wire.go:
 
 // Config holds the application configuration
 type Config struct {
+	App config.Config
 	DSN string
+	// when set, the DSN is fetched from it and again when the database credentials rotate
+	DSNSource repository.DSNFunc
+	Rotation  repository.RotationConfig
+	// when set, the services and the handler follow the hot reloaded config
+	Watcher *config.Watcher
+}
+
+// ProvideDB opens the connection pool shared by the repository, the health check and the pool metrics
+// the cleanup function closes it
+func ProvideDB(cfg Config) (*sql.DB, func(), error) {
+	pool := repository.PoolConfig{
+		MaxOpenConns:    cfg.App.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.App.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.App.DB.ConnMaxLifetime,
+	}
+	var db *sql.DB
+	var err error
+	if cfg.DSNSource != nil {
+		db, err = repository.OpenRotatingDB(context.Background(), cfg.DSNSource, cfg.Rotation, pool)
+	} else {
+		db, err = repository.OpenDB(cfg.DSN, pool)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	repository.PublishStats(db)
+	return db, func() {
+		db.Close()
+	}, nil
+}
+
+// ProvideRepository creates a new repository instance on the shared connection pool
+func ProvideRepository(db *sql.DB) *repository.Repository {
+	return repository.NewRepositoryFromDB(db)
+}
+
+// ProvideClock returns the clock used by the services
+func ProvideClock() services.Clock {
+	return services.RealClock{}
 }
 
-// ProvideRepository creates a new repository instance
-func ProvideRepository(config Config) *repository.Repository {
-	return repository.NewRepository(config.DSN)
+// ProvideTokenManager returns the token manager of the configured format
+func ProvideTokenManager(cfg Config, clock services.Clock) (auth.TokenManager, error) {
+	return auth.NewTokenManager(cfg.App.Tokens, cfg.App.JWT, clock)
 }
 
-// ProvideServices creates a new services instance
-func ProvideServices(repo *repository.Repository) *services.Services {
-	return services.NewService(repo)
+// ProvideServices creates a new services instance, seeds the first admins and starts pruning the revoked tokens which expired
+// the cleanup function stops the pruning
+func ProvideServices(repo *repository.Repository, clock services.Clock, tokens auth.TokenManager, cfg Config) (*services.Services, func()) {
+	services2 := services.NewService(repo, clock, tokens, cfg.App)
+	if cfg.Watcher != nil {
+		cfg.Watcher.Subscribe(services2.ApplyConfig)
+	}
+	seedAdmins(services2)
+	ctx, cancel := context.WithCancel(context.Background())
+	go pruneRevokedTokens(ctx, services2, revokedTokensPruneInterval)
+	return services2, cancel
 }
 
 // ProvideHandler creates a new handler instance
-func ProvideHandler(services2 *services.Services) *handler.Handler {
-	return handler.NewHandler(services2)
+func ProvideHandler(services2 *services.Services, db *sql.DB, cfg Config) *handler.Handler {
+	limiter := handler.NewLoginLimiter(handler.NewMemoryAttemptStore(), cfg.App.Auth.RateLimit, services2.Clock)
+	requestLimiter := handler.NewMemoryRequestLimiter(cfg.App.Auth.RequestLimit, services2.Clock)
+	opts := []handler.Option{handler.WithLoginLimiter(limiter), handler.WithRequestLimiter(requestLimiter)}
+	if clientId := cfg.App.OAuth.Google.ClientId; clientId != "" {
+		opts = append(opts, handler.WithGoogleVerifier(auth.NewGoogleVerifier(clientId, auth.GoogleJWKSURL, services2.Clock)))
+	}
+	handler2 := handler.NewHandler(services2, db, cfg.App.Server, cfg.App.Pagination, opts...)
+	if cfg.Watcher != nil {
+		cfg.Watcher.Subscribe(handler2.ApplyConfig)
+	}
+	return handler2
 }
 
 // ProvideRouter creates a new Gin router